@@ -0,0 +1,100 @@
+package main
+
+import "math"
+
+// adaptiveMinSamples - наименьшее число основных сэмплов пикселя при
+// адаптивном сэмплировании: по меньшему числу разброс не оценить.
+const adaptiveMinSamples = 4
+
+// adaptiveFloor - яркость, ниже которой погрешность отсчитывается от нее,
+// а не от среднего: иначе почти черные пиксели получали бы все MaxSamples
+// сэмплов ради невидимого шума.
+const adaptiveFloor = 0.05
+
+// sampleNoise накапливает среднее и дисперсию яркости сэмплов пикселя
+// (алгоритм Уэлфорда).
+type sampleNoise struct {
+	n    int
+	mean float64
+	m2   float64 // Сумма квадратов отклонений от среднего
+}
+
+func (s *sampleNoise) add(x float64) {
+	s.n++
+	d := x - s.mean
+	s.mean += d / float64(s.n)
+	s.m2 += d * (x - s.mean)
+}
+
+// relError возвращает стандартную ошибку среднего яркости в долях
+// допустимой: noise относительно среднего.
+func (s *sampleNoise) relError(noise float64) float64 {
+	if s.n < 2 {
+		return 0
+	}
+	stderr := math.Sqrt(s.m2 / float64(s.n-1) / float64(s.n))
+	return stderr / (noise * math.Max(s.mean, adaptiveFloor))
+}
+
+// adaptive сообщает, что пиксели получают разное число сэмплов.
+func (p *pixelRenderer) adaptive() bool {
+	return p.maxSamples > p.samples
+}
+
+// adaptiveRect - адаптивный рендер прямоугольника кадра в два прохода.
+// Первый проход дает каждому пикселю основные сэмплы и оценивает их
+// погрешность, второй добавляет сэмплы, пока погрешность не станет
+// допустимой, но не больше MaxSamples.
+//
+// Число добавочных сэмплов определяет худший пиксель окрестности 3x3: у
+// одиночного пикселя основные сэмплы могут случайно совпасть, и тогда
+// зашумленный пиксель выглядит чистым, а соседи его выдают. Поэтому первый
+// проход захватывает рамку в пиксель вокруг прямоугольника, и тайлы кадра
+// получаются такими же, как при рендере кадра целиком.
+type adaptiveRect struct {
+	x0, y0, x1, y1 int // Прямоугольник с рамкой, обрезанный по кадру
+	base           []pixelSamples
+	errs           []float64 // relError основных сэмплов
+}
+
+// newAdaptiveRect готовит адаптивный рендер прямоугольника t.
+func (p *pixelRenderer) newAdaptiveRect(t *Tile) *adaptiveRect {
+	a := &adaptiveRect{
+		x0: max(t.X-1, 0), y0: max(t.Y-1, 0),
+		x1: min(t.X+t.Width+1, p.width), y1: min(t.Y+t.Height+1, p.height),
+	}
+	n := (a.x1 - a.x0) * (a.y1 - a.y0)
+	a.base = make([]pixelSamples, n)
+	a.errs = make([]float64, n)
+	return a
+}
+
+// sampleRow выполняет первый проход для строки j прямоугольника с рамкой.
+func (a *adaptiveRect) sampleRow(p *pixelRenderer, j int, ps *pixelSampler) {
+	for i := a.x0; i < a.x1; i++ {
+		k := (j-a.y0)*(a.x1-a.x0) + i - a.x0
+		a.base[k] = pixelSamples{}
+		p.sample(&a.base[k], i, j, p.samples, 0, ps)
+		a.errs[k] = a.base[k].noise.relError(p.opts.Noise)
+	}
+}
+
+// refinePixel выполняет второй проход для пикселя (i, j) и возвращает его цвет.
+// Первый проход должен быть завершен для всех строк прямоугольника.
+func (a *adaptiveRect) refinePixel(p *pixelRenderer, i, j int, ps *pixelSampler) Vec3f {
+	worst := 0.0
+	for y := max(j-1, a.y0); y <= min(j+1, a.y1-1); y++ {
+		for x := max(i-1, a.x0); x <= min(i+1, a.x1-1); x++ {
+			worst = math.Max(worst, a.errs[(y-a.y0)*(a.x1-a.x0)+x-a.x0])
+		}
+	}
+	// Добавочные сэмплы не попадают в base, чтобы второй проход можно было
+	// повторить (см. isolate)
+	s := a.base[(j-a.y0)*(a.x1-a.x0)+i-a.x0]
+	// Погрешность убывает как корень из числа сэмплов
+	target := float64(s.noise.n) * worst * worst
+	if extra := int(math.Min(math.Ceil(target), float64(p.maxSamples))) - s.noise.n; extra > 0 {
+		p.sample(&s, i, j, extra, 1, ps)
+	}
+	return p.finish(&s, i, j, ps)
+}
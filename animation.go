@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// VecKey - значение векторного параметра в кадре Frame. Ease задает
+// характер перехода от этого ключа к следующему (см. easings).
+type VecKey struct {
+	Frame float64 `json:"frame"`
+	Value Vec3f   `json:"value"`
+	Ease  string  `json:"ease,omitempty"`
+}
+
+// FloatKey - значение скалярного параметра в кадре Frame.
+type FloatKey struct {
+	Frame float64 `json:"frame"`
+	Value float64 `json:"value"`
+	Ease  string  `json:"ease,omitempty"`
+}
+
+// easings - функции сглаживания перехода между ключами: доля пути по
+// времени t из [0, 1] превращается в долю изменения значения.
+var easings = map[string]func(t float64) float64{
+	"linear":      func(t float64) float64 { return t },
+	"ease-in":     func(t float64) float64 { return t * t },
+	"ease-out":    func(t float64) float64 { return t * (2 - t) },
+	"ease-in-out": func(t float64) float64 { return t * t * (3 - 2*t) },
+	"step":        func(t float64) float64 { return 0 }, // Значение держится до следующего ключа
+}
+
+// ease применяет к доле пути t сглаживание с именем name ("" - линейное).
+func ease(name string, t float64) float64 {
+	if f := easings[name]; f != nil {
+		return f(t)
+	}
+	return t
+}
+
+// ObjectAnimation - ключевые кадры примитива. Дорожки Translate, Rotate
+// и Scale задают преобразование объекта (см. Transform) и заменяют
+// соответствующие его составляющие.
+type ObjectAnimation struct {
+	Center    []VecKey `json:"center,omitempty"`
+	Translate []VecKey `json:"translate,omitempty"`
+	Rotate    []VecKey `json:"rotate,omitempty"` // В градусах
+	Scale     []VecKey `json:"scale,omitempty"`
+}
+
+// LightAnimation - ключевые кадры источника света.
+type LightAnimation struct {
+	Position  []VecKey   `json:"position,omitempty"`
+	Intensity []FloatKey `json:"intensity,omitempty"`
+}
+
+// CameraAnimation - ключевые кадры камеры.
+type CameraAnimation struct {
+	Position      []VecKey   `json:"position,omitempty"`
+	LookAt        []VecKey   `json:"lookAt,omitempty"`
+	FOV           []FloatKey `json:"fov,omitempty"`
+	Aperture      []FloatKey `json:"aperture,omitempty"`
+	FocalDistance []FloatKey `json:"focalDistance,omitempty"`
+}
+
+// segment находит ключи, между которыми лежит кадр frame, и долю пути между
+// ними. До первого и после последнего ключа значение не меняется.
+func segment(n int, frameAt func(int) float64, frame float64) (int, int, float64) {
+	k := sort.Search(n, func(i int) bool { return frameAt(i) > frame })
+	switch {
+	case k == 0:
+		return 0, 0, 0
+	case k == n:
+		return n - 1, n - 1, 0
+	}
+	a, b := frameAt(k-1), frameAt(k)
+	return k - 1, k, (frame - a) / (b - a)
+}
+
+// sampleVec интерполирует векторный параметр. Если ключей нет,
+// возвращается def.
+func sampleVec(keys []VecKey, frame float64, def Vec3f) Vec3f {
+	if len(keys) == 0 {
+		return def
+	}
+	i, j, t := segment(len(keys), func(i int) float64 { return keys[i].Frame }, frame)
+	t = ease(keys[i].Ease, t)
+	return keys[i].Value.MulScalar(1 - t).Add(keys[j].Value.MulScalar(t))
+}
+
+// sampleFloat интерполирует скалярный параметр. Если ключей нет,
+// возвращается def.
+func sampleFloat(keys []FloatKey, frame float64, def float64) float64 {
+	if len(keys) == 0 {
+		return def
+	}
+	i, j, t := segment(len(keys), func(i int) float64 { return keys[i].Frame }, frame)
+	t = ease(keys[i].Ease, t)
+	return keys[i].Value*(1-t) + keys[j].Value*t
+}
+
+// tracks возвращает все дорожки ключевых кадров сцены.
+func (s *Scene) tracks() (vec [][]VecKey, float [][]FloatKey) {
+	for _, a := range s.objectAnimations() {
+		vec = append(vec, a.Center, a.Translate, a.Rotate, a.Scale)
+	}
+	for _, light := range s.Lights {
+		if a := light.Animation; a != nil {
+			vec = append(vec, a.Position)
+			float = append(float, a.Intensity)
+		}
+	}
+	if a := s.Camera.Animation; a != nil {
+		vec = append(vec, a.Position, a.LookAt)
+		float = append(float, a.FOV, a.Aperture, a.FocalDistance)
+	}
+	return vec, float
+}
+
+// sortKeys упорядочивает ключевые кадры сцены по номеру кадра.
+func (s *Scene) sortKeys() {
+	vec, float := s.tracks()
+	for _, keys := range vec {
+		sort.SliceStable(keys, func(i, j int) bool { return keys[i].Frame < keys[j].Frame })
+	}
+	for _, keys := range float {
+		sort.SliceStable(keys, func(i, j int) bool { return keys[i].Frame < keys[j].Frame })
+	}
+}
+
+// checkKeys проверяет имена сглаживаний в ключевых кадрах сцены.
+func (s *Scene) checkKeys() error {
+	check := func(name string) error {
+		if _, ok := easings[name]; name != "" && !ok {
+			return fmt.Errorf("unknown ease %q (want one of %s)", name, strings.Join(easingNames(), ", "))
+		}
+		return nil
+	}
+	vec, float := s.tracks()
+	for _, keys := range vec {
+		for _, k := range keys {
+			if err := check(k.Ease); err != nil {
+				return err
+			}
+		}
+	}
+	for _, keys := range float {
+		for _, k := range keys {
+			if err := check(k.Ease); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// easingNames возвращает имена сглаживаний в алфавитном порядке.
+func easingNames() []string {
+	names := make([]string, 0, len(easings))
+	for name := range easings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// atFrame возвращает копию сцены с параметрами, вычисленными для кадра frame.
+// Ресурсы (текстуры, карта окружения) разделяются с исходной сценой.
+func (s *Scene) atFrame(frame float64) *Scene {
+	out := *s
+	out.Spheres = slices.Clone(s.Spheres)
+	for i := range out.Spheres {
+		o := &out.Spheres[i]
+		o.Motion = shutterMotion(o.Motion, o.Center, o.Transform, o.Animation, frame, s.Camera.Shutter)
+		animateObject(&o.Center, &o.Transform, o.Animation, frame)
+	}
+	out.Cylinders = slices.Clone(s.Cylinders)
+	for i := range out.Cylinders {
+		o := &out.Cylinders[i]
+		o.Motion = shutterMotion(o.Motion, o.Center, o.Transform, o.Animation, frame, s.Camera.Shutter)
+		animateObject(&o.Center, &o.Transform, o.Animation, frame)
+	}
+	out.Cones = slices.Clone(s.Cones)
+	for i := range out.Cones {
+		o := &out.Cones[i]
+		o.Motion = shutterMotion(o.Motion, o.Center, o.Transform, o.Animation, frame, s.Camera.Shutter)
+		animateObject(&o.Center, &o.Transform, o.Animation, frame)
+	}
+	out.Tori = slices.Clone(s.Tori)
+	for i := range out.Tori {
+		o := &out.Tori[i]
+		o.Motion = shutterMotion(o.Motion, o.Center, o.Transform, o.Animation, frame, s.Camera.Shutter)
+		animateObject(&o.Center, &o.Transform, o.Animation, frame)
+	}
+
+	out.Lights = make([]PointLight, len(s.Lights))
+	for i, light := range s.Lights {
+		if a := light.Animation; a != nil {
+			light.Position = sampleVec(a.Position, frame, light.Position)
+			light.Intensity = sampleFloat(a.Intensity, frame, light.Intensity)
+		}
+		out.Lights[i] = light
+	}
+	if a := s.Camera.Animation; a != nil {
+		out.Camera.Position = sampleVec(a.Position, frame, s.Camera.Position)
+		if len(a.LookAt) > 0 {
+			lookAt := sampleVec(a.LookAt, frame, Vec3f{})
+			out.Camera.LookAt = &lookAt
+		}
+		out.Camera.FOV = sampleFloat(a.FOV, frame, s.Camera.FOV)
+		out.Camera.Aperture = sampleFloat(a.Aperture, frame, s.Camera.Aperture)
+		out.Camera.FocalDistance = sampleFloat(a.FocalDistance, frame, s.Camera.FocalDistance)
+		if s.Camera.Shutter > 0 {
+			out.Camera.Motion = nil
+			if m := sampleVec(a.Position, frame+s.Camera.Shutter, s.Camera.Position).Subtract(out.Camera.Position); m.Length2() > 0 {
+				out.Camera.Motion = &m
+			}
+		}
+	}
+	out.build()
+	return &out
+}
+
+// animateObject заменяет центр и преобразование примитива значениями из
+// анимации a для кадра frame. Преобразование исходной сцены не меняется:
+// кадр получает его копию.
+func animateObject(center *Vec3f, transform **Transform, a *ObjectAnimation, frame float64) {
+	if a == nil {
+		return
+	}
+	*center = sampleVec(a.Center, frame, *center)
+	if len(a.Translate) == 0 && len(a.Rotate) == 0 && len(a.Scale) == 0 {
+		return
+	}
+	var t Transform
+	if *transform != nil {
+		t = **transform
+	}
+	t.Translate = sampleVec(a.Translate, frame, t.Translate)
+	t.Rotate = sampleVec(a.Rotate, frame, t.Rotate)
+	if len(a.Scale) > 0 {
+		scale := Vec3f{1, 1, 1}
+		if t.Scale != nil {
+			scale = *t.Scale
+		}
+		scale = sampleVec(a.Scale, frame, scale)
+		t.Scale = &scale
+	}
+	*transform = &t
+}
+
+// objectAnimations возвращает анимации всех примитивов сцены.
+func (s *Scene) objectAnimations() []*ObjectAnimation {
+	var out []*ObjectAnimation
+	add := func(a *ObjectAnimation) {
+		if a != nil {
+			out = append(out, a)
+		}
+	}
+	for _, o := range s.Spheres {
+		add(o.Animation)
+	}
+	for _, o := range s.Cylinders {
+		add(o.Animation)
+	}
+	for _, o := range s.Cones {
+		add(o.Animation)
+	}
+	for _, o := range s.Tori {
+		add(o.Animation)
+	}
+	return out
+}
+
+// framePath возвращает имя файла кадра: result.png -> result_0001.png.
+func framePath(path string, frame int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s_%04d%s", strings.TrimSuffix(path, ext), frame, ext)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// aovNames - поддерживаемые вспомогательные проходы (AOV).
+var aovNames = []string{"position", "curvature", "depth", "normal", "albedo", "id"}
+
+// AOVBuffers хранит данные о попаданиях первичных лучей (через центры
+// пикселей), из которых строятся вспомогательные проходы.
+type AOVBuffers struct {
+	Width, Height int
+	Hit           []bool
+	Position      []Vec3f   // Мировые координаты точки попадания
+	Normal        []Vec3f   // Нормаль в точке попадания
+	Albedo        []Vec3f   // Цвет поверхности в точке попадания, с учетом текстуры
+	Depth         []float64 // Расстояние от камеры до точки попадания
+	ID            []int32   // Номер объекта в Scene.objects
+
+	ids map[Hittable]int32
+}
+
+// newAOVBuffers создает буферы для кадра сцены с объектами objects.
+func newAOVBuffers(width, height int, objects []Hittable) *AOVBuffers {
+	ids := make(map[Hittable]int32, len(objects))
+	for i, obj := range objects {
+		ids[obj] = int32(i)
+	}
+	return &AOVBuffers{
+		Width:    width,
+		Height:   height,
+		Hit:      make([]bool, width*height),
+		Position: make([]Vec3f, width*height),
+		Normal:   make([]Vec3f, width*height),
+		Albedo:   make([]Vec3f, width*height),
+		Depth:    make([]float64, width*height),
+		ID:       make([]int32, width*height),
+		ids:      ids,
+	}
+}
+
+// parseAOVs разбирает список проходов, перечисленных через запятую.
+func parseAOVs(list string) ([]string, error) {
+	var aovs []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(aovNames, name) {
+			return nil, fmt.Errorf("unknown aov %q", name)
+		}
+		aovs = append(aovs, name)
+	}
+	return aovs, nil
+}
+
+// aovPath возвращает имя файла прохода: result.png -> result_position.png.
+func aovPath(path, name string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "_" + name + ext
+}
+
+// recordRow сохраняет попадания первичных лучей строки j: hits[i] -
+// пересечение луча rays[i] пикселя (i, j).
+func (a *AOVBuffers) recordRow(j int, rays []Ray, hits []Hit) {
+	for i, h := range hits {
+		if h.Object == nil {
+			continue
+		}
+		k := j*a.Width + i
+		a.Hit[k] = true
+		a.Position[k] = rays[i].Origin.Add(rays[i].Dir.MulScalar(h.Dist))
+		a.Normal[k] = h.Object.normalAt(a.Position[k])
+		a.Albedo[k] = surfaceColor(h.Object, a.Position[k])
+		a.Depth[k] = h.Dist
+		a.ID[k] = a.ids[h.Object]
+	}
+}
+
+// curvature оценивает кривизну поверхности в каждом пикселе по изменению
+// нормали между соседними пикселями: k = (dN·dP)/|dP|^2. Для сферы радиуса R
+// это дает 1/R; выпуклые поверхности положительны, вогнутые - отрицательны.
+func (a *AOVBuffers) curvature() []float64 {
+	curv := make([]float64, a.Width*a.Height)
+	for j := 0; j < a.Height; j++ {
+		for i := 0; i < a.Width; i++ {
+			k := j*a.Width + i
+			if !a.Hit[k] {
+				continue
+			}
+			sum, n := 0.0, 0
+			for _, d := range [][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+				x, y := i+d[0], j+d[1]
+				if x < 0 || y < 0 || x >= a.Width || y >= a.Height || !a.Hit[y*a.Width+x] {
+					continue
+				}
+				dP := a.Position[y*a.Width+x].Subtract(a.Position[k])
+				dN := a.Normal[y*a.Width+x].Subtract(a.Normal[k])
+				// Соседи через разрыв глубины (другой объект) не учитываются
+				if l2 := dP.Length2(); l2 > 0 && dN.Length2() < 0.25 {
+					sum += dN.Dot(dP) / l2
+					n++
+				}
+			}
+			if n > 0 {
+				curv[k] = sum / float64(n)
+			}
+		}
+	}
+	return curv
+}
+
+// framebuffer возвращает проход без нормировки - для HDR-форматов.
+func (a *AOVBuffers) framebuffer(name string) *Framebuffer {
+	fb := NewFramebuffer(a.Width, a.Height)
+	switch name {
+	case "position":
+		for k, p := range a.Position {
+			if a.Hit[k] {
+				fb.Pixels[k] = p
+			}
+		}
+	case "curvature":
+		for k, c := range a.curvature() {
+			fb.Pixels[k] = Vec3f{c, c, c}
+		}
+	case "depth":
+		// У фона глубина 0: бесконечность не записать в RGBE
+		for k, d := range a.Depth {
+			if a.Hit[k] {
+				fb.Pixels[k] = Vec3f{d, d, d}
+			}
+		}
+	case "normal":
+		for k, n := range a.Normal {
+			if a.Hit[k] {
+				fb.Pixels[k] = n
+			}
+		}
+	case "albedo":
+		for k, c := range a.Albedo {
+			if a.Hit[k] {
+				fb.Pixels[k] = c
+			}
+		}
+	case "id":
+		// Номер объекта, начиная с 1; 0 - фон
+		for k, id := range a.ID {
+			if a.Hit[k] {
+				v := float64(id + 1)
+				fb.Pixels[k] = Vec3f{v, v, v}
+			}
+		}
+	}
+	return fb
+}
+
+// idColor возвращает цвет объекта с номером id в маске объектов: оттенки
+// соседних номеров далеки друг от друга.
+func idColor(id int32) Vec3f {
+	h := float64(id) * (math.Sqrt(5) - 1) / 2
+	return hsvColor(h-math.Floor(h), 0.7, 0.9)
+}
+
+// image строит изображение прохода. Позиция нормируется на габариты видимой
+// части сцены, кривизна - на максимальный модуль (0.5 - плоская поверхность),
+// глубина - на диапазон логарифмов видимых расстояний (ближнее - белое), нормаль
+// переводится из [-1, 1] в [0, 1], объекты маски получают разные цвета.
+// Фон во всех проходах черный.
+func (a *AOVBuffers) image(name string) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, a.Width, a.Height))
+	switch name {
+	case "position":
+		lo := Vec3f{math.Inf(1), math.Inf(1), math.Inf(1)}
+		hi := lo.Negate()
+		for k, p := range a.Position {
+			if a.Hit[k] {
+				lo = Vec3f{math.Min(lo.X, p.X), math.Min(lo.Y, p.Y), math.Min(lo.Z, p.Z)}
+				hi = Vec3f{math.Max(hi.X, p.X), math.Max(hi.Y, p.Y), math.Max(hi.Z, p.Z)}
+			}
+		}
+		scale := func(v, lo, hi float64) float64 {
+			if hi <= lo {
+				return 0
+			}
+			return (v - lo) / (hi - lo)
+		}
+		for k, p := range a.Position {
+			if a.Hit[k] {
+				img.Set(k%a.Width, k/a.Width, colorToRGBA(Vec3f{scale(p.X, lo.X, hi.X), scale(p.Y, lo.Y, hi.Y), scale(p.Z, lo.Z, hi.Z)}))
+			} else {
+				img.Set(k%a.Width, k/a.Width, color.RGBA{A: 255})
+			}
+		}
+	case "curvature":
+		curv := a.curvature()
+		maxAbs := 0.0
+		for _, c := range curv {
+			maxAbs = math.Max(maxAbs, math.Abs(c))
+		}
+		for k, c := range curv {
+			v := 0.5
+			if maxAbs > 0 {
+				v += 0.5 * c / maxAbs
+			}
+			if !a.Hit[k] {
+				v = 0
+			}
+			img.Set(k%a.Width, k/a.Width, colorToRGBA(Vec3f{v, v, v}))
+		}
+	case "depth":
+		// Шкала логарифмическая: иначе далекая у горизонта плоскость
+		// сжимает все остальные расстояния в один оттенок
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for k, d := range a.Depth {
+			if a.Hit[k] {
+				lo, hi = math.Min(lo, math.Log(d)), math.Max(hi, math.Log(d))
+			}
+		}
+		for k, d := range a.Depth {
+			v := 0.0
+			if a.Hit[k] {
+				v = 1
+				if hi > lo {
+					v = 1 - (math.Log(d)-lo)/(hi-lo)
+				}
+			}
+			img.Set(k%a.Width, k/a.Width, colorToRGBA(Vec3f{v, v, v}))
+		}
+	default:
+		// Цветные проходы
+		for k := range a.Hit {
+			var c Vec3f
+			if a.Hit[k] {
+				switch name {
+				case "normal":
+					c = a.Normal[k].Add(Vec3f{1, 1, 1}).MulScalar(0.5)
+				case "albedo":
+					c = a.Albedo[k]
+				case "id":
+					c = idColor(a.ID[k])
+				}
+			}
+			img.Set(k%a.Width, k/a.Width, colorToRGBA(c))
+		}
+	}
+	return img
+}
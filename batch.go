@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// batchJob - сцена пакетного рендера и файл, куда сохраняется результат.
+type batchJob struct {
+	Scene  string
+	Output string
+}
+
+// batchReport - итог рендера одной сцены пакета.
+type batchReport struct {
+	batchJob
+	Rays     int64
+	Duration time.Duration
+	Err      error
+}
+
+// readManifest читает список сцен: по одной на строку, за путем к сцене может
+// следовать имя выходного файла. Пустые строки и строки с # пропускаются.
+// Относительные пути считаются от каталога манифеста.
+func readManifest(path string, defaultOutput string) ([]batchJob, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	dir := filepath.Dir(path)
+	var jobs []batchJob
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		job := batchJob{Scene: resolvePath(dir, fields[0])}
+		if len(fields) > 1 {
+			job.Output = resolvePath(dir, fields[1])
+		} else {
+			job.Output = batchOutput(job.Scene, defaultOutput)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, scanner.Err()
+}
+
+// batchOutput возвращает имя результата для сцены: файл с именем сцены
+// в каталоге и формате -out (scenes/a.json, out/result.png -> out/a.png).
+func batchOutput(scenePath, output string) string {
+	name := strings.TrimSuffix(filepath.Base(scenePath), filepath.Ext(scenePath))
+	return filepath.Join(filepath.Dir(output), name+filepath.Ext(output))
+}
+
+// renderBatch рендерит сцены по очереди на общем пуле горутин. Ошибка в одной
+// сцене не останавливает остальные; отмена ctx прерывает весь пакет.
+func renderBatch(ctx context.Context, jobs []batchJob, opts RenderOptions, prepare func(*Scene)) []batchReport {
+	pool := NewWorkerPool(runtime.NumCPU())
+	defer pool.Close()
+	opts.Pool = pool
+
+	reports := make([]batchReport, 0, len(jobs))
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+		report := batchReport{batchJob: job}
+		scene, err := LoadScene(job.Scene)
+		if err == nil {
+			prepare(scene)
+			jobOpts := opts
+			jobOpts.Output = job.Output
+			jobOpts.Scene = job.Scene
+			if err = checkOutputPath(job.Output); err == nil {
+				var res *RenderResult
+				res, err = render(ctx, scene, jobOpts)
+				if res != nil {
+					report.Rays, report.Duration = res.Rays, res.Duration
+				}
+			}
+		}
+		report.Err = err
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// printBatchReport выводит сводную таблицу по пакету.
+func printBatchReport(w io.Writer, reports []batchReport) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCENE\tOUTPUT\tTIME\tRAYS\tSTATUS")
+	var total time.Duration
+	failed := 0
+	for _, r := range reports {
+		status := "ok"
+		if r.Err != nil {
+			status = r.Err.Error()
+			failed++
+		}
+		total += r.Duration
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Scene, r.Output, r.Duration.Round(time.Millisecond), formatCount(float64(r.Rays)), status)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d scenes, %d failed, total %s\n", len(reports), failed, total.Round(time.Millisecond))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"testing"
+)
+
+// benchScenes - эталонные сцены для замеров производительности.
+var benchScenes = []struct {
+	name  string
+	scene func() *Scene
+}{
+	{"default", defaultScene},
+	{"primitives", primitivesScene},
+	{"spheres", spheresScene},
+}
+
+// benchKernels - ядра пересечений, с которыми собираются сцены замеров.
+var benchKernels = []string{"portable", "packed"}
+
+// forEachScene запускает f подзамером на каждой эталонной сцене,
+// собранной с каждым ядром пересечений.
+func forEachScene(b *testing.B, f func(b *testing.B, scene *Scene)) {
+	defer selectKernel(intersectKernel)
+	for _, kernel := range benchKernels {
+		selectKernel(kernel)
+		for _, bs := range benchScenes {
+			scene := bs.scene()
+			b.Run(bs.name+"/"+kernel, func(b *testing.B) { f(b, scene) })
+		}
+	}
+}
+
+// frameRays возвращает первичные лучи кадра 64x48 из камеры сцены.
+func frameRays(scene *Scene) []Ray {
+	eye := scene.Camera.Position
+	var rays []Ray
+	for j := 0; j < 48; j++ {
+		for i := 0; i < 64; i++ {
+			x, y := float64(i)/32-1, 1-float64(j)/24
+			rays = append(rays, newRay(eye, Vec3f{x, y * 0.75, -1}.Normalize()))
+		}
+	}
+	return rays
+}
+
+// BenchmarkRayIntersect замеряет пересечение луча с первым объектом каждого
+// типа в эталонных сценах; луч идет из камеры в центр объекта, поэтому
+// проверяется случай попадания.
+func BenchmarkRayIntersect(b *testing.B) {
+	for _, bs := range benchScenes {
+		scene := bs.scene()
+		eye := scene.Camera.Position
+		seen := map[string]bool{}
+		for _, obj := range scene.objects {
+			box := obj.bounds()
+			kind := strings.TrimPrefix(fmt.Sprintf("%T", obj), "*main.")
+			if !box.isFinite() || seen[kind] {
+				continue
+			}
+			seen[kind] = true
+			dir := box.center().Subtract(eye).Normalize()
+			b.Run(bs.name+"/"+kind, func(b *testing.B) {
+				for n := 0; n < b.N; n++ {
+					obj.RayIntersect(eye, dir)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkIntersect замеряет пересечение первичных лучей кадра со сценой
+// по одному лучу.
+func BenchmarkIntersect(b *testing.B) {
+	forEachScene(b, func(b *testing.B, scene *Scene) {
+		rays := frameRays(scene)
+		for n := 0; n < b.N; n++ {
+			for _, r := range rays {
+				scene.intersect(r)
+			}
+		}
+	})
+}
+
+// BenchmarkIntersectMany замеряет пересечение первичных лучей кадра со
+// сценой пачкой.
+func BenchmarkIntersectMany(b *testing.B) {
+	forEachScene(b, func(b *testing.B, scene *Scene) {
+		rays := frameRays(scene)
+		hits := make([]Hit, len(rays))
+		for n := 0; n < b.N; n++ {
+			scene.intersectMany(rays, hits)
+		}
+	})
+}
+
+// BenchmarkCastRay замеряет вычисление цвета одного луча из камеры.
+func BenchmarkCastRay(b *testing.B) {
+	forEachScene(b, func(b *testing.B, scene *Scene) {
+		rng := rand.New(rand.NewPCG(0, 0))
+		ray := newRay(scene.Camera.Position, Vec3f{0, 0, -1})
+		for n := 0; n < b.N; n++ {
+			castRay(ray, scene, 200, rng)
+		}
+	})
+}
+
+// BenchmarkRenderFrame замеряет рендер целого кадра каждым интегратором.
+func BenchmarkRenderFrame(b *testing.B) {
+	for _, integrator := range []string{"whitted", "path"} {
+		opts := RenderOptions{Depth: 200, Samples: 1, Integrator: integrator}
+		b.Run(integrator, func(b *testing.B) {
+			forEachScene(b, func(b *testing.B, scene *Scene) {
+				var rays int64
+				for n := 0; n < b.N; n++ {
+					res, err := Render(context.Background(), scene, opts)
+					if err != nil {
+						b.Fatal(err)
+					}
+					rays += res.Rays
+				}
+				b.ReportMetric(float64(rays)/b.Elapsed().Seconds(), "rays/s")
+			})
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// SceneBuilder собирает сцену из программы цепочкой вызовов:
+//
+//	scene, err := NewSceneBuilder().
+//		AddSphere(Vec3f{0, 0, -5}, 1, NewMaterial(Vec3f{0.8, 0.2, 0.2}, 0.7, 50)).
+//		AddPlane(Vec3f{0, -1, 0}, Vec3f{0, 1, 0}, NewMaterial(Vec3f{0.5, 0.5, 0.5}, 0.9, 10)).
+//		AddLight(Vec3f{-5, 8, 2}, 1.5).
+//		Camera(Vec3f{0, 0, 0}, 60).
+//		Build()
+//
+// Методы ошибок не возвращают: сцена проверяется в Build так же, как файл
+// сцены при загрузке. Построенную сцену можно сохранить в файл сцены
+// (Scene.Save).
+type SceneBuilder struct {
+	scene Scene
+	dir   string // Каталог, от которого считаются пути к текстурам и карте окружения
+	last  string // Тип последнего добавленного примитива (для Transform)
+	err   error  // Первая ошибка цепочки
+}
+
+// NewSceneBuilder возвращает построитель пустой сцены с фоном по умолчанию.
+// Пути к файлам считаются от текущего каталога.
+func NewSceneBuilder() *SceneBuilder {
+	return &SceneBuilder{scene: Scene{Background: Vec3f{0.2, 0.7, 0.8}}, dir: "."}
+}
+
+// NewMaterial возвращает материал по модели Фонга: albedo - доля
+// диффузного отражения, остальное отражается зеркально.
+func NewMaterial(color Vec3f, albedo, specularExponent float64) Material {
+	return Material{Color: color, Albedo: albedo, SpecularExponent: specularExponent}
+}
+
+// NewGGXMaterial возвращает материал с микрогранями GGX (см. Material.BRDF).
+func NewGGXMaterial(color Vec3f, roughness, metalness float64) Material {
+	return Material{Color: color, BRDF: "ggx", Roughness: roughness, Metalness: metalness}
+}
+
+// fail запоминает первую ошибку цепочки.
+func (b *SceneBuilder) fail(format string, args ...any) *SceneBuilder {
+	if b.err == nil {
+		b.err = fmt.Errorf(format, args...)
+	}
+	return b
+}
+
+func (b *SceneBuilder) AddSphere(center Vec3f, radius float64, m Material) *SceneBuilder {
+	sphere := Sphere{Center: center, Radius: radius, Material: m}
+	if err := sphere.checkShape(); err != nil {
+		return b.fail("sphere %d: %v", len(b.scene.Spheres), err)
+	}
+	b.scene.Spheres = append(b.scene.Spheres, sphere)
+	b.last = "sphere"
+	return b
+}
+
+// AddCylinder добавляет цилиндр с серединой оси center.
+func (b *SceneBuilder) AddCylinder(center Vec3f, radius, height float64, m Material) *SceneBuilder {
+	cylinder := Cylinder{Center: center, Radius: radius, Height: height, Material: m}
+	if err := cylinder.checkShape(); err != nil {
+		return b.fail("cylinder %d: %v", len(b.scene.Cylinders), err)
+	}
+	b.scene.Cylinders = append(b.scene.Cylinders, cylinder)
+	b.last = "cylinder"
+	return b
+}
+
+// AddCone добавляет конус с центром основания center.
+func (b *SceneBuilder) AddCone(center Vec3f, radius, height float64, m Material) *SceneBuilder {
+	cone := Cone{Center: center, Radius: radius, Height: height, Material: m}
+	if err := cone.checkShape(); err != nil {
+		return b.fail("cone %d: %v", len(b.scene.Cones), err)
+	}
+	b.scene.Cones = append(b.scene.Cones, cone)
+	b.last = "cone"
+	return b
+}
+
+func (b *SceneBuilder) AddTorus(center Vec3f, majorRadius, minorRadius float64, m Material) *SceneBuilder {
+	torus := Torus{Center: center, MajorRadius: majorRadius, MinorRadius: minorRadius, Material: m}
+	if err := torus.checkShape(); err != nil {
+		return b.fail("torus %d: %v", len(b.scene.Tori), err)
+	}
+	b.scene.Tori = append(b.scene.Tori, torus)
+	b.last = "torus"
+	return b
+}
+
+// AddPlane добавляет плоскость, проходящую через center. Плоскости не
+// преобразуются (Transform).
+func (b *SceneBuilder) AddPlane(center, normal Vec3f, m Material) *SceneBuilder {
+	plane := Plane{Center: center, Normal: normal, Material: m}
+	if err := plane.checkShape(); err != nil {
+		return b.fail("plane %d: %v", len(b.scene.Planes), err)
+	}
+	b.scene.Planes = append(b.scene.Planes, plane)
+	b.last = "plane"
+	return b
+}
+
+// Transform задает преобразование последнего добавленного примитива.
+func (b *SceneBuilder) Transform(t Transform) *SceneBuilder {
+	var dst **Transform
+	switch b.last {
+	case "sphere":
+		dst = &b.scene.Spheres[len(b.scene.Spheres)-1].Transform
+	case "cylinder":
+		dst = &b.scene.Cylinders[len(b.scene.Cylinders)-1].Transform
+	case "cone":
+		dst = &b.scene.Cones[len(b.scene.Cones)-1].Transform
+	case "torus":
+		dst = &b.scene.Tori[len(b.scene.Tori)-1].Transform
+	default:
+		return b.fail("transform: no sphere, cylinder, cone or torus to transform")
+	}
+	*dst = &t
+	return b
+}
+
+// Motion задает смещение последнего добавленного примитива за время
+// выдержки (см. Moving).
+func (b *SceneBuilder) Motion(m Vec3f) *SceneBuilder {
+	var dst **Vec3f
+	switch b.last {
+	case "sphere":
+		dst = &b.scene.Spheres[len(b.scene.Spheres)-1].Motion
+	case "cylinder":
+		dst = &b.scene.Cylinders[len(b.scene.Cylinders)-1].Motion
+	case "cone":
+		dst = &b.scene.Cones[len(b.scene.Cones)-1].Motion
+	case "torus":
+		dst = &b.scene.Tori[len(b.scene.Tori)-1].Motion
+	default:
+		return b.fail("motion: no sphere, cylinder, cone or torus to move")
+	}
+	*dst = &m
+	return b
+}
+
+// AddInstance добавляет повтор объекта с номером object (сферы, цилиндры,
+// конусы, торы и плоскости подряд, как в файле сцены) с преобразованием t.
+func (b *SceneBuilder) AddInstance(object int, t Transform) *SceneBuilder {
+	b.scene.Instances = append(b.scene.Instances, Instance{Object: object, Transform: t})
+	return b
+}
+
+// AddLight добавляет точечный источник.
+func (b *SceneBuilder) AddLight(position Vec3f, intensity float64) *SceneBuilder {
+	return b.AddAreaLight(PointLight{Position: position, Intensity: intensity})
+}
+
+// AddAreaLight добавляет источник с заданными формой и числом теневых
+// лучей (см. PointLight).
+func (b *SceneBuilder) AddAreaLight(l PointLight) *SceneBuilder {
+	if l.Intensity < 0 {
+		return b.fail("light %d: negative intensity", len(b.scene.Lights))
+	}
+	if err := l.checkFalloff(); err != nil {
+		return b.fail("light %d: %v", len(b.scene.Lights), err)
+	}
+	b.scene.Lights = append(b.scene.Lights, l)
+	return b
+}
+
+// AddDirectionalLight добавляет удаленный источник, свет которого
+// распространяется по направлению direction.
+func (b *SceneBuilder) AddDirectionalLight(direction Vec3f, intensity float64) *SceneBuilder {
+	b.scene.DirectionalLights = append(b.scene.DirectionalLights, DirectionalLight{Direction: direction, Intensity: intensity})
+	return b
+}
+
+func (b *SceneBuilder) AddAmbientLight(intensity float64) *SceneBuilder {
+	b.scene.AmbientLights = append(b.scene.AmbientLights, AmbientLight{Intensity: intensity})
+	return b
+}
+
+// AddDomeLight добавляет купол неба; samples - число теневых лучей (0 - по умолчанию).
+func (b *SceneBuilder) AddDomeLight(intensity float64, samples int) *SceneBuilder {
+	b.scene.DomeLights = append(b.scene.DomeLights, DomeLight{Intensity: intensity, Samples: samples})
+	return b
+}
+
+// Camera ставит камеру в position с вертикальным углом обзора fov в
+// градусах (0 - по умолчанию).
+func (b *SceneBuilder) Camera(position Vec3f, fov float64) *SceneBuilder {
+	b.scene.Camera.Position = position
+	b.scene.Camera.FOV = fov
+	return b
+}
+
+// LookAt направляет камеру на точку target.
+func (b *SceneBuilder) LookAt(target Vec3f) *SceneBuilder {
+	b.scene.Camera.LookAt = &target
+	return b
+}
+
+// Projection задает проекцию камеры (см. projections).
+func (b *SceneBuilder) Projection(name string) *SceneBuilder {
+	if _, ok := projections[name]; !ok {
+		return b.fail("camera: unknown projection %q", name)
+	}
+	b.scene.Camera.Projection = name
+	return b
+}
+
+// Lens задает диаметр линзы камеры и расстояние до плоскости фокуса.
+func (b *SceneBuilder) Lens(aperture, focalDistance float64) *SceneBuilder {
+	b.scene.Camera.Aperture = aperture
+	b.scene.Camera.FocalDistance = focalDistance
+	return b
+}
+
+func (b *SceneBuilder) Background(c Vec3f) *SceneBuilder {
+	b.scene.Background = c
+	return b
+}
+
+// EnvMap задает путь к карте окружения (см. Scene.EnvMap).
+func (b *SceneBuilder) EnvMap(path string) *SceneBuilder {
+	b.scene.EnvMap = path
+	return b
+}
+
+// Epsilon задает отступ лучей от поверхности (см. EpsilonPolicy).
+func (b *SceneBuilder) Epsilon(p EpsilonPolicy) *SceneBuilder {
+	if err := p.check(); err != nil {
+		return b.fail("epsilon: %v", err)
+	}
+	b.scene.Epsilon = &p
+	return b
+}
+
+// Dir задает каталог, от которого считаются пути к текстурам и карте окружения.
+func (b *SceneBuilder) Dir(dir string) *SceneBuilder {
+	b.dir = dir
+	return b
+}
+
+// Build проверяет сцену и загружает ее ресурсы. Построитель можно
+// продолжать использовать: каждый вызов возвращает новую сцену.
+func (b *SceneBuilder) Build() (*Scene, error) {
+	if b.err != nil {
+		return nil, fmt.Errorf("builder: %w", b.err)
+	}
+	data, err := json.Marshal(&b.scene)
+	if err != nil {
+		return nil, err
+	}
+	return parseScene(data, "builder", b.dir, "")
+}
+
+// Encode записывает сцену в формате файла сцены. Сферы россыпи (Drop) уже
+// входят в Spheres, поэтому сама россыпь не записывается.
+func (s *Scene) Encode(w io.Writer) error {
+	out := *s
+	out.Drop = nil
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&out)
+}
+
+// Save сохраняет сцену в файл сцены path.
+func (s *Scene) Save(path string) error {
+	return writeFile(FileSink{}, path, s.Encode)
+}
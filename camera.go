@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strings"
+)
+
+// Camera - камера, смотрящая вдоль -Z или в точку LookAt. С ненулевой
+// апертурой перспективная камера моделирует тонкую линзу, и объекты вне
+// плоскости фокуса размываются.
+type Camera struct {
+	Position      Vec3f            `json:"position"`
+	LookAt        *Vec3f           `json:"lookAt,omitempty"`        // Точка, в которую смотрит камера; верх кадра - в сторону +Y
+	Projection    string           `json:"projection,omitempty"`    // Проекция (см. projections), по умолчанию перспективная
+	FOV           float64          `json:"fov,omitempty"`           // Вертикальный угол обзора в градусах, по умолчанию 60; у fisheye - угол круга изображения, по умолчанию 180
+	OrthoHeight   float64          `json:"orthoHeight,omitempty"`   // Высота кадра ортографической камеры, по умолчанию 2
+	Aperture      float64          `json:"aperture,omitempty"`      // Диаметр линзы, 0 - камера-обскура
+	FocalDistance float64          `json:"focalDistance,omitempty"` // Расстояние до плоскости фокуса вдоль -Z
+	Animation     *CameraAnimation `json:"animation,omitempty"`
+	// Длительность выдержки в кадрах анимации: движение объектов и камеры
+	// за это время от начала кадра размывается. 0 - без размытия движения
+	Shutter float64 `json:"shutter,omitempty"`
+	Motion  *Vec3f  `json:"motion,omitempty"` // Смещение камеры за время выдержки
+}
+
+// fovRadians возвращает вертикальный угол обзора в радианах.
+func (c *Camera) fovRadians() float64 {
+	if c.FOV <= 0 {
+		return math.Pi / 3
+	}
+	return c.FOV * math.Pi / 180
+}
+
+// orient переводит направление d из системы камеры (взгляд вдоль -Z,
+// верх - +Y) в мировую.
+func (c *Camera) orient(d Vec3f) Vec3f {
+	if c.LookAt == nil {
+		return d
+	}
+	forward := c.LookAt.Subtract(c.Position).Normalize()
+	up := Vec3f{0, 1, 0}
+	if math.Abs(forward.Y) > 0.999 {
+		up = Vec3f{0, 0, -1} // Камера смотрит вертикально: верх кадра - вдаль
+	}
+	right := forward.Cross(up).Normalize()
+	up = right.Cross(forward)
+	return right.MulScalar(d.X).Add(up.MulScalar(d.Y)).Add(forward.MulScalar(-d.Z))
+}
+
+// lensRay превращает луч камеры-обскуры ray в луч тонкой линзы: начало
+// луча выбирается случайно на диске апертуры, а сам луч проходит через ту
+// же точку плоскости фокуса. Линза есть только у перспективной камеры.
+func (c *Camera) lensRay(ray Ray, rng *rand.Rand) Ray {
+	if c.Aperture <= 0 || c.FocalDistance <= 0 || c.Projection != "" && c.Projection != "perspective" {
+		return ray
+	}
+	focus := c.Position.Add(ray.Dir.MulScalar(c.FocalDistance / ray.Dir.Dot(c.orient(Vec3f{0, 0, -1}))))
+	// Равномерная точка на диске радиуса Aperture/2
+	r := c.Aperture / 2 * math.Sqrt(rng.Float64())
+	phi := 2 * math.Pi * rng.Float64()
+	orig := c.Position.Add(c.orient(Vec3f{r * math.Cos(phi), r * math.Sin(phi), 0}))
+	return newRay(orig, focus.Subtract(orig).Normalize())
+}
+
+// projection строит луч камеры в ее системе координат (взгляд вдоль -Z)
+// через точку кадра (x, y): x и y от -1 до 1, y растет вверх. Возвращает
+// начало луча относительно камеры, направление и false для точек кадра
+// вне изображения проекции.
+type projection func(p *pixelRenderer, x, y float64) (orig, dir Vec3f, ok bool)
+
+// projections - проекции камеры по именам (Camera.Projection).
+var projections = map[string]projection{
+	"perspective":     perspectiveProjection,
+	"orthographic":    orthographicProjection,
+	"fisheye":         fisheyeProjection,
+	"equirectangular": equirectangularProjection,
+}
+
+// projectionNames возвращает имена проекций в алфавитном порядке.
+func projectionNames() []string {
+	names := make([]string, 0, len(projections))
+	for name := range projections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkProjection проверяет имя проекции камеры.
+func (c *Camera) checkProjection() error {
+	if _, ok := projections[c.Projection]; c.Projection != "" && !ok {
+		return fmt.Errorf("unknown projection %q (want one of %s)", c.Projection, strings.Join(projectionNames(), ", "))
+	}
+	return nil
+}
+
+// cameraProjection возвращает проекцию камеры.
+func (c *Camera) cameraProjection() projection {
+	if project := projections[c.Projection]; project != nil {
+		return project
+	}
+	return perspectiveProjection
+}
+
+// perspectiveProjection - центральная проекция с вертикальным углом обзора FOV.
+func perspectiveProjection(p *pixelRenderer, x, y float64) (Vec3f, Vec3f, bool) {
+	w, h := float64(p.width), float64(p.height)
+	return Vec3f{}, Vec3f{x * p.tanHalf * w / h, y * p.tanHalf, -1}.Normalize(), true
+}
+
+// orthographicProjection - параллельная проекция: лучи идут вдоль взгляда
+// из точек прямоугольника высотой OrthoHeight (для чертежей и изометрии).
+func orthographicProjection(p *pixelRenderer, x, y float64) (Vec3f, Vec3f, bool) {
+	half := p.scene.Camera.OrthoHeight / 2
+	if half <= 0 {
+		half = 1
+	}
+	w, h := float64(p.width), float64(p.height)
+	return Vec3f{x * half * w / h, y * half, 0}, Vec3f{0, 0, -1}, true
+}
+
+// fisheyeProjection - эквидистантный рыбий глаз: угол луча к оси взгляда
+// пропорционален расстоянию от центра кадра. Изображение - круг, вписанный
+// в кадр по высоте; углы кадра остаются черными.
+func fisheyeProjection(p *pixelRenderer, x, y float64) (Vec3f, Vec3f, bool) {
+	angle := math.Pi
+	if c := p.scene.Camera; c.FOV > 0 {
+		angle = c.fovRadians()
+	}
+	x *= float64(p.width) / float64(p.height)
+	r := math.Hypot(x, y)
+	if r > 1 {
+		return Vec3f{}, Vec3f{}, false
+	}
+	theta, phi := r*angle/2, math.Atan2(y, x)
+	return Vec3f{}, Vec3f{math.Sin(theta) * math.Cos(phi), math.Sin(theta) * math.Sin(phi), -math.Cos(theta)}, true
+}
+
+// equirectangularProjection - панорама на 360 градусов: x задает долготу,
+// y - широту. Кадр с соотношением сторон 2:1 не искажает пропорций.
+func equirectangularProjection(p *pixelRenderer, x, y float64) (Vec3f, Vec3f, bool) {
+	lon, lat := x*math.Pi, y*math.Pi/2
+	return Vec3f{}, Vec3f{math.Cos(lat) * math.Sin(lon), math.Sin(lat), -math.Cos(lat) * math.Cos(lon)}, true
+}
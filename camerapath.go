@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// cameraPathColumns - столбцы CSV-файла пути камеры. Угол обзора можно не
+// указывать: тогда он берется из сцены.
+var cameraPathColumns = []string{"frame", "x", "y", "z", "lookX", "lookY", "lookZ", "fov"}
+
+// ReadCameraPath читает путь камеры, выгруженный из другой программы, в
+// формате CSV: по строке на ключевой кадр со столбцами cameraPathColumns
+// (номер кадра, положение, точка, в которую смотрит камера, и вертикальный
+// угол обзора в градусах). Первая строка может быть заголовком, строки,
+// начинающиеся с #, пропускаются. Кадры между ключами интерполируются
+// линейно.
+func ReadCameraPath(r io.Reader) (*CameraAnimation, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	a := &CameraAnimation{}
+	for row := 1; ; row++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if row == 1 && isCameraPathHeader(record) {
+			continue
+		}
+		if len(record) != len(cameraPathColumns) && len(record) != len(cameraPathColumns)-1 {
+			return nil, fmt.Errorf("row %d: want %d or %d columns (%s), got %d", row,
+				len(cameraPathColumns)-1, len(cameraPathColumns), strings.Join(cameraPathColumns, ", "), len(record))
+		}
+		values := make([]float64, len(record))
+		for i, field := range record {
+			if values[i], err = strconv.ParseFloat(strings.TrimSpace(field), 64); err != nil {
+				return nil, fmt.Errorf("row %d: %s: %w", row, cameraPathColumns[i], err)
+			}
+		}
+		frame := values[0]
+		a.Position = append(a.Position, VecKey{Frame: frame, Value: Vec3f{values[1], values[2], values[3]}})
+		a.LookAt = append(a.LookAt, VecKey{Frame: frame, Value: Vec3f{values[4], values[5], values[6]}})
+		if len(values) == len(cameraPathColumns) {
+			a.FOV = append(a.FOV, FloatKey{Frame: frame, Value: values[7]})
+		}
+	}
+	if len(a.Position) == 0 {
+		return nil, errors.New("no keyframes")
+	}
+	if len(a.FOV) != 0 && len(a.FOV) != len(a.Position) {
+		return nil, errors.New("fov must be given for all keyframes or for none")
+	}
+	return a, nil
+}
+
+// isCameraPathHeader сообщает, что строка - заголовок, а не ключевой кадр.
+func isCameraPathHeader(record []string) bool {
+	_, err := strconv.ParseFloat(strings.TrimSpace(record[0]), 64)
+	return err != nil
+}
+
+// LoadCameraPath читает путь камеры из CSV-файла (см. ReadCameraPath).
+func LoadCameraPath(path string) (*CameraAnimation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	a, err := ReadCameraPath(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return a, nil
+}
+
+// setCameraPath заменяет анимацию камеры путем a. Ключи упорядочиваются
+// по кадрам, как при загрузке сцены.
+func (s *Scene) setCameraPath(a *CameraAnimation) {
+	s.Camera.Animation = a
+	s.sortKeys()
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// checkpointPercents - доли сэмплов (в процентах), после которых
+// сохраняются контрольные снимки: каждая следующая вдвое больше.
+var checkpointPercents = []int{1, 2, 4, 8, 16, 32, 64}
+
+// checkpointStages возвращает число сэмплов пикселя к концу каждой стадии
+// рендера с samples сэмплами. Доли, на которые не приходится отдельного
+// числа сэмплов, пропускаются; последняя стадия - все сэмплы, после
+// остальных сохраняются контрольные снимки.
+func checkpointStages(samples int) []int {
+	var counts []int
+	for _, pct := range checkpointPercents {
+		n := (samples*pct + 99) / 100
+		if n >= samples || len(counts) > 0 && n <= counts[len(counts)-1] {
+			continue
+		}
+		counts = append(counts, n)
+	}
+	return append(counts, samples)
+}
+
+// checkpointPath возвращает имя контрольного снимка по числу сэмплов в
+// нем: result.png -> result_s0004.png. Доля из checkpointPercents для
+// имени не годится: при малом числе сэмплов снимок содержит заметно
+// большую долю, чем та, по которой он выбран.
+func checkpointPath(path string, samples int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s_s%04d%s", strings.TrimSuffix(path, ext), samples, ext)
+}
+
+// progressive - рендер кадра стадиями по числу сэмплов: каждая стадия
+// добавляет всем пикселям сэмплы, после стадий контрольных снимков
+// (RenderOptions.Checkpoints) сохраняется снимок, а после обучающей стадии
+// кэш яркости (RenderOptions.Guide) перестает учиться. Поток случайных
+// чисел пикселя продолжается со стадии на стадию, а сэмплы складываются в
+// том же порядке, поэтому без направленного сэмплирования итоговый кадр
+// побитово совпадает с обычным рендером.
+type progressive struct {
+	counts []int  // Число сэмплов пикселя к концу стадии
+	save   []bool // Сохранять контрольный снимок после стадии
+	learn  int    // Стадия, завершающая обучение кэша яркости (-1 - нет)
+	pixels []pixelSamples
+	states []rand.PCG // Состояние потока случайных чисел пикселя
+}
+
+// newProgressive готовит рендер стадиями или возвращает nil, если у кадра
+// нет промежуточных стадий.
+func (p *pixelRenderer) newProgressive() *progressive {
+	save := map[int]bool{} // Число сэмплов к концу стадии -> сохранять снимок
+	if p.opts.Checkpoints {
+		counts := checkpointStages(p.samples)
+		for _, n := range counts[:len(counts)-1] {
+			save[n] = true
+		}
+	}
+	learn := 0
+	if p.scene.guide != nil {
+		learn = guideLearnSamples(p.samples)
+		if _, ok := save[learn]; !ok && learn < p.samples {
+			save[learn] = false
+		}
+	}
+	if len(save) == 0 {
+		return nil
+	}
+	g := &progressive{
+		counts: append(slices.Sorted(maps.Keys(save)), p.samples),
+		learn:  -1,
+		pixels: make([]pixelSamples, p.width*p.height),
+		states: make([]rand.PCG, p.width*p.height),
+	}
+	for k, n := range g.counts {
+		g.save = append(g.save, save[n])
+		if n == learn {
+			g.learn = k
+		}
+	}
+	return g
+}
+
+// sampleRow выполняет стадию stage для строки j; последняя стадия
+// записывает готовые пиксели в fb. Строка обновляется целиком после
+// всех ее пикселей, поэтому стадию можно повторить (см. isolate).
+func (g *progressive) sampleRow(p *pixelRenderer, j, stage int, fb *Framebuffer, ps *pixelSampler) {
+	row := j * p.width
+	pixels := make([]pixelSamples, p.width)
+	states := make([]rand.PCG, p.width)
+	copy(pixels, g.pixels[row:row+p.width])
+	from := 0
+	if stage > 0 {
+		from = g.counts[stage-1]
+	}
+	count := g.counts[stage] - from
+	for i := range pixels {
+		if stage == 0 {
+			p.sample(&pixels[i], i, j, count, 0, ps)
+		} else {
+			*ps.pcg = g.states[row+i]
+			p.addSamples(&pixels[i], i, j, count, ps)
+		}
+		states[i] = *ps.pcg
+	}
+	if stage == len(g.counts)-1 {
+		for i := range pixels {
+			fb.Set(i, j, p.finish(&pixels[i], i, j, ps))
+		}
+	}
+	copy(g.pixels[row:], pixels)
+	copy(g.states[row:], states)
+}
+
+// snapshot возвращает кадр из сэмплов, накопленных к этому моменту;
+// пиксели без сэмплов остаются черными.
+func (g *progressive) snapshot(p *pixelRenderer) *Framebuffer {
+	fb := NewFramebuffer(p.width, p.height)
+	for k, s := range g.pixels {
+		if s.noise.n > 0 {
+			fb.Pixels[k] = s.sum.MulScalar(p.exposure / float64(s.noise.n))
+		}
+	}
+	return fb
+}
+
+// saveCheckpoint сохраняет снимок после стадии stage рядом с результатом
+// (см. checkpointPath), если он нужен. Ошибка сохранения не прерывает рендер.
+func (g *progressive) saveCheckpoint(p *pixelRenderer, stage int) {
+	if !g.save[stage] {
+		return
+	}
+	opts := p.opts
+	opts.Output = checkpointPath(opts.Output, g.counts[stage])
+	opts.Samples = g.counts[stage]
+	opts.AOVs = nil
+	res := &RenderResult{Image: g.snapshot(p)}
+	if err := res.save(opts); err != nil {
+		fmt.Fprintln(os.Stderr, "checkpoint:", err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// compareDivider - цвет линии, разделяющей половины сравнения.
+var compareDivider = Vec3f{1, 1, 1}
+
+// parseCompare разбирает пару интеграторов вида "whitted,path".
+func parseCompare(list string) (string, string, error) {
+	a, b, ok := strings.Cut(list, ",")
+	if !ok {
+		return "", "", fmt.Errorf("-compare expects two integrators, got %q", list)
+	}
+	for _, name := range []string{a, b} {
+		if !slices.Contains(integratorNames, name) {
+			return "", "", fmt.Errorf("unknown integrator %q", name)
+		}
+	}
+	return a, b, nil
+}
+
+// RenderCompare рендерит сцену интеграторами a и b с одинаковыми зерном
+// и числом сэмплов и собирает из них одно изображение: слева половина
+// кадра a, справа - та же половина кадра b. Оба кадра рендерятся целиком,
+// поэтому каждый пиксель получает те же случайные числа, что и в обычном
+// рендере этим интегратором.
+func RenderCompare(ctx context.Context, scene *Scene, opts RenderOptions, a, b string) (*RenderResult, error) {
+	opts.AOVs = nil
+	opts.Integrator = a
+	left, err := Render(ctx, scene, opts)
+	if err != nil {
+		return left, err
+	}
+	opts.Integrator = b
+	right, err := Render(ctx, scene, opts)
+	if err != nil {
+		return right, err
+	}
+
+	fb := left.Image
+	half := fb.Width / 2
+	for j := 0; j < fb.Height; j++ {
+		for i := half; i < fb.Width; i++ {
+			fb.Set(i, j, right.Image.At(i, j))
+		}
+		fb.Set(half-1, j, compareDivider)
+		fb.Set(half, j, compareDivider)
+	}
+	return &RenderResult{
+		Image:    fb,
+		Rays:     left.Rays + right.Rays,
+		Duration: left.Duration + right.Duration,
+		Stats:    left.Stats.add(right.Stats),
+	}, nil
+}
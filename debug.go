@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// integratorNames - допустимые значения -integrator.
+var integratorNames = []string{"whitted", "path", "uv", "checker", "mip"}
+
+// checkerCells - число клеток шахматки на единицу UV.
+const checkerCells = 16
+
+// mipColors - цвета уровней детализации текстуры, начиная с нулевого.
+var mipColors = []Vec3f{
+	{0.1, 0.1, 1.0}, // 0: текстура недостаточно подробна
+	{0.1, 0.8, 1.0},
+	{0.1, 1.0, 0.1},
+	{1.0, 1.0, 0.1},
+	{1.0, 0.5, 0.1},
+	{1.0, 0.1, 0.1},
+	{1.0, 0.1, 1.0},
+	{1.0, 1.0, 1.0}, // 7 и выше
+}
+
+// traceUV окрашивает поверхность текстурными координатами: R = u, G = v.
+func traceUV(ray Ray, scene *Scene, _ int, _ *rand.Rand) Vec3f {
+	hit, ok := scene.hit(ray)
+	if !ok {
+		return Vec3f{0, 0, 0}
+	}
+	u, v := objectUV(hit.Object, hit.Point)
+	return Vec3f{u, v, 0}
+}
+
+// traceChecker накладывает на поверхность шахматку в UV с простым
+// освещением от камеры - разрывы и растяжения развертки сразу видны.
+func traceChecker(ray Ray, scene *Scene, _ int, _ *rand.Rand) Vec3f {
+	hit, ok := scene.hit(ray)
+	if !ok {
+		return Vec3f{0, 0, 0}
+	}
+	u, v := objectUV(hit.Object, hit.Point)
+	shade := 0.2 + 0.8*math.Abs(hit.Normal.Dot(ray.Dir))
+	if (int(math.Floor(u*checkerCells))+int(math.Floor(v*checkerCells)))%2 == 0 {
+		return Vec3f{0.9, 0.9, 0.9}.MulScalar(shade)
+	}
+	return Vec3f{0.2, 0.2, 0.2}.MulScalar(shade)
+}
+
+// mipLevelTracer возвращает интегратор, показывающий уровень mip-карты,
+// который выбрала бы фильтрация по размеру пятна пикселя на поверхности.
+// pixelAngle - угловой размер пикселя. Объекты без текстуры окрашены серым.
+func mipLevelTracer(pixelAngle float64) Integrator {
+	return func(ray Ray, scene *Scene, _ int, _ *rand.Rand) Vec3f {
+		hit, ok := scene.hit(ray)
+		if !ok {
+			return Vec3f{0, 0, 0}
+		}
+		hitObj, dist, point, N, dir := hit.Object, hit.T, hit.Point, hit.Normal, ray.Dir
+		tex := hitObj.material().texture
+		if tex == nil {
+			return Vec3f{0.5, 0.5, 0.5}
+		}
+		// Размер пятна пикселя на поверхности с учетом наклона; меньше
+		// отступа лучей шаг не берется - его съело бы округление координат
+		footprint := dist * pixelAngle / math.Max(1e-3, math.Abs(N.Dot(dir)))
+		footprint = math.Max(footprint, scene.epsilon.at(point))
+		// Число текселей, которые накрывает пятно: развертка численно
+		// дифференцируется вдоль двух касательных направлений
+		u0, v0 := objectUV(hitObj, point)
+		t1, t2 := orthonormalBasis(N)
+		texels := 0.0
+		for _, t := range [2]Vec3f{t1, t2} {
+			u, v := objectUV(hitObj, point.Add(t.MulScalar(footprint)))
+			du := math.Abs(u - u0)
+			du = math.Min(du, 1-du) // Шов развертки по u
+			texels = math.Max(texels, math.Max(du*float64(tex.Width), math.Abs(v-v0)*float64(tex.Height)))
+		}
+		lod := math.Log2(texels)
+		level := min(len(mipColors)-1, int(math.Max(0, math.Floor(lod))))
+		return mipColors[level]
+	}
+}
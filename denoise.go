@@ -0,0 +1,123 @@
+package main
+
+import "math"
+
+// Параметры шумоподавления.
+const (
+	denoiseIterations = 5    // Проходы à-trous: радиус фильтра 2^5 = 32 пикселя
+	denoiseSigmaColor = 2.0  // Чувствительность к разнице цвета на первом проходе
+	denoiseSigmaNorm  = 0.3  // Чувствительность к разнице нормалей
+	denoiseSigmaAlbe  = 0.1  // Чувствительность к разнице альбедо
+	fireflyRatio      = 4    // Пиксель ярче среднего соседей во столько раз - светлячок
+	albedoFloor       = 0.02 // Нижняя граница альбедо при делении на него
+)
+
+// atrousKernel - одномерное ядро B3-сплайна фильтра à-trous.
+var atrousKernel = [5]float64{1.0 / 16, 1.0 / 4, 3.0 / 8, 1.0 / 4, 1.0 / 16}
+
+// luminance возвращает яркость цвета.
+func luminance(c Vec3f) float64 {
+	return 0.2126*c.X + 0.7152*c.Y + 0.0722*c.Z
+}
+
+// denoise убирает шум Монте-Карло из кадра fb, используя нормали и
+// альбедо первичных попаданий из aov. Сначала одиночные слишком яркие
+// пиксели (светлячки) заменяются средним соседей, затем освещенность
+// (цвет, деленный на альбедо, чтобы не размывать текстуры) сглаживается
+// фильтром à-trous, который не усредняет пиксели через границы объектов,
+// резкие изгибы и смену материала.
+func denoise(fb *Framebuffer, aov *AOVBuffers) *Framebuffer {
+	w, h := fb.Width, fb.Height
+	albedo := func(k int) Vec3f {
+		a := aov.Albedo[k]
+		return Vec3f{math.Max(a.X, albedoFloor), math.Max(a.Y, albedoFloor), math.Max(a.Z, albedoFloor)}
+	}
+	cur := removeFireflies(fb).Pixels
+	for k := range cur {
+		if aov.Hit[k] {
+			a := albedo(k)
+			cur[k] = Vec3f{cur[k].X / a.X, cur[k].Y / a.Y, cur[k].Z / a.Z}
+		}
+	}
+
+	next := make([]Vec3f, len(cur))
+	sigmaColor := denoiseSigmaColor
+	for it := 0; it < denoiseIterations; it++ {
+		step := 1 << it
+		for j := 0; j < h; j++ {
+			for i := 0; i < w; i++ {
+				k := j*w + i
+				if !aov.Hit[k] {
+					next[k] = cur[k] // Фон не шумит
+					continue
+				}
+				var sum Vec3f
+				total := 0.0
+				for dy := -2; dy <= 2; dy++ {
+					y := j + dy*step
+					if y < 0 || y >= h {
+						continue
+					}
+					for dx := -2; dx <= 2; dx++ {
+						x := i + dx*step
+						if x < 0 || x >= w {
+							continue
+						}
+						q := y*w + x
+						if !aov.Hit[q] {
+							continue
+						}
+						wc := cur[q].Subtract(cur[k]).Length2() / (sigmaColor * sigmaColor)
+						wn := aov.Normal[q].Subtract(aov.Normal[k]).Length2() / (denoiseSigmaNorm * denoiseSigmaNorm)
+						wa := aov.Albedo[q].Subtract(aov.Albedo[k]).Length2() / (denoiseSigmaAlbe * denoiseSigmaAlbe)
+						weight := atrousKernel[dx+2] * atrousKernel[dy+2] * math.Exp(-wc-wn-wa)
+						sum = sum.Add(cur[q].MulScalar(weight))
+						total += weight
+					}
+				}
+				next[k] = sum.MulScalar(1 / total)
+			}
+		}
+		cur, next = next, cur
+		// Каждый следующий проход усредняет более дальние пиксели, уже
+		// сглаженные предыдущими, поэтому допуск по цвету сужается
+		sigmaColor /= 2
+	}
+
+	out := NewFramebuffer(w, h)
+	for k, c := range cur {
+		if aov.Hit[k] {
+			c = c.Mul(albedo(k))
+		}
+		out.Pixels[k] = c
+	}
+	return out
+}
+
+// removeFireflies возвращает копию кадра, в которой пиксели, во много
+// раз более яркие, чем их соседи, заменены средним цветом соседей.
+func removeFireflies(fb *Framebuffer) *Framebuffer {
+	out := NewFramebuffer(fb.Width, fb.Height)
+	copy(out.Pixels, fb.Pixels)
+	for j := 0; j < fb.Height; j++ {
+		for i := 0; i < fb.Width; i++ {
+			var mean Vec3f
+			n := 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					x, y := i+dx, j+dy
+					if (dx == 0 && dy == 0) || x < 0 || y < 0 || x >= fb.Width || y >= fb.Height {
+						continue
+					}
+					mean = mean.Add(fb.At(x, y))
+					n++
+				}
+			}
+			mean = mean.MulScalar(1 / float64(n))
+			if luminance(fb.At(i, j)) > fireflyRatio*math.Max(luminance(mean), 0.05) {
+				out.Set(i, j, mean)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// SphereDrop описывает россыпь сфер, сброшенных на горизонтальный пол:
+// сферы падают по одной в случайные точки квадрата и скатываются с уже
+// лежащих, пока не упрутся в пол или не застрянут между соседями.
+type SphereDrop struct {
+	Count     int        `json:"count"`
+	Seed      uint64     `json:"seed"`
+	Center    Vec3f      `json:"center"` // Центр квадрата на полу; Y - высота пола
+	Size      float64    `json:"size"`   // Половина стороны квадрата
+	MinRadius float64    `json:"minRadius"`
+	MaxRadius float64    `json:"maxRadius"`
+	Materials []Material `json:"materials,omitempty"` // Пусто - случайные цвета
+}
+
+// Параметры скатывания сферы.
+const (
+	dropRollStep  = 0.05 // Шаг скатывания в долях радиуса
+	dropRollSteps = 200  // Предельное число шагов
+)
+
+// dropSpheres сбрасывает сферы по описанию d. Уже лежащие сферы obstacles
+// служат опорой, но сами не сдвигаются.
+func dropSpheres(d *SphereDrop, obstacles []Sphere) []Sphere {
+	rng := rand.New(rand.NewPCG(d.Seed, 0))
+	placed := append([]Sphere(nil), obstacles...)
+	out := make([]Sphere, 0, d.Count)
+	for n := 0; n < d.Count; n++ {
+		r := d.MinRadius + (d.MaxRadius-d.MinRadius)*rng.Float64()
+		x := d.Center.X + d.Size*(2*rng.Float64()-1)
+		z := d.Center.Z + d.Size*(2*rng.Float64()-1)
+		s := Sphere{Center: settle(x, z, r, d.Center.Y, placed), Radius: r}
+		if len(d.Materials) > 0 {
+			s.Material = d.Materials[rng.IntN(len(d.Materials))]
+		} else {
+			s.Material = Material{Color: randomColor(rng), Albedo: 0.6, SpecularExponent: 50}
+		}
+		placed = append(placed, s)
+		out = append(out, s)
+	}
+	return out
+}
+
+// settle возвращает положение, в котором остановится сфера радиуса r,
+// сброшенная над точкой (x, z) на пол высоты ground с лежащими сферами placed.
+// Сфера, опирающаяся на одну соседнюю, скатывается с нее по горизонтали,
+// пока это понижает ее центр.
+func settle(x, z, r, ground float64, placed []Sphere) Vec3f {
+	y, support := restHeight(x, z, r, ground, placed)
+	for step := 0; step < dropRollSteps && support >= 0; step++ {
+		c := placed[support].Center
+		dx, dz := x-c.X, z-c.Z
+		l := math.Hypot(dx, dz)
+		if l == 0 {
+			dx, dz, l = 1, 0, 1 // Точно на вершине: скатывается в любую сторону
+		}
+		nx, nz := x+dx/l*r*dropRollStep, z+dz/l*r*dropRollStep
+		ny, nsupport := restHeight(nx, nz, r, ground, placed)
+		if ny >= y {
+			break // Застряла между соседями
+		}
+		x, y, z, support = nx, ny, nz, nsupport
+	}
+	return Vec3f{x, y, z}
+}
+
+// restHeight возвращает высоту центра сферы радиуса r, опущенной
+// вертикально над точкой (x, z), и номер сферы из placed, на которую она
+// легла (-1 - на пол).
+func restHeight(x, z, r, ground float64, placed []Sphere) (float64, int) {
+	y, support := ground+r, -1
+	for i, p := range placed {
+		d2 := (x-p.Center.X)*(x-p.Center.X) + (z-p.Center.Z)*(z-p.Center.Z)
+		reach := r + p.Radius
+		if d2 >= reach*reach {
+			continue
+		}
+		if h := p.Center.Y + math.Sqrt(reach*reach-d2); h > y {
+			y, support = h, i
+		}
+	}
+	return y, support
+}
+
+// randomColor возвращает случайный насыщенный цвет.
+func randomColor(rng *rand.Rand) Vec3f {
+	// Оттенок по кругу, насыщенность и яркость - в приятном диапазоне
+	h := rng.Float64()
+	s, v := 0.5+0.3*rng.Float64(), 0.6+0.3*rng.Float64()
+	return hsvColor(h, s, v)
+}
+
+// hsvColor переводит цвет из HSV (оттенок h в [0, 1)) в RGB.
+func hsvColor(h, s, v float64) Vec3f {
+	h *= 6
+	f := h - math.Floor(h)
+	p, q, t := v*(1-s), v*(1-s*f), v*(1-s*(1-f))
+	switch int(h) {
+	case 0:
+		return Vec3f{v, t, p}
+	case 1:
+		return Vec3f{q, v, p}
+	case 2:
+		return Vec3f{p, v, t}
+	case 3:
+		return Vec3f{p, q, v}
+	case 4:
+		return Vec3f{t, p, v}
+	}
+	return Vec3f{v, p, q}
+}
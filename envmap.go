@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EnvMap - карта окружения в equirectangular-проекции.
+type EnvMap struct {
+	Width, Height int
+	Pixels        []Vec3f // Построчно, сверху вниз
+}
+
+// LoadEnvMap загружает карту окружения. Файлы .hdr читаются как Radiance RGBE,
+// остальные форматы (PNG, JPEG) - через пакет image.
+func LoadEnvMap(path string) (*EnvMap, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".hdr") {
+		return readRadianceHDR(bufio.NewReader(file))
+	}
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	env := &EnvMap{Width: bounds.Dx(), Height: bounds.Dy(), Pixels: make([]Vec3f, bounds.Dx()*bounds.Dy())}
+	for y := 0; y < env.Height; y++ {
+		for x := 0; x < env.Width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			env.Pixels[y*env.Width+x] = Vec3f{float64(r) / 0xffff, float64(g) / 0xffff, float64(b) / 0xffff}
+		}
+	}
+	return env, nil
+}
+
+// Sample возвращает цвет окружения в направлении dir (с билинейной интерполяцией).
+func (e *EnvMap) Sample(dir Vec3f) Vec3f {
+	d := dir.Normalize()
+	u := 0.5 + math.Atan2(d.X, -d.Z)/(2*math.Pi)
+	v := math.Acos(math.Max(-1, math.Min(1, d.Y))) / math.Pi
+
+	x := u*float64(e.Width) - 0.5
+	y := v*float64(e.Height) - 0.5
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+
+	c00 := e.texel(int(x0), int(y0))
+	c10 := e.texel(int(x0)+1, int(y0))
+	c01 := e.texel(int(x0), int(y0)+1)
+	c11 := e.texel(int(x0)+1, int(y0)+1)
+	top := c00.MulScalar(1 - fx).Add(c10.MulScalar(fx))
+	bottom := c01.MulScalar(1 - fx).Add(c11.MulScalar(fx))
+	return top.MulScalar(1 - fy).Add(bottom.MulScalar(fy))
+}
+
+// texel возвращает пиксель карты: по горизонтали координата заворачивается,
+// по вертикали прижимается к краю.
+func (e *EnvMap) texel(x, y int) Vec3f {
+	x %= e.Width
+	if x < 0 {
+		x += e.Width
+	}
+	y = max(0, min(e.Height-1, y))
+	return e.Pixels[y*e.Width+x]
+}
+
+// maxHDRSide, maxHDRPixels - наибольшие сторона и число пикселей
+// читаемого HDR-изображения: панорама до 16384x8192 занимает в памяти
+// около 3 ГБ.
+const (
+	maxHDRSide   = 1 << 15
+	maxHDRPixels = 16384 * 8192
+)
+
+// readRadianceHDR читает изображение в формате Radiance RGBE (.hdr).
+func readRadianceHDR(r *bufio.Reader) (*EnvMap, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "#?") {
+		return nil, fmt.Errorf("hdr: missing #? signature")
+	}
+	// Заголовок заканчивается пустой строкой
+	for {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT=32-bit_rle_rgbe" {
+			return nil, fmt.Errorf("hdr: unsupported %s", line)
+		}
+	}
+	line, err = r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	var width, height int
+	if _, err := fmt.Sscanf(line, "-Y %d +X %d", &height, &width); err != nil {
+		return nil, fmt.Errorf("hdr: unsupported resolution line %q", strings.TrimSpace(line))
+	}
+	// Размер проверяется до выделения памяти: испорченный или подложенный
+	// файл не должен ронять рендер или сервис, загружающий сцены
+	if width <= 0 || height <= 0 || width > maxHDRSide || height > maxHDRSide || width*height > maxHDRPixels {
+		return nil, fmt.Errorf("hdr: unsupported size %dx%d", width, height)
+	}
+
+	env := &EnvMap{Width: width, Height: height, Pixels: make([]Vec3f, width*height)}
+	scanline := make([]byte, 4*width)
+	for y := 0; y < height; y++ {
+		if err := readRGBEScanline(r, scanline, width); err != nil {
+			return nil, err
+		}
+		for x := 0; x < width; x++ {
+			env.Pixels[y*width+x] = rgbeToVec3f(scanline[4*x : 4*x+4])
+		}
+	}
+	return env, nil
+}
+
+// readRGBEScanline читает одну строку пикселей в формате RGBE
+// (новое RLE-кодирование по каналам либо несжатые данные).
+func readRGBEScanline(r *bufio.Reader, dst []byte, width int) error {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return err
+	}
+	if width < 8 || width > 0x7fff || head[0] != 2 || head[1] != 2 || head[2]&0x80 != 0 {
+		// Несжатая строка
+		copy(dst, head)
+		_, err := io.ReadFull(r, dst[4:])
+		return err
+	}
+	if int(head[2])<<8|int(head[3]) != width {
+		return fmt.Errorf("hdr: scanline width mismatch")
+	}
+	// Каналы R, G, B, E хранятся раздельно, каждый сжат RLE
+	channel := make([]byte, width)
+	for c := 0; c < 4; c++ {
+		for x := 0; x < width; {
+			count, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			if count > 128 {
+				n := int(count - 128)
+				if x+n > width {
+					return fmt.Errorf("hdr: bad run length")
+				}
+				value, err := r.ReadByte()
+				if err != nil {
+					return err
+				}
+				for i := 0; i < n; i++ {
+					channel[x+i] = value
+				}
+				x += n
+			} else {
+				n := int(count)
+				if n == 0 || x+n > width {
+					return fmt.Errorf("hdr: bad run length")
+				}
+				if _, err := io.ReadFull(r, channel[x:x+n]); err != nil {
+					return err
+				}
+				x += n
+			}
+		}
+		for x := 0; x < width; x++ {
+			dst[4*x+c] = channel[x]
+		}
+	}
+	return nil
+}
+
+// rgbeToVec3f декодирует пиксель RGBE в цвет.
+func rgbeToVec3f(p []byte) Vec3f {
+	if p[3] == 0 {
+		return Vec3f{0, 0, 0}
+	}
+	f := math.Ldexp(1, int(p[3])-(128+8))
+	return Vec3f{float64(p[0]) * f, float64(p[1]) * f, float64(p[2]) * f}
+}
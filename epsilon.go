@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// EpsilonPolicy - правило выбора отступа вторичных и теневых лучей от
+// поверхности, на которой они начинаются. Отступ нужен, чтобы луч не
+// пересек ту же поверхность из-за ошибок округления (shadow acne), но
+// слишком большой отступ пропускает свет сквозь тонкие объекты и в щели.
+// Постоянный отступ подходит только сценам размером порядка единиц:
+// в огромной сцене его съедает округление координат, в крошечной он
+// больше самих объектов.
+type EpsilonPolicy struct {
+	// Режим (см. epsilonDefaults): "absolute" - постоянный отступ Value,
+	// "relative" - доля Value размера сцены, "adaptive" - Value * (1 + |p|),
+	// где |p| - наибольшая по модулю координата точки: растет вместе с
+	// ошибкой округления ее координат
+	Mode  string  `json:"mode,omitempty"`
+	Value float64 `json:"value,omitempty"` // 0 - значение режима по умолчанию
+}
+
+// epsilonDefaults - режимы отступа и их Value по умолчанию.
+var epsilonDefaults = map[string]float64{
+	"absolute": 1e-3,
+	"relative": 1e-5,
+	"adaptive": 1e-9,
+}
+
+// epsilonModeNames возвращает имена режимов отступа в алфавитном порядке.
+func epsilonModeNames() []string {
+	names := make([]string, 0, len(epsilonDefaults))
+	for name := range epsilonDefaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// check проверяет режим и параметр политики.
+func (p *EpsilonPolicy) check() error {
+	if _, ok := epsilonDefaults[p.Mode]; p.Mode != "" && !ok {
+		return fmt.Errorf("unknown mode %q (want one of %s)", p.Mode, strings.Join(epsilonModeNames(), ", "))
+	}
+	if p.Value < 0 || math.IsNaN(p.Value) || math.IsInf(p.Value, 0) {
+		return fmt.Errorf("value must be a non-negative number")
+	}
+	return nil
+}
+
+// rayEpsilon - отступ, к которому сводится политика для конкретной сцены:
+// в точке p он равен abs + rel * |p|.
+type rayEpsilon struct {
+	abs, rel float64
+}
+
+// resolve сводит политику p (nil - по умолчанию) к отступу для сцены,
+// ограниченные объекты которой лежат в boxes.
+func (p *EpsilonPolicy) resolve(boxes []AABB) rayEpsilon {
+	mode, value := "absolute", 0.0
+	if p != nil {
+		if p.Mode != "" {
+			mode = p.Mode
+		}
+		value = p.Value
+	}
+	if value == 0 {
+		value = epsilonDefaults[mode]
+	}
+	switch mode {
+	case "relative":
+		return rayEpsilon{abs: value * sceneExtent(boxes)}
+	case "adaptive":
+		return rayEpsilon{abs: value, rel: value}
+	}
+	return rayEpsilon{abs: value}
+}
+
+// at возвращает отступ для луча, начинающегося в точке p. У сцены, не
+// прошедшей build, отступ постоянный по умолчанию.
+func (e rayEpsilon) at(p Vec3f) float64 {
+	if e.rel == 0 {
+		if e.abs == 0 {
+			return epsilonDefaults["absolute"]
+		}
+		return e.abs
+	}
+	return e.abs + e.rel*math.Max(math.Abs(p.X), math.Max(math.Abs(p.Y), math.Abs(p.Z)))
+}
+
+// sceneExtent возвращает диагональ параллелепипеда, содержащего
+// ограниченные объекты boxes, или 1, если таких объектов нет.
+func sceneExtent(boxes []AABB) float64 {
+	var box AABB
+	found := false
+	for _, b := range boxes {
+		if !b.isFinite() {
+			continue
+		}
+		if !found {
+			box, found = b, true
+		} else {
+			box = box.union(b)
+		}
+	}
+	if !found {
+		return 1
+	}
+	if d := box.Max.Subtract(box.Min).Length(); d > 0 {
+		return d
+	}
+	return 1
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// scaledScene возвращает сцену из сферы на плоскости, освещенную
+// точечным источником, увеличенную в k раз и сдвинутую на shift.
+func scaledScene(k float64, shift Vec3f, policy *EpsilonPolicy) *Scene {
+	at := func(x, y, z float64) Vec3f { return Vec3f{x, y, z}.MulScalar(k).Add(shift) }
+	look := at(0, 0, -4)
+	mat := Material{Color: Vec3f{0.8, 0.8, 0.8}, Albedo: 0.9, SpecularExponent: 20}
+	scene := &Scene{
+		Camera:  Camera{Position: at(0, 1, 0), LookAt: &look},
+		Spheres: []Sphere{{Center: at(0, 0, -4), Radius: k, Material: mat}},
+		Planes:  []Plane{{Center: at(0, -1, 0), Normal: Vec3f{0, 1, 0}, Material: mat}},
+		Lights:  []PointLight{{Position: at(2, 3, -1), Intensity: 1}},
+		Epsilon: policy,
+	}
+	scene.build()
+	return scene
+}
+
+// renderScaled рендерит сцену scaledScene в маленький кадр.
+func renderScaled(t *testing.T, scene *Scene) *Framebuffer {
+	t.Helper()
+	res, err := Render(context.Background(), scene, RenderOptions{Width: 64, Height: 48, Depth: 4, Integrator: "whitted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Image
+}
+
+// TestEpsilonScale проверяет, что с отступом, зависящим от масштаба, сцена
+// крошечного и огромного размера выглядит так же, как сцена размером
+// порядка единиц: самозатенение (shadow acne) изменило бы много пикселей.
+func TestEpsilonScale(t *testing.T) {
+	want := postProcess(renderScaled(t, scaledScene(1, Vec3f{}, nil)), "clamp", false)
+	tol := ImageTolerance{RMSE: 0.01, Pixel: 0.05, Changed: 0.01}
+	for _, c := range []struct {
+		name   string
+		k      float64
+		shift  Vec3f
+		policy *EpsilonPolicy
+		pass   bool
+	}{
+		{"tiny/adaptive", 1e-4, Vec3f{}, &EpsilonPolicy{Mode: "adaptive"}, true},
+		{"tiny/relative", 1e-4, Vec3f{}, &EpsilonPolicy{Mode: "relative"}, true},
+		{"huge/adaptive", 1e4, Vec3f{}, &EpsilonPolicy{Mode: "adaptive"}, true},
+		{"huge/relative", 1e4, Vec3f{}, &EpsilonPolicy{Mode: "relative"}, true},
+		{"far/adaptive", 1, Vec3f{1e7, 0, 0}, &EpsilonPolicy{Mode: "adaptive"}, true},
+		// Постоянный отступ 1e-3 больше крошечной сцены, а вдали от начала
+		// координат меньше ошибки округления - проверка должна это замечать
+		{"tiny/absolute", 1e-4, Vec3f{}, nil, false},
+		{"far/absolute", 1, Vec3f{1e7, 0, 0}, &EpsilonPolicy{Mode: "absolute", Value: 1e-9}, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got := postProcess(renderScaled(t, scaledScene(c.k, c.shift, c.policy)), "clamp", false)
+			d, err := CompareImages(got, want, tol)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Pass != c.pass {
+				t.Errorf("pass %v, want %v: rmse %.4f, %d/%d pixels changed", d.Pass, c.pass, d.RMSE, d.Changed, d.Pixels)
+			}
+		})
+	}
+}
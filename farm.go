@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// farmTileSize - сторона тайла, который рендерит воркер фермы.
+const farmTileSize = 128
+
+// farmRequests - сколько тайлов одновременно отправляется каждому воркеру,
+// чтобы воркер не простаивал, пока передается результат предыдущего.
+const farmRequests = 2
+
+// farmJob - задание воркеру фермы: тайл кадра сцены.
+type farmJob struct {
+	Scene   json.RawMessage `json:"scene"` // Подготовленная сцена в формате файла сцены
+	Dir     string          `json:"dir"`   // Каталог ресурсов сцены ("" - сцена без файлов)
+	Options farmOptions     `json:"options"`
+	X       int             `json:"x"`
+	Y       int             `json:"y"`
+	Width   int             `json:"width"`
+	Height  int             `json:"height"`
+}
+
+// farmOptions - параметры рендера, которые влияют на пиксели тайла.
+type farmOptions struct {
+	Depth      int     `json:"depth"`
+	Samples    int     `json:"samples"`
+	MaxSamples int     `json:"maxSamples"`
+	Noise      float64 `json:"noise"`
+	Integrator string  `json:"integrator"`
+	Seed       uint64  `json:"seed"`
+	Exposure   float64 `json:"exposure"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	Jitter     bool    `json:"jitter"`
+	Wireframe  bool    `json:"wireframe"`
+}
+
+func newFarmOptions(o RenderOptions) farmOptions {
+	width, height := o.size()
+	return farmOptions{o.Depth, o.Samples, o.MaxSamples, o.Noise, o.Integrator, o.Seed, o.Exposure, width, height, o.Jitter, o.Wireframe}
+}
+
+func (f farmOptions) renderOptions() RenderOptions {
+	return RenderOptions{Depth: f.Depth, Samples: f.Samples, MaxSamples: f.MaxSamples, Noise: f.Noise, Integrator: f.Integrator, Seed: f.Seed,
+		Exposure: f.Exposure, Width: f.Width, Height: f.Height, Jitter: f.Jitter, Wireframe: f.Wireframe}
+}
+
+// check проверяет, что тайл x, y, width x height лежит в кадре, а
+// задание укладывается в ограничения сервиса рендера (см. maxServeSide):
+// параметры присылает клиент, и без проверки один запрос мог бы занять
+// память и ядра воркера без предела. Объем работы ограничивается на тайл.
+func (f farmOptions) check(x, y, width, height int) error {
+	switch {
+	case f.Width < 1 || f.Height < 1 || f.Width > maxServeSide || f.Height > maxServeSide || f.Width*f.Height > maxServePixels:
+		return fmt.Errorf("frame %dx%d exceeds %d pixels or %d on a side", f.Width, f.Height, maxServePixels, maxServeSide)
+	case width < 1 || height < 1 || width > farmTileSize || height > farmTileSize:
+		return fmt.Errorf("tile %dx%d exceeds %d on a side", width, height, farmTileSize)
+	case x < 0 || y < 0 || x+width > f.Width || y+height > f.Height:
+		return errors.New("tile outside the frame")
+	case f.Samples > maxServeSamples || f.MaxSamples > maxServeSamples:
+		return fmt.Errorf("samples exceed %d", maxServeSamples)
+	case width*height*max(1, f.Samples, f.MaxSamples) > maxServeWork:
+		return fmt.Errorf("tile exceeds %d samples", maxServeWork)
+	case f.Depth > maxServeDepth:
+		return fmt.Errorf("depth %d exceeds %d", f.Depth, maxServeDepth)
+	}
+	return nil
+}
+
+// parseFarm разбирает список адресов воркеров через запятую.
+func parseFarm(list string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(list, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// renderFarm рендерит кадр на воркерах фермы opts.Farm: кадр делится на
+// тайлы, воркеры берут их из общей очереди, и более быстрые машины
+// получают больше работы. Тайл, на котором воркер не ответил, возвращается
+// в очередь, а сам воркер больше не получает заданий; рендер завершается
+// ошибкой, только если отказали все воркеры. У каждого пикселя свой поток
+// случайных чисел, поэтому изображение совпадает с рендером на одной машине.
+//
+// Воркеры получают сцену целиком в каждом задании; текстуры и карту
+// окружения они читают сами, по тем же путям, что и координатор.
+func renderFarm(ctx context.Context, scene *Scene, opts RenderOptions) (*RenderResult, error) {
+	if len(opts.AOVs) > 0 || opts.Denoise {
+		return nil, errors.New("farm rendering supports neither AOVs nor denoising")
+	}
+	job := farmJob{Options: newFarmOptions(opts)}
+	if err := job.Options.check(0, 0, 1, 1); err != nil {
+		return nil, fmt.Errorf("farm: %w", err)
+	}
+	var err error
+	if job.Scene, err = farmScene(scene); err != nil {
+		return nil, err
+	}
+	if opts.Scene != "" {
+		if job.Dir, err = filepath.Abs(filepath.Dir(opts.Scene)); err != nil {
+			return nil, err
+		}
+	}
+
+	width, height := opts.size()
+	fb := NewFramebuffer(width, height)
+	tiles := frameTiles(width, height, farmTileSize)
+	queue := make(chan Tile, len(tiles))
+	for _, t := range tiles {
+		queue <- t
+	}
+	if opts.Progress != nil {
+		opts.Progress.Start(len(tiles))
+		defer opts.Progress.Finish()
+	}
+
+	start := time.Now()
+	var remaining atomic.Int64
+	remaining.Store(int64(len(tiles)))
+	var rays atomic.Int64
+	var mu sync.Mutex
+	var stats RenderStats
+	var lastErr error
+	var wg sync.WaitGroup
+	for _, addr := range opts.Farm {
+		for k := 0; k < farmRequests; k++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for t := range queue {
+					tileJob := job
+					tileJob.X, tileJob.Y, tileJob.Width, tileJob.Height = t.X, t.Y, t.Width, t.Height
+					pixels, st, err := requestFarmTile(ctx, addr, &tileJob)
+					if err != nil {
+						queue <- t
+						mu.Lock()
+						lastErr = fmt.Errorf("farm worker %s: %w", addr, err)
+						mu.Unlock()
+						return
+					}
+					for y := 0; y < t.Height; y++ {
+						copy(fb.Pixels[(t.Y+y)*width+t.X:], pixels[y*t.Width:(y+1)*t.Width])
+					}
+					mu.Lock()
+					stats = stats.add(st)
+					mu.Unlock()
+					total := rays.Add(st.Rays)
+					left := remaining.Add(-1)
+					if opts.Progress != nil {
+						opts.Progress.Update(len(tiles)-int(left), total)
+					}
+					if left == 0 {
+						close(queue)
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	res := &RenderResult{Image: fb, Rays: stats.Rays, Duration: time.Since(start), Stats: stats}
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	if remaining.Load() > 0 {
+		return res, lastErr
+	}
+	return res, nil
+}
+
+// farmScene возвращает подготовленную сцену в формате файла сцены. Россыпь
+// сфер уже добавлена в Spheres, поэтому Drop не передается.
+func farmScene(s *Scene) ([]byte, error) {
+	out := *s
+	out.Drop = nil
+	return json.Marshal(&out)
+}
+
+// farmStatsHeader - заголовок ответа воркера со статистикой тайла в JSON.
+const farmStatsHeader = "X-Render-Stats"
+
+// requestFarmTile отправляет задание воркеру addr и возвращает пиксели
+// тайла и статистику его рендера.
+func requestFarmTile(ctx context.Context, addr string, job *farmJob) ([]Vec3f, RenderStats, error) {
+	var st RenderStats
+	body, err := json.Marshal(job)
+	if err != nil {
+		return nil, st, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+addr+"/tile", bytes.NewReader(body))
+	if err != nil {
+		return nil, st, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, st, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, st, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	pixels := make([]Vec3f, job.Width*job.Height)
+	if err := binary.Read(resp.Body, binary.LittleEndian, pixels); err != nil {
+		return nil, st, err
+	}
+	json.Unmarshal([]byte(resp.Header.Get(farmStatsHeader)), &st)
+	return pixels, st, nil
+}
+
+// maxFarmJobBytes - наибольший размер задания воркеру фермы.
+const maxFarmJobBytes = 64 << 20
+
+// farmWorker - воркер фермы: рендерит присланные тайлы на всех ядрах.
+// Файлы ресурсов сцен читаются только из каталога root, заданного при
+// запуске воркера: каталог сцены в задании присылает клиент, и без этого
+// ограничения любой клиент мог бы прочитать файлы машины воркера.
+type farmWorker struct {
+	pool *WorkerPool
+	root string // Каталог ресурсов сцен ("" - только сцены без файлов)
+
+	mu    sync.Mutex
+	key   [sha256.Size]byte // Последняя разобранная сцена: тайлы одного
+	scene *Scene            // кадра приходят с одной и той же сценой
+}
+
+// serveFarmWorker запускает воркер фермы на адресе addr и работает до
+// отмены ctx. Сцены воркера могут читать файлы только из каталога root
+// (пусто - сцены без файлов).
+func serveFarmWorker(ctx context.Context, addr, root string) error {
+	if root != "" {
+		var err error
+		if root, err = filepath.Abs(root); err == nil {
+			root, err = filepath.EvalSymlinks(root)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	w := &farmWorker{pool: NewWorkerPool(runtime.NumCPU()), root: root}
+	defer w.pool.Close()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tile", route("/tile", w.handleTile, http.MethodPost))
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	err := srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
+
+// sceneFor возвращает сцену задания, разбирая ее только при смене сцены.
+func (w *farmWorker) sceneFor(job *farmJob) (*Scene, error) {
+	key := sha256.Sum256(append([]byte(job.Dir+"\x00"), job.Scene...))
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.scene != nil && key == w.key {
+		return w.scene, nil
+	}
+	name := "farm scene"
+	if job.Dir != "" {
+		name = job.Dir
+		if w.root == "" {
+			return nil, errors.New("worker has no -worker-root: scenes may not reference files")
+		}
+	}
+	scene, err := parseScene(job.Scene, name, job.Dir, w.root)
+	if err != nil {
+		return nil, err
+	}
+	w.key, w.scene = key, scene
+	return scene, nil
+}
+
+func (w *farmWorker) handleTile(rw http.ResponseWriter, r *http.Request) {
+	var job farmJob
+	if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, maxFarmJobBytes)).Decode(&job); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := job.Options.check(job.X, job.Y, job.Width, job.Height); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := job.Options.renderOptions()
+	scene, err := w.sceneFor(&job)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Строки тайла распределяются между горутинами пула
+	stats := newRenderStats(opts.Depth)
+	pr := newPixelRenderer(scene.withStats(stats), opts)
+	t := Tile{X: job.X, Y: job.Y, Width: job.Width, Height: job.Height, Pixels: make([]Vec3f, job.Width*job.Height)}
+	rows := func(y0, y1 int, row func(y int, s *pixelSampler)) {
+		var wg sync.WaitGroup
+		for y := y0; y < y1; y++ {
+			wg.Add(1)
+			w.pool.Submit(func() {
+				defer wg.Done()
+				if r.Context().Err() == nil {
+					pr.isolate(fmt.Sprintf("row %d", y), pr.newSampler(), func(s *pixelSampler) { row(y, s) })
+				}
+			})
+		}
+		wg.Wait()
+	}
+	if pr.adaptive() {
+		// Оба прохода адаптивного рендера идут по строкам тайла параллельно
+		a := pr.newAdaptiveRect(&t)
+		rows(a.y0, a.y1, func(y int, s *pixelSampler) { a.sampleRow(pr, y, s) })
+		rows(t.Y, t.Y+t.Height, func(y int, s *pixelSampler) {
+			for x := 0; x < t.Width; x++ {
+				t.Pixels[(y-t.Y)*t.Width+x] = a.refinePixel(pr, t.X+x, y, s)
+			}
+		})
+	} else {
+		rows(0, t.Height, func(y int, s *pixelSampler) { pr.renderRows(&t, y, y+1, s) })
+	}
+	if r.Context().Err() != nil {
+		return
+	}
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	st, _ := json.Marshal(stats.snapshot())
+	rw.Header().Set(farmStatsHeader, string(st))
+	binary.Write(rw, binary.LittleEndian, t.Pixels)
+}
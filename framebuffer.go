@@ -0,0 +1,32 @@
+package main
+
+import "image"
+
+// Framebuffer - изображение с цветами в плавающей точке, до постобработки.
+type Framebuffer struct {
+	Width, Height int
+	Pixels        []Vec3f // Построчно, сверху вниз
+}
+
+func NewFramebuffer(width, height int) *Framebuffer {
+	return &Framebuffer{Width: width, Height: height, Pixels: make([]Vec3f, width*height)}
+}
+
+// At возвращает цвет пикселя (i, j).
+func (f *Framebuffer) At(i, j int) Vec3f {
+	return f.Pixels[j*f.Width+i]
+}
+
+// Set задает цвет пикселя (i, j).
+func (f *Framebuffer) Set(i, j int, c Vec3f) {
+	f.Pixels[j*f.Width+i] = c
+}
+
+// crop возвращает копию прямоугольника rect буфера.
+func (f *Framebuffer) crop(rect image.Rectangle) *Framebuffer {
+	out := NewFramebuffer(rect.Dx(), rect.Dy())
+	for y := 0; y < out.Height; y++ {
+		copy(out.Pixels[y*out.Width:(y+1)*out.Width], f.Pixels[(rect.Min.Y+y)*f.Width+rect.Min.X:])
+	}
+	return out
+}
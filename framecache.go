@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FrameCache хранит тайлы предыдущего кадра анимации: следующий кадр
+// рендерит заново только тайлы, которых могли коснуться изменения сцены,
+// а остальные берет отсюда (см. renderCached).
+//
+// Для каждого тайла запоминается объем, который занимают все отрезки его
+// лучей - первичных, вторичных и теневых. У каждого пикселя свой поток
+// случайных чисел, поэтому, если ни старое, ни новое положение изменившихся
+// объектов не задевает этот объем, лучи тайла в новом кадре пересекают то
+// же самое, и пиксели совпадают с пикселями нового рендера до бита. Камера,
+// источники, фон, среда и параметры рендера должны совпадать, иначе кадр
+// рендерится целиком.
+type FrameCache struct {
+	key     string   // Сцена без объектов и параметры рендера (см. frameCacheKey)
+	objects []string // Описания объектов (см. objectKeys)
+	boxes   []AABB   // Параллелепипеды объектов
+	tiles   []cachedTile
+}
+
+// cachedTile - тайл кадра и объем, который занимают отрезки его лучей.
+type cachedTile struct {
+	Tile
+	volume AABB
+}
+
+// emptyBox - пустой параллелепипед, начальное значение для union.
+var emptyBox = AABB{Min: Vec3f{math.Inf(1), math.Inf(1), math.Inf(1)}, Max: Vec3f{math.Inf(-1), math.Inf(-1), math.Inf(-1)}}
+
+// overlaps сообщает, пересекаются ли параллелепипеды (с запасом boxPad).
+func (b AABB) overlaps(o AABB) bool {
+	return b.Min.X <= o.Max.X+boxPad && o.Min.X <= b.Max.X+boxPad &&
+		b.Min.Y <= o.Max.Y+boxPad && o.Min.Y <= b.Max.Y+boxPad &&
+		b.Min.Z <= o.Max.Z+boxPad && o.Min.Z <= b.Max.Z+boxPad
+}
+
+// probeSegment добавляет к объему лучей отрезок луча из orig по направлению
+// dir длиной dist (dist может быть бесконечным).
+func (s *Scene) probeSegment(orig, dir Vec3f, dist float64) {
+	if s.probe == nil || s.probe.volume == nil {
+		return
+	}
+	end := func(o, d float64) float64 {
+		if d == 0 {
+			return o
+		}
+		return o + d*dist
+	}
+	e := Vec3f{end(orig.X, dir.X), end(orig.Y, dir.Y), end(orig.Z, dir.Z)}
+	seg := AABB{Min: orig, Max: orig}.union(AABB{Min: e, Max: e})
+	*s.probe.volume = s.probe.volume.union(seg)
+}
+
+// objectKeys возвращает описания объектов s.objects (в порядке build) в
+// формате файла сцены: у совпадающих объектов описания равны. Описание
+// экземпляра включает описание его примитива.
+func (s *Scene) objectKeys() []string {
+	var keys []string
+	add := func(v any) {
+		data, _ := json.Marshal(v)
+		keys = append(keys, string(data))
+	}
+	for i := range s.Spheres {
+		add(&s.Spheres[i])
+	}
+	for i := range s.Cylinders {
+		add(&s.Cylinders[i])
+	}
+	for i := range s.Cones {
+		add(&s.Cones[i])
+	}
+	for i := range s.Tori {
+		add(&s.Tori[i])
+	}
+	for i := range s.Planes {
+		add(&s.Planes[i])
+	}
+	primitives := len(keys)
+	for i := range s.Instances {
+		if k := s.Instances[i].Object; k >= 0 && k < primitives {
+			add(&s.Instances[i])
+			keys[len(keys)-1] += keys[k]
+		}
+	}
+	return keys
+}
+
+// frameCacheKey описывает все, кроме объектов, от чего зависят пиксели:
+// сцену без объектов и параметры рендера.
+func frameCacheKey(s *Scene, opts RenderOptions) string {
+	rest := *s
+	rest.Spheres, rest.Cylinders, rest.Cones, rest.Tori, rest.Planes, rest.Instances = nil, nil, nil, nil, nil, nil
+	rest.Drop = nil
+	data, _ := json.Marshal(struct {
+		Scene   *Scene
+		Options farmOptions
+	}{&rest, newFarmOptions(opts)})
+	return string(data)
+}
+
+// renderCached рендерит кадр по тайлам, беря из opts.Cache тайлы, которых
+// не коснулись изменения сцены с предыдущего кадра, и обновляет кэш.
+// Прерванный рендер кэш не меняет.
+func renderCached(ctx context.Context, scene *Scene, opts RenderOptions) (*RenderResult, error) {
+	cache := opts.Cache
+	key := frameCacheKey(scene, opts)
+	objects := scene.objectKeys()
+	// Параллелепипеды старых и новых положений изменившихся объектов
+	var changed []AABB
+	reuse := cache.key == key && len(cache.objects) == len(objects)
+	for i := range objects {
+		if reuse && objects[i] != cache.objects[i] {
+			changed = append(changed, cache.boxes[i], scene.boxes[i])
+		}
+	}
+
+	width, height := opts.size()
+	fb := NewFramebuffer(width, height)
+	tiles := frameTiles(width, height, tileSize)
+	next := make([]cachedTile, len(tiles))
+	stats := newRenderStats(opts.Depth)
+	pr := newPixelRenderer(scene.withStats(stats), opts)
+	if opts.Progress != nil {
+		opts.Progress.Start(len(tiles))
+		defer opts.Progress.Finish()
+	}
+	pool := opts.Pool
+	if pool == nil {
+		pool = NewWorkerPool(runtime.NumCPU())
+		defer pool.Close()
+	}
+
+	start := time.Now()
+	var done atomic.Int64
+	reused := 0
+	put := func(t *Tile) {
+		for y := 0; y < t.Height; y++ {
+			copy(fb.Pixels[(t.Y+y)*width+t.X:], t.Pixels[y*t.Width:(y+1)*t.Width])
+		}
+	}
+	var wg sync.WaitGroup
+	for k, t := range tiles {
+		if reuse && !slices.ContainsFunc(changed, cache.tiles[k].volume.overlaps) {
+			next[k] = cache.tiles[k]
+			put(&next[k].Tile)
+			reused++
+			done.Add(1)
+			continue
+		}
+		wg.Add(1)
+		pool.Submit(func() {
+			defer wg.Done()
+			if ctx.Err() != nil {
+				return
+			}
+			volume := emptyBox
+			t.Pixels = make([]Vec3f, t.Width*t.Height)
+			pr.isolate(t.area(), pr.newSampler(), func(s *pixelSampler) {
+				s.probe.volume = &volume
+				pr.renderRows(&t, 0, t.Height, s)
+			})
+			next[k] = cachedTile{t, volume}
+			put(&t)
+			n := done.Add(1)
+			if opts.Progress != nil {
+				opts.Progress.Update(int(n), stats.rays.Load())
+			}
+		})
+	}
+	wg.Wait()
+
+	st := stats.snapshot()
+	res := &RenderResult{Image: fb, Rays: st.Rays, Duration: time.Since(start), Stats: st, Reused: reused}
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	boxes := slices.Clone(scene.boxes)
+	*cache = FrameCache{key: key, objects: objects, boxes: boxes, tiles: next}
+	return res, nil
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"slices"
+)
+
+// Hittable - геометрический примитив, который умеет пересекаться с лучом.
+type Hittable interface {
+	// RayIntersect возвращает расстояние до ближайшего пересечения с лучом
+	// (dir - единичный вектор); пересечения позади начала луча не считаются.
+	RayIntersect(orig, dir Vec3f) (bool, float64)
+	normalAt(point Vec3f) Vec3f        // Внешняя единичная нормаль в точке поверхности
+	uv(point Vec3f) (float64, float64) // Текстурные координаты точки поверхности
+	bounds() AABB                      // Ограничивающий параллелепипед
+	material() *Material               // Материал поверхности
+}
+
+// AABB - параллелепипед, выровненный по осям координат.
+type AABB struct {
+	Min, Max Vec3f
+}
+
+// union возвращает параллелепипед, содержащий оба параллелепипеда.
+func (b AABB) union(o AABB) AABB {
+	return AABB{
+		Min: Vec3f{math.Min(b.Min.X, o.Min.X), math.Min(b.Min.Y, o.Min.Y), math.Min(b.Min.Z, o.Min.Z)},
+		Max: Vec3f{math.Max(b.Max.X, o.Max.X), math.Max(b.Max.Y, o.Max.Y), math.Max(b.Max.Z, o.Max.Z)},
+	}
+}
+
+// isFinite сообщает, ограничен ли параллелепипед.
+func (b AABB) isFinite() bool {
+	for _, x := range [6]float64{b.Min.X, b.Min.Y, b.Min.Z, b.Max.X, b.Max.Y, b.Max.Z} {
+		if math.IsInf(x, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// center возвращает центр параллелепипеда.
+func (b AABB) center() Vec3f {
+	return b.Min.Add(b.Max).MulScalar(0.5)
+}
+
+// boxPad - запас, на который расширяется параллелепипед при проверке
+// луча: луч, касающийся объекта, не должен отсекаться из-за погрешности.
+const boxPad = 1e-6
+
+// hitRay сообщает, пересекает ли луч orig + t*dir параллелепипед при
+// 0 <= t <= tMax. invDir - покомпонентно обратное направление луча.
+// Проверка грубая: она только отсекает объекты, в которые луч точно не попадет.
+func (b AABB) hitRay(orig, invDir Vec3f, tMax float64) bool {
+	tMin := 0.0
+	// slab сужает [tMin, tMax] до отрезка луча между плоскостями одной оси.
+	// Сравнения с NaN ложны: луч, параллельный граням и лежащий в плоскости
+	// одной из них, по этой оси не отсекается
+	slab := func(lo, hi, o, inv float64) bool {
+		t1, t2 := (lo-boxPad-o)*inv, (hi+boxPad-o)*inv
+		if t1 > t2 {
+			t1, t2 = t2, t1
+		}
+		if t1 > tMin {
+			tMin = t1
+		}
+		if t2 < tMax {
+			tMax = t2
+		}
+		return tMin <= tMax
+	}
+	return slab(b.Min.X, b.Max.X, orig.X, invDir.X) &&
+		slab(b.Min.Y, b.Max.Y, orig.Y, invDir.Y) &&
+		slab(b.Min.Z, b.Max.Z, orig.Z, invDir.Z)
+}
+
+// isCutout сообщает, вырезана ли точка поверхности альфа-маской текстуры.
+func isCutout(obj Hittable, point Vec3f) bool {
+	m := obj.material()
+	if m.texture == nil || m.AlphaCutoff <= 0 {
+		return false
+	}
+	return m.texture.AlphaAt(objectUV(obj, point)) < m.AlphaCutoff
+}
+
+// surfaceColor возвращает цвет поверхности в точке с учетом текстуры.
+func surfaceColor(obj Hittable, point Vec3f) Vec3f {
+	m := obj.material()
+	if m.texture == nil {
+		return m.Color
+	}
+	return m.texture.At(objectUV(obj, point))
+}
+
+// firstHit выбирает из расстояний-кандидатов ts ближайшее неотрицательное,
+// точка которого не вырезана альфа-маской.
+func firstHit(obj Hittable, orig, dir Vec3f, ts []float64) (bool, float64) {
+	slices.Sort(ts)
+	for _, t := range ts {
+		if t < 0 || isCutout(obj, orig.Add(dir.MulScalar(t))) {
+			continue
+		}
+		return true, t
+	}
+	return false, 0
+}
+
+// Cylinder - закрытый цилиндр с осью, параллельной Y.
+type Cylinder struct {
+	Center Vec3f   `json:"center"` // Середина оси
+	Radius float64 `json:"radius"`
+	Height float64 `json:"height"`
+	Material
+	Animation *ObjectAnimation `json:"animation,omitempty"`
+	Transform *Transform       `json:"transform,omitempty"`
+	Motion    *Vec3f           `json:"motion,omitempty"`
+}
+
+// checkShape проверяет размеры цилиндра.
+func (c *Cylinder) checkShape() error {
+	if c.Radius <= 0 || c.Height <= 0 {
+		return errors.New("radius and height must be positive")
+	}
+	return nil
+}
+
+func (c *Cylinder) RayIntersect(orig, dir Vec3f) (bool, float64) {
+	p := orig.Subtract(c.Center)
+	half := c.Height / 2
+	var buf [4]float64
+	ts := buf[:0]
+	// Боковая поверхность: x^2 + z^2 = r^2, |y| <= h/2
+	a := dir.X*dir.X + dir.Z*dir.Z
+	b := p.X*dir.X + p.Z*dir.Z
+	cc := p.X*p.X + p.Z*p.Z - c.Radius*c.Radius
+	if disc := b*b - a*cc; a > 1e-12 && disc >= 0 {
+		sq := math.Sqrt(disc)
+		for _, t := range [2]float64{(-b - sq) / a, (-b + sq) / a} {
+			if y := p.Y + t*dir.Y; math.Abs(y) <= half {
+				ts = append(ts, t)
+			}
+		}
+	}
+	// Торцы: y = ±h/2 внутри круга радиуса r
+	if math.Abs(dir.Y) > 1e-12 {
+		for _, y := range [2]float64{-half, half} {
+			t := (y - p.Y) / dir.Y
+			x, z := p.X+t*dir.X, p.Z+t*dir.Z
+			if x*x+z*z <= c.Radius*c.Radius {
+				ts = append(ts, t)
+			}
+		}
+	}
+	return firstHit(c, orig, dir, ts)
+}
+
+// onCap сообщает, лежит ли точка (в координатах относительно середины оси)
+// на торце y = capY.
+func onCap(p Vec3f, capY, radius float64) bool {
+	return math.Abs(p.Y-capY) < 1e-9*(1+math.Abs(capY)) && p.X*p.X+p.Z*p.Z < radius*radius*(1-1e-9)
+}
+
+func (c *Cylinder) normalAt(point Vec3f) Vec3f {
+	p := point.Subtract(c.Center)
+	switch {
+	case onCap(p, c.Height/2, c.Radius):
+		return Vec3f{0, 1, 0}
+	case onCap(p, -c.Height/2, c.Radius):
+		return Vec3f{0, -1, 0}
+	}
+	return Vec3f{p.X, 0, p.Z}.Normalize()
+}
+
+// uv: боковая поверхность развертывается по углу и высоте (v = 0 сверху),
+// торцы - плоской проекцией на XZ.
+func (c *Cylinder) uv(point Vec3f) (float64, float64) {
+	p := point.Subtract(c.Center)
+	if onCap(p, c.Height/2, c.Radius) || onCap(p, -c.Height/2, c.Radius) {
+		return 0.5 + p.X/(2*c.Radius), 0.5 + p.Z/(2*c.Radius)
+	}
+	return 0.5 + math.Atan2(p.Z, p.X)/(2*math.Pi), 0.5 - p.Y/c.Height
+}
+
+func (c *Cylinder) bounds() AABB {
+	e := Vec3f{c.Radius, c.Height / 2, c.Radius}
+	return AABB{Min: c.Center.Subtract(e), Max: c.Center.Add(e)}
+}
+
+func (c *Cylinder) material() *Material { return &c.Material }
+
+// Cone - закрытый конус с осью, параллельной Y, и вершиной сверху.
+type Cone struct {
+	Center Vec3f   `json:"center"` // Центр основания
+	Radius float64 `json:"radius"` // Радиус основания
+	Height float64 `json:"height"`
+	Material
+	Animation *ObjectAnimation `json:"animation,omitempty"`
+	Transform *Transform       `json:"transform,omitempty"`
+	Motion    *Vec3f           `json:"motion,omitempty"`
+}
+
+// checkShape проверяет размеры конуса.
+func (c *Cone) checkShape() error {
+	if c.Radius <= 0 || c.Height <= 0 {
+		return errors.New("radius and height must be positive")
+	}
+	return nil
+}
+
+func (c *Cone) RayIntersect(orig, dir Vec3f) (bool, float64) {
+	p := orig.Subtract(c.Center)
+	k := c.Radius / c.Height
+	k2 := k * k
+	var buf [4]float64
+	ts := buf[:0]
+	// Боковая поверхность: x^2 + z^2 = k^2 (h - y)^2, 0 <= y <= h
+	hy := c.Height - p.Y
+	a := dir.X*dir.X + dir.Z*dir.Z - k2*dir.Y*dir.Y
+	b := p.X*dir.X + p.Z*dir.Z + k2*hy*dir.Y
+	cc := p.X*p.X + p.Z*p.Z - k2*hy*hy
+	if math.Abs(a) > 1e-12 {
+		if disc := b*b - a*cc; disc >= 0 {
+			sq := math.Sqrt(disc)
+			for _, t := range [2]float64{(-b - sq) / a, (-b + sq) / a} {
+				if y := p.Y + t*dir.Y; y >= 0 && y <= c.Height {
+					ts = append(ts, t)
+				}
+			}
+		}
+	} else if math.Abs(b) > 1e-12 {
+		// Луч параллелен образующей - одно пересечение
+		t := -cc / (2 * b)
+		if y := p.Y + t*dir.Y; y >= 0 && y <= c.Height {
+			ts = append(ts, t)
+		}
+	}
+	// Основание: y = 0 внутри круга радиуса r
+	if math.Abs(dir.Y) > 1e-12 {
+		t := -p.Y / dir.Y
+		x, z := p.X+t*dir.X, p.Z+t*dir.Z
+		if x*x+z*z <= c.Radius*c.Radius {
+			ts = append(ts, t)
+		}
+	}
+	return firstHit(c, orig, dir, ts)
+}
+
+func (c *Cone) normalAt(point Vec3f) Vec3f {
+	p := point.Subtract(c.Center)
+	if onCap(p, 0, c.Radius) {
+		return Vec3f{0, -1, 0}
+	}
+	// Градиент x^2 + z^2 - k^2 (h - y)^2
+	k := c.Radius / c.Height
+	n := Vec3f{p.X, k * k * (c.Height - p.Y), p.Z}
+	if n.Length2() == 0 {
+		return Vec3f{0, 1, 0} // Вершина
+	}
+	return n.Normalize()
+}
+
+// uv: боковая поверхность развертывается по углу и высоте (v = 0 у вершины),
+// основание - плоской проекцией на XZ.
+func (c *Cone) uv(point Vec3f) (float64, float64) {
+	p := point.Subtract(c.Center)
+	if onCap(p, 0, c.Radius) {
+		return 0.5 + p.X/(2*c.Radius), 0.5 + p.Z/(2*c.Radius)
+	}
+	return 0.5 + math.Atan2(p.Z, p.X)/(2*math.Pi), 1 - p.Y/c.Height
+}
+
+func (c *Cone) bounds() AABB {
+	return AABB{
+		Min: c.Center.Subtract(Vec3f{c.Radius, 0, c.Radius}),
+		Max: c.Center.Add(Vec3f{c.Radius, c.Height, c.Radius}),
+	}
+}
+
+func (c *Cone) material() *Material { return &c.Material }
+
+// Torus - тор, лежащий в плоскости XZ.
+type Torus struct {
+	Center      Vec3f   `json:"center"`
+	MajorRadius float64 `json:"majorRadius"` // Расстояние от центра до оси трубки
+	MinorRadius float64 `json:"minorRadius"` // Радиус трубки
+	Material
+	Animation *ObjectAnimation `json:"animation,omitempty"`
+	Transform *Transform       `json:"transform,omitempty"`
+	Motion    *Vec3f           `json:"motion,omitempty"`
+}
+
+// checkShape проверяет радиусы тора: трубка тоньше кольца, иначе тор
+// самопересекается.
+func (t *Torus) checkShape() error {
+	if t.MinorRadius <= 0 || t.MajorRadius <= t.MinorRadius {
+		return errors.New("need 0 < minorRadius < majorRadius")
+	}
+	return nil
+}
+
+func (t *Torus) RayIntersect(orig, dir Vec3f) (bool, float64) {
+	// Начало луча переносится к ограничивающей сфере: корни уравнения
+	// четвертой степени теряют точность, если начало далеко от тора
+	p := orig.Subtract(t.Center)
+	bound := t.MajorRadius + t.MinorRadius
+	shift := 0.0
+	if d := p.Length() - bound; d > 0 {
+		shift = d
+		p = p.Add(dir.MulScalar(shift))
+	}
+
+	// (|p|^2 + R^2 - r^2)^2 = 4 R^2 (x^2 + z^2)
+	R2, r2 := t.MajorRadius*t.MajorRadius, t.MinorRadius*t.MinorRadius
+	dd := dir.Dot(dir)
+	e := p.Dot(p) - R2 - r2
+	f := p.Dot(dir)
+	fourR2 := 4 * R2
+	coeffs := [5]float64{
+		e*e - fourR2*(r2-p.Y*p.Y),
+		4*f*e + 2*fourR2*p.Y*dir.Y,
+		2*dd*e + 4*f*f + fourR2*dir.Y*dir.Y,
+		4 * dd * f,
+		dd * dd,
+	}
+	var buf [4]float64
+	roots := solveQuartic(coeffs, buf[:0])
+	for i := range roots {
+		roots[i] += shift
+	}
+	return firstHit(t, orig, dir, roots)
+}
+
+func (t *Torus) normalAt(point Vec3f) Vec3f {
+	p := point.Subtract(t.Center)
+	ring := Vec3f{p.X, 0, p.Z}
+	if ring.Length2() == 0 {
+		return Vec3f{0, 1, 0}
+	}
+	// Нормаль направлена от ближайшей точки оси трубки
+	return p.Subtract(ring.Normalize().MulScalar(t.MajorRadius)).Normalize()
+}
+
+// uv: u - угол вокруг оси тора, v - угол вокруг трубки.
+func (t *Torus) uv(point Vec3f) (float64, float64) {
+	p := point.Subtract(t.Center)
+	u := 0.5 + math.Atan2(p.Z, p.X)/(2*math.Pi)
+	v := 0.5 + math.Atan2(p.Y, math.Hypot(p.X, p.Z)-t.MajorRadius)/(2*math.Pi)
+	return u, v
+}
+
+func (t *Torus) bounds() AABB {
+	e := Vec3f{t.MajorRadius + t.MinorRadius, t.MinorRadius, t.MajorRadius + t.MinorRadius}
+	return AABB{Min: t.Center.Subtract(e), Max: t.Center.Add(e)}
+}
+
+func (t *Torus) material() *Material { return &t.Material }
+
+// Plane - бесконечная плоскость.
+type Plane struct {
+	Center Vec3f `json:"center"` // Любая точка плоскости
+	Normal Vec3f `json:"normal"`
+	Material
+}
+
+// checkShape проверяет нормаль плоскости.
+func (p *Plane) checkShape() error {
+	if p.Normal.Length2() == 0 {
+		return errors.New("zero normal")
+	}
+	return nil
+}
+
+func (p *Plane) RayIntersect(orig, dir Vec3f) (bool, float64) {
+	n := p.Normal.Normalize()
+	denom := dir.Dot(n)
+	if math.Abs(denom) < 1e-12 {
+		return false, 0
+	}
+	t := p.Center.Subtract(orig).Dot(n) / denom
+	return firstHit(p, orig, dir, []float64{t})
+}
+
+func (p *Plane) normalAt(Vec3f) Vec3f {
+	return p.Normal.Normalize()
+}
+
+// uv: текстура повторяется с шагом 1 вдоль двух осей плоскости.
+func (p *Plane) uv(point Vec3f) (float64, float64) {
+	t, b := orthonormalBasis(p.Normal.Normalize())
+	d := point.Subtract(p.Center)
+	u, v := d.Dot(t), d.Dot(b)
+	return u - math.Floor(u), v - math.Floor(v)
+}
+
+// bounds плоскости не ограничены.
+func (p *Plane) bounds() AABB {
+	inf := math.Inf(1)
+	return AABB{Min: Vec3f{-inf, -inf, -inf}, Max: Vec3f{inf, inf, inf}}
+}
+
+func (p *Plane) material() *Material { return &p.Material }
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// goldenWidth, goldenHeight - размер кадра эталонных изображений.
+const goldenWidth, goldenHeight = 128, 96
+
+// goldenCases - эталонные сцены регрессионной проверки (TestGolden и флаг
+// -golden): каждая рендерится в маленький кадр и сравнивается со своим
+// изображением из каталога эталонов. Сцены покрывают затенение, пересечения с большим
+// числом объектов, источники света и стохастические интеграторы, чтобы
+// изменения в них не меняли картинку незаметно.
+var goldenCases = []struct {
+	name  string
+	scene func() *Scene
+	opts  RenderOptions
+}{
+	{"default", defaultScene, RenderOptions{Depth: 200, Integrator: "whitted"}},
+	{"primitives", primitivesScene, RenderOptions{Depth: 200, Integrator: "whitted"}},
+	{"spheres", spheresScene, RenderOptions{Depth: 200, Integrator: "whitted"}},
+	{"lights", lightsScene, RenderOptions{Depth: 200, Integrator: "whitted", Samples: 4, Seed: 1}},
+	{"primitives-path", primitivesScene, RenderOptions{Depth: 8, Integrator: "path", Samples: 16, Seed: 1}},
+	{"default-adaptive", defaultScene, RenderOptions{Depth: 8, Integrator: "path", Samples: 4, MaxSamples: 16, Noise: 0.05, Seed: 1}},
+}
+
+// lightsScene возвращает сцену со всеми типами источников: цветными
+// точечными с ослаблением, протяженным, удаленным и куполом неба.
+func lightsScene() *Scene {
+	mat := func(r, g, b float64) Material {
+		return Material{Color: Vec3f{r, g, b}, Albedo: 0.9, SpecularExponent: 30}
+	}
+	red, blue := Vec3f{1, 0.4, 0.2}, Vec3f{0.2, 0.4, 1}
+	scene := &Scene{
+		Spheres: []Sphere{
+			{Center: Vec3f{-1.5, 0, -7}, Radius: 1, Material: mat(0.8, 0.8, 0.8)},
+			{Center: Vec3f{1.5, 0, -7}, Radius: 1, Material: Material{Color: Vec3f{0.9, 0.6, 0.3}, BRDF: "ggx", Roughness: 0.3, Metalness: 1}},
+		},
+		Planes: []Plane{{Center: Vec3f{0, -1, 0}, Normal: Vec3f{0, 1, 0}, Material: mat(0.6, 0.6, 0.6)}},
+		Lights: []PointLight{
+			{Position: Vec3f{-3, 3, -5}, Intensity: 4, Color: &red, Falloff: "inverse-square"},
+			{Position: Vec3f{3, 3, -5}, Intensity: 1, Color: &blue, Falloff: "inverse-linear"},
+			{Position: Vec3f{0, 4, -7}, Intensity: 0.3, Shape: "rect", U: Vec3f{2, 0, 0}, V: Vec3f{0, 0, 2}, Samples: 4},
+		},
+		DirectionalLights: []DirectionalLight{{Direction: Vec3f{1, -1, -1}, Intensity: 0.2}},
+		DomeLights:        []DomeLight{{Intensity: 0.2, Samples: 4}},
+		Background:        Vec3f{0.2, 0.7, 0.8},
+	}
+	scene.build()
+	return scene
+}
+
+// primitivesScene возвращает сцену со всеми типами примитивов на плоском полу.
+func primitivesScene() *Scene {
+	mat := func(r, g, b float64) Material {
+		return Material{Color: Vec3f{r, g, b}, Albedo: 0.7, SpecularExponent: 50}
+	}
+	scene := &Scene{
+		Spheres:    []Sphere{{Center: Vec3f{0, 1.8, -9}, Radius: 0.8, Material: mat(0.8, 0.8, 0.2)}},
+		Cylinders:  []Cylinder{{Center: Vec3f{-3, 0, -9}, Radius: 0.8, Height: 2, Material: mat(0.8, 0.2, 0.2)}},
+		Cones:      []Cone{{Center: Vec3f{0, -1, -9}, Radius: 1, Height: 2.5, Material: mat(0.2, 0.8, 0.2)}},
+		Tori:       []Torus{{Center: Vec3f{3, 0, -9}, MajorRadius: 1, MinorRadius: 0.35, Material: mat(0.2, 0.3, 0.9)}},
+		Planes:     []Plane{{Center: Vec3f{0, -1, 0}, Normal: Vec3f{0, 1, 0}, Material: Material{Color: Vec3f{0.5, 0.5, 0.5}, Albedo: 0.9, SpecularExponent: 10}}},
+		Lights:     []PointLight{*NewPointLight(Vec3f{-5, 8, 2}, 1.5)},
+		Background: Vec3f{0.2, 0.7, 0.8},
+	}
+	scene.build()
+	return scene
+}
+
+// spheresScene возвращает сцену из сотни сфер, насыпанных на пол.
+func spheresScene() *Scene {
+	scene := &Scene{
+		Spheres:    dropSpheres(&SphereDrop{Count: 100, Seed: 1, Center: Vec3f{0, -1, -9}, Size: 2, MinRadius: 0.2, MaxRadius: 0.5}, nil),
+		Planes:     []Plane{{Center: Vec3f{0, -1, 0}, Normal: Vec3f{0, 1, 0}, Material: Material{Color: Vec3f{0.5, 0.5, 0.5}, Albedo: 0.9, SpecularExponent: 10}}},
+		Lights:     []PointLight{*NewPointLight(Vec3f{-5, 8, 2}, 1.5)},
+		Background: Vec3f{0.2, 0.7, 0.8},
+	}
+	scene.build()
+	return scene
+}
+
+// ImageTolerance - допустимое отличие изображения от эталона (см. CompareImages).
+type ImageTolerance struct {
+	RMSE    float64 // Наибольшая среднеквадратичная ошибка пикселей
+	Pixel   float64 // Ошибка, начиная с которой пиксель считается изменившимся
+	Changed float64 // Наибольшая доля изменившихся пикселей
+}
+
+// goldenTolerance - допуск регрессионной проверки: пропускает расхождения
+// округления между платформами (например, из-за FMA), но не изменения
+// затенения или сэмплирования.
+var goldenTolerance = ImageTolerance{RMSE: 0.002, Pixel: 0.05, Changed: 0.002}
+
+// ImageDiff - результат сравнения изображения с эталоном.
+type ImageDiff struct {
+	RMSE    float64 // Среднеквадратичная ошибка пикселей
+	Max     float64 // Наибольшая ошибка пикселя
+	Changed int     // Число пикселей с ошибкой не меньше ImageTolerance.Pixel
+	Pixels  int     // Число пикселей
+	Pass    bool    // Отличие в пределах допуска
+}
+
+// CompareImages сравнивает изображение got с эталоном want того же размера.
+// Ошибка пикселя - расстояние между цветами в 8-битном пространстве
+// изображения (с гаммой, как их видит глаз), каналы которого взвешены по
+// вкладу в яркость: заметный сдвиг зеленого весит больше, чем такой же
+// сдвиг синего. Каналы нормированы к [0, 1].
+//
+// Сравнение живет в пакете main, а не в отдельном пакете imagetest: у
+// репозитория нет go.mod, и без пути модуля другие пакеты из его
+// каталогов не импортируются. Эталоны проверяет TestGolden (go test), а
+// вне тестов - флаг -golden.
+func CompareImages(got, want image.Image, tol ImageTolerance) (ImageDiff, error) {
+	gb, wb := got.Bounds(), want.Bounds()
+	if gb.Dx() != wb.Dx() || gb.Dy() != wb.Dy() {
+		return ImageDiff{}, fmt.Errorf("size %dx%d, want %dx%d", gb.Dx(), gb.Dy(), wb.Dx(), wb.Dy())
+	}
+	d := ImageDiff{Pixels: gb.Dx() * gb.Dy()}
+	sum := 0.0
+	for y := 0; y < gb.Dy(); y++ {
+		for x := 0; x < gb.Dx(); x++ {
+			r1, g1, b1, _ := got.At(gb.Min.X+x, gb.Min.Y+y).RGBA()
+			r2, g2, b2, _ := want.At(wb.Min.X+x, wb.Min.Y+y).RGBA()
+			ch := func(a, b uint32) float64 { return (float64(a) - float64(b)) / 0xffff }
+			dr, dg, db := ch(r1, r2), ch(g1, g2), ch(b1, b2)
+			e2 := 0.2126*dr*dr + 0.7152*dg*dg + 0.0722*db*db
+			sum += e2
+			e := math.Sqrt(e2)
+			d.Max = math.Max(d.Max, e)
+			if e >= tol.Pixel {
+				d.Changed++
+			}
+		}
+	}
+	if d.Pixels > 0 {
+		d.RMSE = math.Sqrt(sum / float64(d.Pixels))
+	}
+	d.Pass = d.RMSE <= tol.RMSE && float64(d.Changed) <= tol.Changed*float64(d.Pixels)
+	return d, nil
+}
+
+// goldenImage рендерит эталонную сцену номер k и возвращает изображение
+// в том виде, в каком оно сохраняется в PNG.
+func goldenImage(ctx context.Context, k int) (image.Image, error) {
+	c := goldenCases[k]
+	opts := c.opts
+	opts.Width, opts.Height = goldenWidth, goldenHeight
+	res, err := Render(ctx, c.scene(), opts)
+	if err != nil {
+		return nil, err
+	}
+	return postProcess(res.Image, "clamp", true), nil
+}
+
+// runGolden рендерит эталонные сцены и сравнивает их с изображениями
+// <name>.png из каталога dir, выводя по строке на сцену. С update вместо
+// сравнения эталоны перезаписываются. Возвращает false, если какая-либо
+// сцена не прошла проверку.
+func runGolden(ctx context.Context, w io.Writer, dir string, update bool) bool {
+	ok := true
+	for k, c := range goldenCases {
+		path := filepath.Join(dir, c.name+".png")
+		img, err := goldenImage(ctx, k)
+		if err == nil && update {
+			if err = os.MkdirAll(dir, 0o755); err == nil {
+				err = saveImage(FileSink{}, img, path, encodeParams{})
+			}
+			if err == nil {
+				fmt.Fprintf(w, "updated %s\n", path)
+				continue
+			}
+		}
+		var d ImageDiff
+		if err == nil {
+			var want image.Image
+			if want, err = loadImage(path); err == nil {
+				d, err = CompareImages(img, want, goldenTolerance)
+			}
+		}
+		switch {
+		case err != nil:
+			ok = false
+			fmt.Fprintf(w, "FAIL %-18s %v\n", c.name, err)
+		case !d.Pass:
+			ok = false
+			fmt.Fprintf(w, "FAIL %-18s rmse %.4f, max %.3f, %d/%d pixels changed\n", c.name, d.RMSE, d.Max, d.Changed, d.Pixels)
+		default:
+			fmt.Fprintf(w, "ok   %-18s rmse %.4f, max %.3f\n", c.name, d.RMSE, d.Max)
+		}
+	}
+	return ok
+}
+
+// loadImage читает изображение из файла.
+func loadImage(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return img, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// TestGolden рендерит эталонные сцены и сравнивает их с изображениями из
+// testdata/golden; эталоны обновляются флагом -golden-update.
+func TestGolden(t *testing.T) {
+	for k, c := range goldenCases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := goldenImage(context.Background(), k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := loadImage(filepath.Join("testdata", "golden", c.name+".png"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := CompareImages(got, want, goldenTolerance)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !d.Pass {
+				t.Errorf("rmse %.4f, max %.3f, %d/%d pixels changed (tolerance %+v)", d.RMSE, d.Max, d.Changed, d.Pixels, goldenTolerance)
+			}
+		})
+	}
+}
+
+// TestCompareImages проверяет, что сравнение пропускает одинаковые
+// изображения и ловит заметное изменение.
+func TestCompareImages(t *testing.T) {
+	fb := NewFramebuffer(16, 16)
+	for i := range fb.Pixels {
+		fb.Pixels[i] = Vec3f{0.5, 0.5, 0.5}
+	}
+	want := postProcess(fb, "clamp", false)
+	if d, err := CompareImages(want, want, goldenTolerance); err != nil || !d.Pass || d.RMSE != 0 {
+		t.Errorf("same image: %+v, %v", d, err)
+	}
+	for i := range fb.Pixels[:32] {
+		fb.Pixels[i] = Vec3f{0.5, 0.6, 0.5}
+	}
+	if d, err := CompareImages(postProcess(fb, "clamp", false), want, goldenTolerance); err != nil || d.Pass || d.Changed != 32 {
+		t.Errorf("changed image: %+v, %v", d, err)
+	}
+	if _, err := CompareImages(postProcess(NewFramebuffer(8, 8), "clamp", false), want, goldenTolerance); err == nil {
+		t.Error("size mismatch not reported")
+	}
+}
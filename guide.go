@@ -0,0 +1,202 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+	"sync/atomic"
+)
+
+// Параметры кэша яркости для направленного сэмплирования (path guiding).
+const (
+	guideGridSize    = 16 // Ячеек по каждой оси
+	guideTheta       = 16 // Полос гистограммы направлений по Z (равной площади)
+	guidePhi         = 16 // Секторов гистограммы по азимуту
+	guideBins        = guideTheta * guidePhi
+	guideScale       = 1 << 16 // Единица фиксированной точки, в которой копится яркость
+	guideMaxRadiance = 1e3     // Яркость, выше которой запись обрезается (светлячки)
+	guideFraction    = 0.5     // Доля отскоков, направление которых берется из кэша
+)
+
+// radianceCache - кэш яркости для направленного сэмплирования
+// (RenderOptions.Guide). Пространство сцены делится на ячейки, и в каждой
+// копится гистограмма яркости, пришедшей в диффузные точки путей с разных
+// направлений. Пока кэш учится, tracePath записывает в него яркость;
+// после freeze диффузные отскоки выбирают направления пропорционально
+// гистограмме своей ячейки вперемешку с косинусным сэмплированием.
+//
+// Яркость копится атомарно в целых числах, поэтому содержимое кэша не
+// зависит от порядка, в котором горутины рендерят строки, и рендер
+// остается воспроизводимым.
+type radianceCache struct {
+	box    AABB // Ячейки делят этот параллелепипед; точки вне его относятся к крайним ячейкам
+	cells  []guideCell
+	frozen bool // Обучение закончено: кэш только читается
+}
+
+// guideCell - гистограмма яркости по направлениям в ячейке кэша.
+type guideCell struct {
+	energy [guideBins]atomic.Uint64 // Яркость в фиксированной точке (guideScale)
+	cdf    [guideBins]float64       // Функция распределения направлений после freeze; нули - ячейка пуста
+}
+
+// guideVertex - диффузная точка пути, в которую tracePath запишет
+// пришедшую яркость, когда путь закончится.
+type guideVertex struct {
+	cell       *guideCell
+	bin        int
+	radiance   Vec3f // Яркость пути до отскока из точки
+	throughput Vec3f // Вес пути после отскока
+}
+
+// newRadianceCache возвращает пустой кэш, ячейки которого покрывают
+// ограниченные объекты сцены и камеру.
+func newRadianceCache(s *Scene) *radianceCache {
+	box := AABB{Min: s.Camera.Position, Max: s.Camera.Position}
+	for _, b := range s.boxes {
+		if b.isFinite() {
+			box = box.union(b)
+		}
+	}
+	return &radianceCache{box: box, cells: make([]guideCell, guideGridSize*guideGridSize*guideGridSize)}
+}
+
+// withGuide возвращает копию сцены, трассировка путей в которой учится на
+// кэше guide и затем сэмплирует по нему.
+func (s *Scene) withGuide(guide *radianceCache) *Scene {
+	out := *s
+	out.guide = guide
+	return &out
+}
+
+// cell возвращает ячейку, в которую попадает точка p.
+func (c *radianceCache) cell(p Vec3f) *guideCell {
+	index := func(x, lo, hi float64) int {
+		if hi <= lo {
+			return 0
+		}
+		return max(0, min(guideGridSize-1, int((x-lo)/(hi-lo)*guideGridSize)))
+	}
+	i := index(p.X, c.box.Min.X, c.box.Max.X)
+	j := index(p.Y, c.box.Min.Y, c.box.Max.Y)
+	k := index(p.Z, c.box.Min.Z, c.box.Max.Z)
+	return &c.cells[(k*guideGridSize+j)*guideGridSize+i]
+}
+
+// guideBin возвращает номер участка сферы направлений, в который попадает d.
+func guideBin(d Vec3f) int {
+	zi := max(0, min(guideTheta-1, int((d.Z+1)/2*guideTheta)))
+	phi := math.Atan2(d.Y, d.X) + math.Pi
+	pi := max(0, min(guidePhi-1, int(phi/(2*math.Pi)*guidePhi)))
+	return zi*guidePhi + pi
+}
+
+// guideBinDir возвращает равномерно распределенное направление на участке bin.
+func guideBinDir(bin int, rng *rand.Rand) Vec3f {
+	zi, pi := bin/guidePhi, bin%guidePhi
+	z := -1 + 2*(float64(zi)+rng.Float64())/guideTheta
+	phi := 2*math.Pi*(float64(pi)+rng.Float64())/guidePhi - math.Pi
+	r := math.Sqrt(math.Max(0, 1-z*z))
+	return Vec3f{r * math.Cos(phi), r * math.Sin(phi), z}
+}
+
+// record добавляет яркость путей, закончившихся с итоговой яркостью
+// radiance, в ячейки их диффузных точек verts.
+func (c *radianceCache) record(verts []guideVertex, radiance Vec3f) {
+	for _, v := range verts {
+		// Яркость, пришедшая в точку по выбранному направлению: то, что путь
+		// набрал после нее, без веса предыдущих отскоков
+		in := radiance.Subtract(v.radiance)
+		div := func(x, t float64) float64 {
+			if t <= 0 {
+				return 0
+			}
+			return x / t
+		}
+		lin := Vec3f{div(in.X, v.throughput.X), div(in.Y, v.throughput.Y), div(in.Z, v.throughput.Z)}
+		if l := luminance(lin); l > 0 {
+			v.cell.energy[v.bin].Add(uint64(math.Min(l, guideMaxRadiance)*guideScale + 0.5))
+		}
+	}
+}
+
+// freeze заканчивает обучение кэша: по накопленной яркости строятся
+// распределения направлений ячеек.
+func (c *radianceCache) freeze() {
+	for i := range c.cells {
+		cell := &c.cells[i]
+		total := 0.0
+		for b := range cell.energy {
+			total += float64(cell.energy[b].Load())
+			cell.cdf[b] = total
+		}
+		if total == 0 {
+			continue
+		}
+		for b := range cell.cdf {
+			cell.cdf[b] /= total
+		}
+		cell.cdf[guideBins-1] = 1
+	}
+	c.frozen = true
+}
+
+// empty сообщает, что в ячейку не пришло яркости и сэмплировать по ней нельзя.
+func (cell *guideCell) empty() bool {
+	return cell.cdf[guideBins-1] == 0
+}
+
+// pdf возвращает плотность распределения ячейки в направлении d.
+func (cell *guideCell) pdf(d Vec3f) float64 {
+	b := guideBin(d)
+	p := cell.cdf[b]
+	if b > 0 {
+		p -= cell.cdf[b-1]
+	}
+	return p * guideBins / (4 * math.Pi)
+}
+
+// sample выбирает направление по распределению ячейки.
+func (cell *guideCell) sample(rng *rand.Rand) Vec3f {
+	u := rng.Float64()
+	b := sort.Search(guideBins-1, func(i int) bool { return cell.cdf[i] > u })
+	return guideBinDir(b, rng)
+}
+
+// bounce выбирает направление диффузного отскока из точки point с
+// нормалью N и возвращает его вместе с весом cos / (Pi * плотность), на
+// который умножается вес пути (у косинусного сэмплирования он равен 1).
+// Нулевой вес - направление ушло под поверхность. Без кэша, пока он учится,
+// и в пустых ячейках отскок косинусный.
+func (c *radianceCache) bounce(point, N Vec3f, rng *rand.Rand) (Vec3f, float64) {
+	if c == nil || !c.frozen {
+		return cosineSampleHemisphere(N, rng), 1
+	}
+	cell := c.cell(point)
+	if cell.empty() {
+		return cosineSampleHemisphere(N, rng), 1
+	}
+	var dir Vec3f
+	if rng.Float64() < guideFraction {
+		dir = cell.sample(rng)
+	} else {
+		dir = cosineSampleHemisphere(N, rng)
+	}
+	cos := N.Dot(dir)
+	if cos <= 0 {
+		return dir, 0
+	}
+	pdf := guideFraction*cell.pdf(dir) + (1-guideFraction)*cos/math.Pi
+	return dir, cos / math.Pi / pdf
+}
+
+// guideLearnSamples возвращает число первых сэмплов пикселя, на которых
+// учится кэш яркости, из samples.
+func guideLearnSamples(samples int) int {
+	return max(1, samples/4)
+}
+
+// recording сообщает, что кэш учится и пути нужно записывать.
+func (c *radianceCache) recording() bool {
+	return c != nil && !c.frozen
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// hdrWriters - форматы, в которые буфер сохраняется без потери диапазона.
+// Метаданные сохраняются только в .hdr: в заголовке PFM для них нет места.
+var hdrWriters = map[string]func(io.Writer, *Framebuffer, Metadata) error{
+	".pfm": func(w io.Writer, fb *Framebuffer, _ Metadata) error { return writePFM(w, fb) },
+	".hdr": writeRadianceHDR,
+}
+
+// isHDRPath сообщает, задает ли расширение файла HDR-формат.
+func isHDRPath(path string) bool {
+	_, ok := hdrWriters[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// saveHDR сохраняет буфер в HDR-формате, выбранном по расширению файла.
+func saveHDR(sink Sink, fb *Framebuffer, path string, meta Metadata) error {
+	write := hdrWriters[strings.ToLower(filepath.Ext(path))]
+	return writeFile(sink, path, func(w io.Writer) error {
+		return write(w, fb, meta)
+	})
+}
+
+// writePFM записывает буфер в формате Portable Float Map (RGB, float32,
+// little-endian, строки снизу вверх).
+func writePFM(w io.Writer, fb *Framebuffer) error {
+	if _, err := fmt.Fprintf(w, "PF\n%d %d\n-1.0\n", fb.Width, fb.Height); err != nil {
+		return err
+	}
+	row := make([]float32, 3*fb.Width)
+	for j := fb.Height - 1; j >= 0; j-- {
+		for i := 0; i < fb.Width; i++ {
+			c := fb.At(i, j)
+			row[3*i], row[3*i+1], row[3*i+2] = float32(c.X), float32(c.Y), float32(c.Z)
+		}
+		if err := binary.Write(w, binary.LittleEndian, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRadianceHDR записывает буфер в формате Radiance RGBE (.hdr)
+// несжатыми строками. Метаданные записываются комментариями заголовка.
+func writeRadianceHDR(w io.Writer, fb *Framebuffer, meta Metadata) error {
+	if _, err := fmt.Fprintf(w, "#?RADIANCE\n%sFORMAT=32-bit_rle_rgbe\n\n-Y %d +X %d\n", meta.lines("# ", ": "), fb.Height, fb.Width); err != nil {
+		return err
+	}
+	row := make([]byte, 4*fb.Width)
+	for j := 0; j < fb.Height; j++ {
+		for i := 0; i < fb.Width; i++ {
+			copy(row[4*i:], vec3fToRGBE(fb.At(i, j)))
+		}
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vec3fToRGBE кодирует цвет в пиксель RGBE (общая экспонента для трех каналов).
+func vec3fToRGBE(c Vec3f) []byte {
+	v := math.Max(c.X, math.Max(c.Y, c.Z))
+	if v < 1e-32 {
+		return []byte{0, 0, 0, 0}
+	}
+	m, e := math.Frexp(v)
+	scale := m * 256 / v
+	return []byte{
+		byte(math.Max(0, c.X*scale)),
+		byte(math.Max(0, c.Y*scale)),
+		byte(math.Max(0, c.Z*scale)),
+		byte(e + 128),
+	}
+}
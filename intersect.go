@@ -0,0 +1,122 @@
+package main
+
+import "math"
+
+// Ray - луч Origin + t*Dir (Dir нормирован), видящий пересечения только
+// при TMin <= t <= TMax.
+type Ray struct {
+	Origin, Dir Vec3f
+	TMin, TMax  float64
+}
+
+// newRay возвращает неограниченный луч.
+func newRay(origin, dir Vec3f) Ray {
+	return Ray{Origin: origin, Dir: dir, TMax: math.Inf(1)}
+}
+
+// spawnRay возвращает вторичный луч, выходящий по направлению dir из точки
+// поверхности point с нормалью N и видящий пересечения не дальше tMax.
+// Начало луча сдвигается с поверхности в ту сторону, куда он уходит, на
+// отступ сцены (см. EpsilonPolicy), чтобы луч не пересек ту же
+// поверхность (shadow acne).
+func (s *Scene) spawnRay(point, N, dir Vec3f, tMax float64) Ray {
+	offset := N.MulScalar(s.epsilon.at(point))
+	if dir.Dot(N) < 0 {
+		offset = offset.Negate()
+	}
+	return Ray{Origin: point.Add(offset), Dir: dir, TMax: tMax}
+}
+
+// At возвращает точку луча на расстоянии t от начала.
+func (r Ray) At(t float64) Vec3f {
+	return r.Origin.Add(r.Dir.MulScalar(t))
+}
+
+// start возвращает точку, с которой начинается поиск пересечений, и ее
+// расстояние от начала луча. Примитивы ищут пересечения при t >= 0, поэтому
+// начало луча переносится в TMin.
+func (r Ray) start() (Vec3f, float64) {
+	if r.TMin > 0 {
+		return r.At(r.TMin), r.TMin
+	}
+	return r.Origin, 0
+}
+
+// Hit - ближайшее пересечение луча со сценой. Object == nil - луч ни во
+// что не попал.
+type Hit struct {
+	Object Hittable
+	Dist   float64
+}
+
+// HitRecord - пересечение луча с поверхностью, подготовленное для затенения.
+type HitRecord struct {
+	Point     Vec3f
+	Normal    Vec3f // Геометрическая нормаль, обращенная навстречу лучу
+	T         float64
+	Material  *Material
+	FrontFace bool     // Луч пришел с внешней стороны поверхности
+	Object    Hittable // Объект, которому принадлежит точка
+}
+
+// hit находит ближайшее пересечение луча со сценой.
+func (s *Scene) hit(r Ray) (HitRecord, bool) {
+	obj, t := s.intersect(r)
+	if obj == nil {
+		return HitRecord{}, false
+	}
+	rec := HitRecord{Point: r.At(t), T: t, Material: obj.material(), Object: obj}
+	rec.Normal = obj.normalAt(rec.Point)
+	// Луч может попасть на внутреннюю сторону объекта через вырез
+	rec.FrontFace = rec.Normal.Dot(r.Dir) <= 0
+	if !rec.FrontFace {
+		rec.Normal = rec.Normal.Negate()
+	}
+	return rec, true
+}
+
+// IntersectMany находит ближайшие пересечения для пачки лучей. Для лучей,
+// ни во что не попавших, Dist равно TMax.
+func (s *Scene) IntersectMany(rays []Ray) []Hit {
+	return s.intersectMany(rays, make([]Hit, len(rays)))
+}
+
+// intersectMany записывает пересечения лучей rays в hits (len(hits) >=
+// len(rays)) и возвращает hits[:len(rays)]. Внешний цикл идет по объектам:
+// каждый объект проверяется сразу со всей пачкой, пока его данные в кэше,
+// а обратные направления лучей считаются один раз на пачку в буфере на стеке.
+func (s *Scene) intersectMany(rays []Ray, hits []Hit) []Hit {
+	const chunk = 64
+	hits = hits[:len(rays)]
+	for i, r := range rays {
+		hits[i] = Hit{Dist: r.TMax}
+	}
+	var origins, inv [chunk]Vec3f
+	var offsets [chunk]float64
+	var tests [chunk]int
+	for start := 0; start < len(rays); start += chunk {
+		batch := rays[start:min(start+chunk, len(rays))]
+		out := hits[start : start+len(batch)]
+		for k, r := range batch {
+			origins[k], offsets[k] = r.start()
+			inv[k] = Vec3f{1 / r.Dir.X, 1 / r.Dir.Y, 1 / r.Dir.Z}
+			tests[k] = 0
+		}
+		for i, obj := range s.objects {
+			box := s.boxes[i]
+			for k, r := range batch {
+				if !box.hitRay(origins[k], inv[k], out[k].Dist-offsets[k]) {
+					continue
+				}
+				tests[k]++
+				if hit, dist := obj.RayIntersect(origins[k], r.Dir); hit && dist+offsets[k] < out[k].Dist {
+					out[k] = Hit{Object: obj, Dist: dist + offsets[k]}
+				}
+			}
+		}
+		for k := range batch {
+			s.countRay(false, tests[k])
+		}
+	}
+	return hits
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// TileFailure - участок кадра (строка или тайл), рендер которого
+// завершился паникой, например из-за вырожденной геометрии или NaN.
+type TileFailure struct {
+	Area    string // Участок кадра: "row 12", "tile 64,32"
+	Panic   string // Значение паники
+	Retried bool   // Повтор перебором объектов удался, и участок дорисован
+}
+
+// isolate рендерит участок кадра area функцией work. Паника не роняет
+// рендер: участок один раз рендерится заново с сэмплером, перебирающим все
+// объекты без ускоряющих структур (см. Scene.withBruteForce), а сбой
+// записывается в статистику. Если паникует и повтор, участок остается
+// недорисованным. work должна перезаписывать результат участка целиком, а
+// не дополнять его.
+func (p *pixelRenderer) isolate(area string, s *pixelSampler, work func(s *pixelSampler)) {
+	v := tryRender(s, work)
+	if v == nil {
+		return
+	}
+	brute := *p
+	brute.scene = p.scene.withBruteForce()
+	f := TileFailure{Area: area, Panic: fmt.Sprint(v)}
+	f.Retried = tryRender(brute.newSampler(), work) == nil
+	if stats := p.scene.stats; stats != nil {
+		stats.mu.Lock()
+		stats.failures = append(stats.failures, f)
+		stats.mu.Unlock()
+	}
+}
+
+// tryRender вызывает work и возвращает значение паники или nil.
+func tryRender(s *pixelSampler, work func(s *pixelSampler)) (v any) {
+	defer func() {
+		v = recover()
+	}()
+	work(s)
+	return nil
+}
+
+// maxPrintedFailures - сколько сорвавшихся участков перечисляет printFailures.
+const maxPrintedFailures = 10
+
+// printFailures предупреждает об участках кадра, рендер которых сорвался.
+func printFailures(w io.Writer, res *RenderResult) {
+	failures := res.Stats.Failures
+	for _, f := range failures[:min(len(failures), maxPrintedFailures)] {
+		status := "retried without acceleration"
+		if !f.Retried {
+			status = "left unfinished"
+		}
+		fmt.Fprintf(w, "warning: %s panicked (%s), %s\n", f.Area, f.Panic, status)
+	}
+	if n := len(failures) - maxPrintedFailures; n > 0 {
+		fmt.Fprintf(w, "warning: %d more areas panicked\n", n)
+	}
+}
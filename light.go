@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strings"
+)
+
+// Light - источник света.
+type Light interface {
+	// illuminate возвращает диффузный и бликовый вклад источника в точку q
+	// с учетом цвета света.
+	illuminate(s *Scene, q *lightQuery, rng *rand.Rand) (diffuse, specular Vec3f)
+	// power возвращает интенсивность источника, по сумме которых
+	// нормируется освещение (см. Scene.illuminate).
+	power() float64
+}
+
+// lightQuery - точка поверхности, для которой вычисляется прямое освещение.
+type lightQuery struct {
+	point, N, dir    Vec3f // Точка, нормаль к поверхности и направление луча
+	specularExponent float64
+	alpha            float64 // Ширина распределения микрограней GGX; 0 - блик по Фонгу
+	single           bool    // Протяженные источники сэмплируются одним теневым лучом
+	scale            float64 // Множитель интенсивностей источников
+	unshadowed       bool    // Не учитывать тени
+	volume           bool    // Точка внутри рассеивающей среды: свет приходит со всех сторон, N не задана
+}
+
+// visible сообщает, доходит ли до точки q свет от источника на расстоянии
+// dist по направлению lightDir (dist = +Inf - удаленный источник).
+func (q *lightQuery) visible(s *Scene, lightDir Vec3f, dist float64) bool {
+	return q.unshadowed || !s.occluded(s.spawnRay(q.point, q.N, lightDir, dist))
+}
+
+// brdf возвращает диффузный и бликовый вклад света с интенсивностью
+// intensity, приходящего в точку q по направлению на источник lightDir.
+func (q *lightQuery) brdf(lightDir Vec3f, intensity float64) (diffuse, specular float64) {
+	if q.volume {
+		return intensity, 0
+	}
+	diffuse = intensity * math.Max(0, lightDir.Dot(q.N))
+	if q.alpha > 0 {
+		return diffuse, intensity * ggxSpecular(q.N, q.dir.Negate(), lightDir, q.alpha)
+	}
+	reflection := reflect(lightDir.Negate(), q.N).Normalize()
+	specular = math.Pow(math.Max(0, reflection.Dot(q.dir.Negate())), q.specularExponent) * intensity
+	return diffuse, specular
+}
+
+// lightFalloffs - законы ослабления света точечного источника с расстоянием
+// (PointLight.Falloff): множитель интенсивности на расстоянии dist.
+var lightFalloffs = map[string]func(dist float64) float64{
+	"none":           func(float64) float64 { return 1 },
+	"inverse-square": func(dist float64) float64 { return 1 / (dist * dist) },
+	"inverse-linear": func(dist float64) float64 { return 1 / dist },
+}
+
+// falloffNames возвращает имена законов ослабления в алфавитном порядке.
+func falloffNames() []string {
+	names := make([]string, 0, len(lightFalloffs))
+	for name := range lightFalloffs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkFalloff проверяет закон ослабления источника.
+func (l *PointLight) checkFalloff() error {
+	if _, ok := lightFalloffs[l.Falloff]; l.Falloff != "" && !ok {
+		return fmt.Errorf("unknown falloff %q (want one of %s)", l.Falloff, strings.Join(falloffNames(), ", "))
+	}
+	return nil
+}
+
+// lightColor возвращает цвет света color, по умолчанию белый.
+func lightColor(color *Vec3f) Vec3f {
+	if color == nil {
+		return Vec3f{1, 1, 1}
+	}
+	return *color
+}
+
+// gray возвращает белый цвет яркости v.
+func gray(v float64) Vec3f {
+	return Vec3f{v, v, v}
+}
+
+func (l *PointLight) illuminate(s *Scene, q *lightQuery, rng *rand.Rand) (diffuse, specular Vec3f) {
+	// Протяженный источник освещает точку несколькими теневыми лучами,
+	// каждый из которых несет свою долю интенсивности
+	samples := l.sampleCount()
+	if q.single {
+		samples = 1
+	}
+	intensity := l.Intensity * q.scale / float64(samples)
+	falloff := lightFalloffs[l.Falloff]
+	if falloff == nil {
+		falloff = lightFalloffs["none"]
+	}
+	var d, sp float64
+	for k := 0; k < samples; k++ {
+		toLight := l.samplePoint(rng).Subtract(q.point)
+		dist := toLight.Length()
+		lightDir := toLight.MulScalar(1 / dist)
+		if q.visible(s, lightDir, dist) {
+			// Ослабление считается от точки источника, до которой идет теневой луч
+			ds, ss := q.brdf(lightDir, intensity*falloff(dist))
+			d += ds
+			sp += ss
+		}
+	}
+	color := lightColor(l.Color)
+	return color.MulScalar(d), color.MulScalar(sp)
+}
+
+func (l *PointLight) power() float64 { return l.Intensity }
+
+// DirectionalLight - удаленный источник (солнце): свет приходит во все точки
+// сцены параллельно, теневые лучи тоже параллельны.
+type DirectionalLight struct {
+	Direction Vec3f   `json:"direction"` // Направление, в котором распространяется свет
+	Intensity float64 `json:"intensity"`
+	Color     *Vec3f  `json:"color,omitempty"` // Цвет света, по умолчанию белый
+}
+
+func (l *DirectionalLight) illuminate(s *Scene, q *lightQuery, _ *rand.Rand) (diffuse, specular Vec3f) {
+	lightDir := l.Direction.Negate().Normalize()
+	if !q.visible(s, lightDir, math.Inf(1)) {
+		return Vec3f{}, Vec3f{}
+	}
+	d, sp := q.brdf(lightDir, l.Intensity*q.scale)
+	color := lightColor(l.Color)
+	return color.MulScalar(d), color.MulScalar(sp)
+}
+
+func (l *DirectionalLight) power() float64 { return l.Intensity }
+
+// AmbientLight - рассеянный свет, одинаково освещающий все точки сцены без
+// теней и бликов.
+type AmbientLight struct {
+	Intensity float64 `json:"intensity"`
+}
+
+func (l *AmbientLight) illuminate(_ *Scene, q *lightQuery, _ *rand.Rand) (diffuse, specular Vec3f) {
+	return gray(l.Intensity * q.scale), Vec3f{}
+}
+
+func (l *AmbientLight) power() float64 { return l.Intensity }
+
+// isArea сообщает, является ли источник протяженным.
+func (l *PointLight) isArea() bool {
+	return l.Shape == "sphere" || l.Shape == "rect"
+}
+
+// sampleCount возвращает число теневых лучей, которыми сэмплируется источник.
+func (l *PointLight) sampleCount() int {
+	if !l.isArea() || l.Samples < 1 {
+		return 1
+	}
+	return l.Samples
+}
+
+// samplePoint возвращает случайную точку на поверхности источника.
+// Для точечного источника это всегда его позиция.
+func (l *PointLight) samplePoint(rng *rand.Rand) Vec3f {
+	switch l.Shape {
+	case "sphere":
+		// Равномерная точка на сфере
+		z := 1 - 2*rng.Float64()
+		r := math.Sqrt(math.Max(0, 1-z*z))
+		phi := 2 * math.Pi * rng.Float64()
+		return l.Position.Add(Vec3f{r * math.Cos(phi), r * math.Sin(phi), z}.MulScalar(l.Radius))
+	case "rect":
+		return l.Position.Add(l.U.MulScalar(rng.Float64() - 0.5)).Add(l.V.MulScalar(rng.Float64() - 0.5))
+	}
+	return l.Position
+}
+
+// domeSamples - число теневых лучей купола по умолчанию.
+const domeSamples = 16
+
+// DomeLight - равномерно светящийся купол неба. Освещенность точки
+// пропорциональна видимой из нее части неба, поэтому купол дает мягкое
+// затенение под объектами и в углах.
+type DomeLight struct {
+	Intensity float64 `json:"intensity"`
+	Samples   int     `json:"samples,omitempty"` // Число теневых лучей, по умолчанию domeSamples
+}
+
+func (l *DomeLight) illuminate(s *Scene, q *lightQuery, rng *rand.Rand) (diffuse, specular Vec3f) {
+	samples := l.Samples
+	if samples < 1 {
+		samples = domeSamples
+	}
+	if q.volume {
+		return gray(l.Intensity * q.scale), Vec3f{} // Небо видно со всех сторон
+	}
+	if q.single {
+		samples = 1
+	}
+	// Направления выбираются с плотностью, пропорциональной косинусу,
+	// поэтому каждый незакрытый луч несет одинаковую долю освещенности
+	intensity := l.Intensity * q.scale / float64(samples)
+	var d float64
+	for k := 0; k < samples; k++ {
+		if q.visible(s, cosineSampleHemisphere(q.N, rng), math.Inf(1)) {
+			d += intensity
+		}
+	}
+	return gray(d), Vec3f{}
+}
+
+func (l *DomeLight) power() float64 { return l.Intensity }
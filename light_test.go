@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"testing"
+)
+
+// maxDirectLight возвращает наибольшую составляющую диффузного и бликового
+// прямого освещения в точках сцены, видимых первичными лучами кадра.
+func maxDirectLight(scene *Scene) (diffuse, specular float64) {
+	rng := rand.New(rand.NewPCG(0, 0))
+	for _, r := range frameRays(scene) {
+		hit, ok := scene.hit(r)
+		if !ok {
+			continue
+		}
+		d, sp := scene.illuminate(hit.Point, hit.Normal, r.Dir, hit.Material, false, rng)
+		diffuse, specular = max(diffuse, d.X, d.Y, d.Z), max(specular, sp.X, sp.Y, sp.Z)
+	}
+	return diffuse, specular
+}
+
+// TestNormalizeLights проверяет, что с нормировкой источников прямое
+// освещение сцены по умолчанию, которое без нее выходит за единицу,
+// остается ниже единицы, а экспозиция по-прежнему меняет яркость кадра
+// поверх нормировки.
+func TestNormalizeLights(t *testing.T) {
+	if d, sp := maxDirectLight(defaultScene()); max(d, sp) <= 1 {
+		t.Errorf("without normalization max diffuse %g, specular %g, want > 1", d, sp)
+	}
+	scene := defaultScene()
+	scene.NormalizeLights = true
+	if d, sp := maxDirectLight(scene); d >= 1 || sp >= 1 {
+		t.Errorf("normalized max diffuse %g, specular %g, want < 1", d, sp)
+	}
+
+	render := func(exposure float64) *Framebuffer {
+		res, err := Render(context.Background(), scene, RenderOptions{Width: 64, Height: 48, Depth: 4, Integrator: "whitted", Exposure: exposure})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Image
+	}
+	dark, bright := render(0), render(1)
+	for i, p := range dark.Pixels {
+		if want := p.MulScalar(2); bright.Pixels[i].Subtract(want).Length() > 1e-9 {
+			t.Fatalf("pixel %d with exposure +1 is %v, want %v", i, bright.Pixels[i], want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import "strings"
+
+// LightPass - отладочный проход одного источника света: кадр, в котором
+// горит только он (см. RenderOptions.LightPasses).
+type LightPass struct {
+	Name  string // Источник, как в предпросмотре: "point 1", "sun 2"...
+	Image *Framebuffer
+}
+
+// withSoloLight возвращает копию сцены, в которой прямое освещение дает
+// только источник номер k из s.lights. С NormalizeLights освещение
+// нормируется по всем источникам, как в полной сцене (см. lightNorm),
+// поэтому проход источника показывает ровно его долю освещения кадра. Фон, отражения и самосвечение остаются
+// в каждом проходе.
+func (s *Scene) withSoloLight(k int) *Scene {
+	out := *s
+	out.solo = s.lights[k]
+	return &out
+}
+
+// newLightPasses возвращает по проходу на каждый источник сцены и
+// рендеры, вычисляющие их пиксели.
+func newLightPasses(scene *Scene, opts RenderOptions, width, height int) ([]LightPass, []*pixelRenderer) {
+	knobs := scene.lightKnobs()
+	passes := make([]LightPass, len(scene.lights))
+	renderers := make([]*pixelRenderer, len(scene.lights))
+	for k := range scene.lights {
+		passes[k] = LightPass{Name: knobs[k].Name, Image: NewFramebuffer(width, height)}
+		renderers[k] = newPixelRenderer(scene.withSoloLight(k), opts)
+	}
+	return passes, renderers
+}
+
+// lightPassPath возвращает имя файла прохода источника:
+// result.png -> result_light_point1.png.
+func lightPassPath(path, name string) string {
+	return aovPath(path, "light_"+strings.ReplaceAll(name, " ", ""))
+}
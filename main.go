@@ -1,33 +1,99 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"image"
 	"image/color"
-	"image/png"
+	"image/jpeg"
 	"math"
+	"math/rand/v2"
 	"os"
+	"os/signal"
+	"slices"
+	"strings"
 )
 
 type Vec3f struct {
-	X, Y, Z float64
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// Material описывает свойства поверхности.
+type Material struct {
+	Color            Vec3f   `json:"color"`
+	Albedo           float64 `json:"albedo"`                // Доля диффузного отражения
+	SpecularExponent float64 `json:"specularExponent"`      // Показатель степени блеска
+	Texture          string  `json:"texture,omitempty"`     // Путь к текстуре, заменяющей Color
+	AlphaCutoff      float64 `json:"alphaCutoff,omitempty"` // Тексели с альфой ниже порога прозрачны
+	// Автоматическая развертка вместо собственной развертки примитива
+	// (см. uvProjections); пустая строка - собственная
+	UVMapping string `json:"uvMapping,omitempty"`
+	// Ловец теней: сама поверхность не видна, но затемняет фон за собой
+	// там, где на нее падают тени
+	ShadowCatcher bool `json:"shadowCatcher,omitempty"`
+	// Дальность отражений: дальше отражается только фон. 0 - берется
+	// значение сцены
+	MaxReflectDistance float64 `json:"maxReflectDistance,omitempty"`
+	// Карта нормалей в касательном пространстве (RGB = XYZ, зеленый -
+	// вверх по изображению) и сила ее влияния (0 - по умолчанию, 1)
+	NormalMap      string  `json:"normalMap,omitempty"`
+	NormalStrength float64 `json:"normalStrength,omitempty"`
+	// Отражать только фон и карту окружения, не трассируя отраженный луч
+	// по сцене: дешевое приближение для черновых рендеров зеркальных сцен
+	ReflectEnvOnly bool `json:"reflectEnvOnly,omitempty"`
+	// Модель отражения: "phong" (по умолчанию) или "ggx" - микрограни
+	// Кука-Торранса с шероховатостью и металличностью, как в материалах
+	// DCC-программ (Color - базовый цвет; Albedo и SpecularExponent не
+	// используются)
+	BRDF      string  `json:"brdf,omitempty"`
+	Roughness float64 `json:"roughness,omitempty"`
+	Metalness float64 `json:"metalness,omitempty"`
+
+	texture   *Texture
+	normalMap *Texture
 }
 
 type Sphere struct {
-	Center           Vec3f
-	Radius           float64
-	Color            Vec3f
-	Albedo           float64 // Доля диффузного отражения
-	SpecularExponent float64 // Показатель степени блеска
+	Center Vec3f   `json:"center"`
+	Radius float64 `json:"radius"`
+	Material
+	Animation *ObjectAnimation `json:"animation,omitempty"`
+	Transform *Transform       `json:"transform,omitempty"`
+	Motion    *Vec3f           `json:"motion,omitempty"` // Смещение за время выдержки (см. Moving)
+}
+
+// checkShape проверяет радиус сферы.
+func (s *Sphere) checkShape() error {
+	if s.Radius <= 0 {
+		return errors.New("radius must be positive")
+	}
+	return nil
 }
 
-type Light struct {
-	Position  Vec3f
-	Intensity float64
+// PointLight - точечный источник или протяженный источник в форме сферы
+// или прямоугольника.
+type PointLight struct {
+	Position  Vec3f   `json:"position"`
+	Intensity float64 `json:"intensity"`
+	Shape     string  `json:"shape,omitempty"`   // "point" (по умолчанию), "sphere" или "rect"
+	Radius    float64 `json:"radius,omitempty"`  // Радиус сферического источника
+	U         Vec3f   `json:"u,omitzero"`        // Стороны прямоугольного источника,
+	V         Vec3f   `json:"v,omitzero"`        // Position - его центр
+	Samples   int     `json:"samples,omitempty"` // Число теневых лучей для протяженного источника
+	Color     *Vec3f  `json:"color,omitempty"`   // Цвет света, по умолчанию белый
+	// Ослабление с расстоянием (см. lightFalloffs), по умолчанию "none". С
+	// ослаблением Intensity - интенсивность на расстоянии 1 от источника
+	Falloff string `json:"falloff,omitempty"`
+
+	Animation *LightAnimation `json:"animation,omitempty"`
 }
 
-func NewLight(position Vec3f, intensity float64) *Light {
-	return &Light{Position: position, Intensity: intensity}
+func NewPointLight(position Vec3f, intensity float64) *PointLight {
+	return &PointLight{Position: position, Intensity: intensity}
 }
 
 // Операция сложения векторов
@@ -76,7 +142,18 @@ func (v Vec3f) Negate() Vec3f {
 	return Vec3f{-v.X, -v.Y, -v.Z}
 }
 
-// Пересечение луча со сферой
+// Mul возвращает покомпонентное произведение векторов.
+func (v Vec3f) Mul(other Vec3f) Vec3f {
+	return Vec3f{v.X * other.X, v.Y * other.Y, v.Z * other.Z}
+}
+
+// Cross возвращает векторное произведение.
+func (v Vec3f) Cross(other Vec3f) Vec3f {
+	return Vec3f{v.Y*other.Z - v.Z*other.Y, v.Z*other.X - v.X*other.Z, v.X*other.Y - v.Y*other.X}
+}
+
+// Пересечение луча со сферой. Точки, попавшие в вырезанные альфа-маской
+// тексели, пропускаются - луч идет дальше, к задней стенке сферы.
 func (s *Sphere) RayIntersect(orig, dir Vec3f) (bool, float64) {
 	L := s.Center.Subtract(orig)
 	tca := L.Dot(dir)
@@ -85,80 +162,100 @@ func (s *Sphere) RayIntersect(orig, dir Vec3f) (bool, float64) {
 		return false, 0
 	}
 	thc := math.Sqrt(s.Radius*s.Radius - d2)
-	t0 := tca - thc
-	t1 := tca + thc
-	if t0 < 0 {
-		t0 = t1
-	}
-	if t0 < 0 {
-		return false, 0
+	for _, t := range [2]float64{tca - thc, tca + thc} {
+		if t < 0 || isCutout(s, orig.Add(dir.MulScalar(t))) {
+			continue
+		}
+		return true, t
 	}
-	return true, t0
+	return false, 0
+}
+
+// uv возвращает текстурные координаты точки на поверхности сферы.
+func (s *Sphere) uv(point Vec3f) (float64, float64) {
+	n := point.Subtract(s.Center).Normalize()
+	u := 0.5 + math.Atan2(n.Z, n.X)/(2*math.Pi)
+	v := math.Acos(math.Max(-1, math.Min(1, n.Y))) / math.Pi
+	return u, v
 }
 
-// castRay определяет цвет луча.
-func castRay(orig, dir Vec3f, spheres []Sphere, lights []Light, depth int) Vec3f {
+func (s *Sphere) normalAt(point Vec3f) Vec3f {
+	return point.Subtract(s.Center).Normalize()
+}
+
+func (s *Sphere) bounds() AABB {
+	e := Vec3f{s.Radius, s.Radius, s.Radius}
+	return AABB{Min: s.Center.Subtract(e), Max: s.Center.Add(e)}
+}
+
+func (s *Sphere) material() *Material { return &s.Material }
+
+// castRay определяет цвет первичного луча.
+func castRay(ray Ray, scene *Scene, depth int, rng *rand.Rand) Vec3f {
+	return castRayLimited(ray, scene, depth, true, rng)
+}
+
+// castRayLimited определяет цвет луча, который видит объекты не дальше
+// ray.TMax. У границы дальности цвет объекта плавно переходит в цвет фона.
+// primary - луч выпущен камерой, а не отражен.
+func castRayLimited(ray Ray, scene *Scene, depth int, primary bool, rng *rand.Rand) Vec3f {
+	scene.reachDepth(depth)
 	if depth <= 0 {
 		return Vec3f{0, 0, 0} // Достигнута максимальная глубина рекурсии, возвращаем черный цвет
 	}
 
-	closestDist := math.MaxFloat64
-	var hitSphere *Sphere
-	for i := range spheres {
-		hit, dist := spheres[i].RayIntersect(orig, dir)
-		if hit && dist < closestDist {
-			closestDist = dist
-			hitSphere = &spheres[i]
-		}
+	hit, ok := scene.hit(ray)
+	if !ok {
+		return scene.throughMedium(ray, math.Inf(1), scene.background(ray.Dir, primary), rng) // Цвет фона или карта окружения
 	}
-
-	if hitSphere == nil {
-		return Vec3f{0.2, 0.7, 0.8} // Цвет фона
+	result := scene.throughMedium(ray, hit.T, shade(ray, hit, scene, depth, primary, rng), rng)
+	if fade := reflectionFade(hit.T, ray.TMax); fade > 0 {
+		result = result.MulScalar(1 - fade).Add(scene.background(ray.Dir, primary).MulScalar(fade))
 	}
+	return result
+}
 
-	// Точка пересечения луча со сферой
-	point := orig.Add(dir.MulScalar(closestDist))
-	// Нормаль в точке пересечения
-	N := point.Subtract(hitSphere.Center).Normalize()
-	// Диффузная интенсивность света и блики
-	diffuseLightIntensity := 0.0
-	specularLightIntensity := 0.0
-
-	for _, light := range lights {
-		lightDir := light.Position.Subtract(point).Normalize()
-		shadowOrig := point
-		if lightDir.Dot(N) < 0 {
-			shadowOrig = shadowOrig.Subtract(N.MulScalar(1e-3))
-		} else {
-			shadowOrig = shadowOrig.Add(N.MulScalar(1e-3))
-		}
-		inShadow := false
-		for _, sphere := range spheres {
-			hit, _ := sphere.RayIntersect(shadowOrig, lightDir)
-			if hit {
-				inShadow = true
-				break
-			}
-		}
-		if !inShadow {
-			diffuseLightIntensity += light.Intensity * math.Max(0, lightDir.Dot(N))
-			reflection := reflect(lightDir.Negate(), N).Normalize()
-			specularLightIntensity += math.Pow(math.Max(0, reflection.Dot(dir.Negate())), hitSphere.SpecularExponent) * light.Intensity
-		}
+// shade определяет цвет точки hit, в которую попал луч ray.
+func shade(ray Ray, hit HitRecord, scene *Scene, depth int, primary bool, rng *rand.Rand) Vec3f {
+	m := hit.Material
+	if m.ShadowCatcher {
+		return scene.background(ray.Dir, primary).MulScalar(scene.shadowRatio(hit.Point, hit.Normal, rng))
 	}
+	N := scene.shadingNormal(hit.Object, hit.Point, hit.Normal)
+	// Диффузная интенсивность света и блики
+	diffuseLightIntensity, specularLightIntensity := scene.illuminate(hit.Point, N, ray.Dir, m, false, rng)
 
-	// Отраженное направление
-	reflectDir := reflect(dir, N).Normalize()
-	reflectOrig := point
-	if reflectDir.Dot(N) < 0 {
-		reflectOrig = reflectOrig.Subtract(N.MulScalar(1e-3))
+	// Отраженный луч
+	reflectDir := reflect(ray.Dir, N).Normalize()
+	var reflectColor Vec3f
+	if m.ReflectEnvOnly {
+		reflectColor = scene.background(reflectDir, false)
 	} else {
-		reflectOrig = reflectOrig.Add(N.MulScalar(1e-3))
+		reflectColor = castRayLimited(scene.spawnRay(hit.Point, N, reflectDir, scene.reflectLimit(m)), scene, depth-1, false, rng)
+	}
+	if m.ggx() {
+		// Отражения не размываются: шероховатая поверхность отражает
+		// зеркально, но тем слабее, чем она шероховатее
+		f := m.microfacet(surfaceColor(hit.Object, hit.Point), N, ray.Dir)
+		return f.direct(diffuseLightIntensity, specularLightIntensity).Add(reflectColor.Mul(f.fresnel).MulScalar(1 - m.Roughness))
 	}
-	reflectColor := castRay(reflectOrig, reflectDir, spheres, lights, depth-1)
 
 	// Возвращаем цвет с учетом отраженного цвета и добавляем блики
-	return hitSphere.Color.MulScalar(diffuseLightIntensity * hitSphere.Albedo).Add(Vec3f{1.0, 1.0, 1.0}.MulScalar(specularLightIntensity)).Add(reflectColor.MulScalar(1 - hitSphere.Albedo))
+	return surfaceColor(hit.Object, hit.Point).Mul(diffuseLightIntensity.MulScalar(m.Albedo)).Add(specularLightIntensity).Add(reflectColor.MulScalar(1 - m.Albedo))
+}
+
+// reflectionFade возвращает долю фона в цвете объекта на расстоянии dist
+// при дальности отражений maxDist: последние 20% дальности цвет объекта
+// линейно растворяется в фоне.
+func reflectionFade(dist, maxDist float64) float64 {
+	if math.IsInf(maxDist, 1) {
+		return 0
+	}
+	start := 0.8 * maxDist
+	if dist <= start {
+		return 0
+	}
+	return math.Min(1, (dist-start)/(maxDist-start))
 }
 
 // colorToRGBA преобразует Vec3f в color.RGBA.
@@ -171,54 +268,363 @@ func colorToRGBA(c Vec3f) color.RGBA {
 	}
 }
 
-// render - генерация изображения.
-func render(spheres []Sphere, lights []Light, depth int) {
-	const width, height = 1024, 768
-	const fov = math.Pi / 3 // Поле зрения
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
+func main() {
+	// Подкоманда diff сравнивает две версии сцены
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		os.Exit(runSceneDiff(os.Args[2:]))
+	}
+
+	scenePath := flag.String("scene", "", "JSON-файл сцены (по умолчанию - встроенная сцена)")
+	output := flag.String("out", "result.png", "файл результата: .png, .jpg, .ppm, .bmp, .pfm или .hdr; - или stdout:имя - в стандартный вывод, http(s)://... - загрузка запросом PUT")
+	jpegQuality := flag.Int("jpeg-quality", jpeg.DefaultQuality, "качество JPEG (1-100)")
+	integrator := flag.String("integrator", "whitted", "интегратор: "+strings.Join(integratorNames, ", "))
+	samples := flag.Int("spp", 1, "число сэмплов на пиксель (с -max-spp - наименьшее)")
+	maxSamples := flag.Int("max-spp", 0, "адаптивное сэмплирование: до стольких сэмплов на шумных пикселях (0 - ровно -spp)")
+	noise := flag.Float64("noise", 0.02, "допустимая относительная погрешность пикселя при адаптивном сэмплировании")
+	seed := flag.Uint64("seed", 0, "зерно генератора случайных чисел для воспроизводимого рендера")
+	depth := flag.Int("depth", 200, "глубина рекурсии")
+	maxReflect := flag.Float64("max-reflect-dist", 0, "дальность отражений (0 - из сцены или без ограничения)")
+	normalizeLights := flag.Bool("normalize-lights", false, "нормировать интенсивности источников на их сумму (яркость - через -exposure)")
+	toneMap := flag.String("tonemap", "clamp", "тональная компрессия: "+strings.Join(toneMapperNames(), ", "))
+	exposure := flag.Float64("exposure", 0, "экспозиция в ступенях (EV): +1 - вдвое ярче")
+	srgb := flag.Bool("srgb", false, "гамма-коррекция sRGB")
+	wireframe := flag.Bool("wireframe", false, "наложить каркас примитивов")
+	progress := flag.Bool("progress", true, "выводить ход рендера в stderr")
+	cameraPathFile := flag.String("camera-path", "", "CSV-файл пути камеры: frame,x,y,z,lookX,lookY,lookZ[,fov] по строке на ключевой кадр")
+	frames := flag.Int("frames", 0, "отрендерить анимацию из N кадров (result_0001.png ...)")
+	checkpoints := flag.Bool("checkpoints", false, "сохранять контрольные снимки после 1%, 2%, 4%... сэмплов; в имени - число сэмплов снимка (result_s0004.png ...)")
+	guide := flag.Bool("guide", false, "направленное сэмплирование для -integrator path: первая четверть сэмплов учит кэш яркости, остальные выбирают по нему направления отскоков")
+	reuseTiles := flag.Bool("reuse-tiles", true, "в анимации рендерить заново только тайлы, которых коснулись изменения сцены")
+	compare := flag.String("compare", "", "сравнить два интегратора на одном кадре, например whitted,path")
+	manifest := flag.String("batch", "", "файл со списком сцен для пакетного рендера")
+	studio := flag.Bool("studio", false, "студийная постановка: пол - ловец теней, купол неба, автоматическое кадрирование")
+	override := flag.String("override-material", "", "заменить материалы всех объектов: "+strings.Join(materialOverrideNames(), ", "))
+	printRenderStats := flag.Bool("stats", false, "вывести статистику лучей, пересечений и глубины рекурсии после рендера")
+	golden := flag.String("golden", "", "сравнить рендер эталонных сцен с изображениями из каталога, например testdata/golden, и выйти")
+	goldenUpdate := flag.Bool("golden-update", false, "с -golden перезаписать эталонные изображения вместо сравнения")
+	maxMemory := flag.String("max-memory", "", "бюджет памяти, например 512M или 2G: при нехватке текстуры уменьшаются, буферы кадра должны поместиться")
+	kernel := flag.String("kernel", "auto", "ядро пересечений: "+strings.Join(kernelNames, ", ")+" (auto - по возможностям процессора)")
+	previewAddr := flag.String("preview", "", "запустить HTTP-сервер предпросмотра с настройкой экспозиции, угла обзора и источников, например :8080")
+	farm := flag.String("farm", "", "рендерить на воркерах фермы: адреса host:port через запятую")
+	workerAddr := flag.String("worker", "", "работать воркером фермы на адресе, например :9000")
+	workerRoot := flag.String("worker-root", "", "каталог, из которого воркер фермы читает файлы ресурсов сцен (пусто - только сцены без файлов)")
+	bakeSH := flag.String("bake-sh", "", "запечь освещенность объектов в сферические гармоники второго порядка, записать в JSON-файл и выйти")
+	bakeSamples := flag.Int("bake-samples", 4096, "число лучей на объект для -bake-sh")
+	saveScene := flag.String("save-scene", "", "записать сцену с учетом переопределений командной строки в файл сцены и выйти")
+	saveSnapshot := flag.String("save-snapshot", "", "записать подготовленную сцену с раскодированными текстурами в двоичный снимок и выйти; снимок загружается через -scene")
+	serveAddr := flag.String("serve", "", "запустить HTTP-сервис рендера на адресе, например :8080")
+	denoiseFlag := flag.Bool("denoise", false, "подавить шум после рендера с помощью нормалей и альбедо первичных попаданий")
+	region := flag.String("region", "", "рендерить только прямоугольник кадра x,y,w,h (в пикселях) и сохранить его")
+	lightPasses := flag.Bool("light-passes", false, "сохранить рядом с результатом по кадру на каждый источник света, в котором горит только он (result_light_point1.png ...)")
+	aovList := flag.String("aov", "", "вспомогательные проходы через запятую: "+strings.Join(aovNames, ", "))
+	flag.Parse()
 
-	for j := 0; j < height; j++ {
-		for i := 0; i < width; i++ {
-			x := (2*(float64(i)+0.5)/float64(width) - 1) * math.Tan(fov/2) * float64(width) / float64(height)
-			y := -(2*(float64(j)+0.5)/float64(height) - 1) * math.Tan(fov/2)
-			dir := Vec3f{x, y, -1}.Normalize()
-			col := castRay(Vec3f{0, 0, 0}, dir, spheres, lights, depth)
-			img.Set(i, j, colorToRGBA(col))
+	if !slices.Contains(kernelNames, *kernel) {
+		fmt.Fprintf(os.Stderr, "unknown kernel %q\n", *kernel)
+		os.Exit(2)
+	}
+	selectKernel(*kernel)
+
+	if *golden != "" {
+		if !runGolden(context.Background(), os.Stdout, *golden, *goldenUpdate) {
+			os.Exit(1)
 		}
+		return
 	}
 
-	file, err := os.Create("result.png")
+	if *checkpoints && (*maxSamples > *samples || *farm != "") {
+		fmt.Fprintln(os.Stderr, "-checkpoints cannot be combined with -max-spp or -farm")
+		os.Exit(2)
+	}
+	if *checkpoints && len(checkpointStages(*samples)) < 2 {
+		// С одним сэмплом на пиксель снимок сохранять не после чего
+		fmt.Fprintln(os.Stderr, "-checkpoints needs -spp of at least 2")
+		os.Exit(2)
+	}
+	if !slices.Contains(integratorNames, *integrator) {
+		fmt.Fprintf(os.Stderr, "unknown integrator %q\n", *integrator)
+		os.Exit(2)
+	}
+	if *lightPasses && (*farm != "" || *region != "" || *compare != "") {
+		fmt.Fprintln(os.Stderr, "-light-passes cannot be combined with -farm, -region or -compare")
+		os.Exit(2)
+	}
+	if *guide && (*integrator != "path" || *farm != "") {
+		fmt.Fprintln(os.Stderr, "-guide needs -integrator path and cannot be combined with -farm")
+		os.Exit(2)
+	}
+	var regionRect image.Rectangle
+	if *region != "" {
+		if *aovList != "" || *denoiseFlag || *checkpoints || *guide || *farm != "" || *compare != "" {
+			fmt.Fprintln(os.Stderr, "-region cannot be combined with -aov, -denoise, -checkpoints, -guide, -farm or -compare")
+			os.Exit(2)
+		}
+		var err error
+		if regionRect, err = parseRegion(*region, frameWidth, frameHeight); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	sink, outputPath, err := parseDestination(*output)
+	if err == nil {
+		err = checkOutputPath(outputPath)
+	}
+	if u, ok := sink.(UploadSink); ok && u.presigned() && (*aovList != "" || *checkpoints || *lightPasses || *frames > 0 || *manifest != "") {
+		err = errors.New("presigned -out URL is valid for one file: -aov, -checkpoints, -light-passes, -frames and -batch write several")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if _, ok := toneMappers[*toneMap]; !ok {
+		fmt.Fprintf(os.Stderr, "unknown tone mapper %q\n", *toneMap)
+		os.Exit(2)
+	}
+	if _, ok := materialOverrides[*override]; *override != "" && !ok {
+		fmt.Fprintf(os.Stderr, "unknown material override %q\n", *override)
+		os.Exit(2)
+	}
+	aovs, err := parseAOVs(*aovList)
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	var cameraPath *CameraAnimation
+	if *cameraPathFile != "" {
+		if cameraPath, err = LoadCameraPath(*cameraPathFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	var memoryBudget int64
+	if *maxMemory != "" {
+		if memoryBudget, err = parseByteSize(*maxMemory); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if err := checkFrameMemory(memoryBudget, len(aovs), *denoiseFlag, *maxSamples > *samples || *checkpoints || *guide); err != nil {
+			fmt.Fprintln(os.Stderr, "-max-memory:", err)
+			os.Exit(2)
+		}
+		limitTextures(memoryBudget, len(aovs), *denoiseFlag, *maxSamples > *samples || *checkpoints || *guide)
+	}
+
+	// prepare применяет к загруженной сцене переопределения из командной строки
+	prepare := func(scene *Scene) {
+		if cameraPath != nil {
+			scene.setCameraPath(cameraPath)
+		}
+		if *maxReflect > 0 {
+			scene.MaxReflectDistance = *maxReflect
+		}
+		if *normalizeLights {
+			scene.NormalizeLights = true
+		}
+		if *studio {
+			scene.studio()
+		}
+		if *override != "" {
+			scene.overrideMaterial(materialOverrides[*override])
+		}
+		if memoryBudget > 0 {
+			for _, note := range scene.fitMemory(memoryBudget, len(aovs), *denoiseFlag, *maxSamples > *samples || *checkpoints || *guide) {
+				fmt.Fprintln(os.Stderr, "max-memory:", note)
+			}
+		}
+	}
+
+	opts := RenderOptions{
+		Depth:       *depth,
+		Samples:     *samples,
+		MaxSamples:  *maxSamples,
+		Noise:       *noise,
+		Integrator:  *integrator,
+		AOVs:        aovs,
+		ToneMap:     *toneMap,
+		Exposure:    *exposure,
+		SRGB:        *srgb,
+		Output:      outputPath,
+		Sink:        sink,
+		Wireframe:   *wireframe,
+		JPEGQuality: *jpegQuality,
+		Scene:       *scenePath,
+		Seed:        *seed,
+		Denoise:     *denoiseFlag,
+		Checkpoints: *checkpoints,
+		Guide:       *guide,
+		Farm:        parseFarm(*farm),
+		Region:      regionRect,
+		LightPasses: *lightPasses,
+	}
+	if *progress {
+		opts.Progress = NewBarProgress(os.Stderr)
+	}
+
+	// Ctrl-C прерывает рендер, частично готовое изображение сохраняется
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *workerAddr != "" {
+		fmt.Fprintf(os.Stderr, "farm worker: %s\n", *workerAddr)
+		if err := serveFarmWorker(ctx, *workerAddr, *workerRoot); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Пакетный режим: сцены перечислены аргументами или в манифесте
+	if flag.NArg() > 0 || *manifest != "" {
+		if *frames > 0 || *scenePath != "" {
+			fmt.Fprintln(os.Stderr, "-frames and -scene cannot be combined with batch rendering")
+			os.Exit(2)
+		}
+		var jobs []batchJob
+		if *manifest != "" {
+			jobs, err = readManifest(*manifest, opts.Output)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		for _, path := range flag.Args() {
+			jobs = append(jobs, batchJob{Scene: path, Output: batchOutput(path, opts.Output)})
+		}
+		reports := renderBatch(ctx, jobs, opts, prepare)
+		printBatchReport(os.Stderr, reports)
+		// Пакет прерван, либо часть сцен не удалось отрендерить
+		failed := len(reports) < len(jobs)
+		for _, r := range reports {
+			failed = failed || r.Err != nil
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
 	}
-	defer func(file *os.File) {
-		err := file.Close()
+
+	scene := defaultScene()
+	if *scenePath != "" {
+		scene, err = LoadScene(*scenePath)
 		if err != nil {
-			fmt.Printf("Close error")
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-	}(file)
+	}
+	prepare(scene)
 
-	err = png.Encode(file, img)
-	if err != nil {
-		fmt.Printf("Encode error")
+	if *saveScene != "" {
+		if err := scene.Save(*saveScene); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
-}
 
-func main() {
-	// Источники света
-	lights := []Light{
-		*NewLight(Vec3f{X: 1.0, Y: 2.0, Z: 3.0}, 1.4),
-		*NewLight(Vec3f{X: 3.0, Y: -2.0, Z: -3.0}, 1.0),
+	if *saveSnapshot != "" {
+		if err := scene.SaveSnapshot(*saveSnapshot); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	// Инициализация сцены с несколькими сферами
-	spheres := []Sphere{
-		{Center: Vec3f{X: 2.1, Y: 0, Z: -3}, Radius: 0.8, Color: Vec3f{X: 0.4, Y: 0.4, Z: 0.3}, Albedo: 0.25, SpecularExponent: 50},
-		{Center: Vec3f{X: 4, Y: 4, Z: -10}, Radius: 1.5, Color: Vec3f{X: 0.7, Y: 0.3, Z: 0.5}, Albedo: 0.5, SpecularExponent: 50},
-		{Center: Vec3f{X: 2, Y: -2.5, Z: -5}, Radius: 1.2, Color: Vec3f{X: 0.3, Y: 0.6, Z: 0.7}, Albedo: 0.5, SpecularExponent: 50},
-		{Center: Vec3f{X: -2, Y: 0, Z: -10}, Radius: 4.2, Color: Vec3f{X: 0.3, Y: 0.1, Z: 0.9}, Albedo: 0.5, SpecularExponent: 50},
+	if *bakeSH != "" {
+		bakeSink, bakePath, err := parseDestination(*bakeSH)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		bake, err := BakeSH(ctx, scene, SHBakeOptions{Samples: *bakeSamples, Depth: *depth, Integrator: *integrator, Seed: *seed})
+		if err == nil {
+			err = writeFile(bakeSink, bakePath, bake.Encode)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *previewAddr != "" {
+		fmt.Fprintf(os.Stderr, "preview: http://%s/\n", *previewAddr)
+		if err := servePreview(ctx, *previewAddr, scene, opts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *serveAddr != "" {
+		fmt.Fprintf(os.Stderr, "serve: http://%s/\n", *serveAddr)
+		if err := serveRender(ctx, *serveAddr, scene, opts, prepare); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	// Рендер. Depth - глубина рекурсии
-	render(spheres, lights, 200)
+	if *compare != "" {
+		a, b, err := parseCompare(*compare)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		res, err := RenderCompare(ctx, scene, opts, a, b)
+		if res != nil {
+			opts.AOVs = nil
+			opts.Integrator = *compare // Для метаданных
+			printFailures(os.Stderr, res)
+			if saveErr := res.save(opts); saveErr != nil {
+				err = saveErr
+			}
+			if *printRenderStats {
+				printStats(os.Stderr, res)
+			}
+		}
+		if err != nil {
+			exitRenderError(err, opts.Output)
+		}
+		return
+	}
+
+	if *frames <= 0 {
+		res, err := render(ctx, scene, opts)
+		if res != nil && *printRenderStats {
+			printStats(os.Stderr, res)
+		}
+		if err != nil {
+			exitRenderError(err, opts.Output)
+		}
+		return
+	}
+	if *reuseTiles && len(opts.Farm) == 0 && !opts.Checkpoints && !opts.Guide && opts.Region.Empty() {
+		opts.Cache = &FrameCache{}
+	}
+	for frame := 1; frame <= *frames; frame++ {
+		frameOpts := opts
+		frameOpts.Output = framePath(opts.Output, frame)
+		res, err := render(ctx, scene.atFrame(float64(frame)), frameOpts)
+		if res != nil && *printRenderStats {
+			printStats(os.Stderr, res)
+		}
+		if err != nil {
+			exitRenderError(err, frameOpts.Output)
+		}
+		if res.Reused > 0 {
+			fmt.Fprintf(os.Stderr, "frame %d/%d: %s (%d tiles reused)\n", frame, *frames, frameOpts.Output, res.Reused)
+		} else {
+			fmt.Fprintf(os.Stderr, "frame %d/%d: %s\n", frame, *frames, frameOpts.Output)
+		}
+	}
+}
+
+// exitRenderError сообщает об ошибке рендера и завершает процесс.
+func exitRenderError(err error, output string) {
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "render interrupted, partial image saved to %s\n", output)
+		os.Exit(130)
+	}
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// Fog - однородный туман, заполняющий всю сцену: свет от поверхности на
+// расстоянии d ослабляется в exp(-Density*d) раз, а остаток заменяется
+// цветом тумана. Лучи, ушедшие в фон, туман не затягивает.
+type Fog struct {
+	Density float64 `json:"density"`
+	Color   Vec3f   `json:"color"`
+}
+
+// volumeSteps - число шагов интегрирования рассеяния в объеме по умолчанию.
+const volumeSteps = 32
+
+// Volume - сфера однородной рассеивающей среды (дым, пыль в луче света).
+// Среда ослабляет проходящий свет и рассеивает свет источников в сторону
+// луча, поэтому тени объектов внутри нее становятся видимыми лучами.
+// Учитывается только однократное рассеяние; свет источника на пути к точке
+// рассеяния средой не ослабляется.
+type Volume struct {
+	Center  Vec3f   `json:"center"`
+	Radius  float64 `json:"radius"`
+	Density float64 `json:"density"` // Коэффициент ослабления на единицу длины
+	Color   Vec3f   `json:"color"`   // Доля рассеянного света по каналам (альбедо среды)
+	Steps   int     `json:"steps,omitempty"`
+}
+
+// segment возвращает отрезок луча r внутри сферы объема, обрезанный до
+// [0, maxDist]; ok = false, если луч объем не пересекает.
+func (v *Volume) segment(r Ray, maxDist float64) (t0, t1 float64, ok bool) {
+	L := v.Center.Subtract(r.Origin)
+	tca := L.Dot(r.Dir)
+	d2 := L.Length2() - tca*tca
+	if d2 > v.Radius*v.Radius {
+		return 0, 0, false
+	}
+	thc := math.Sqrt(v.Radius*v.Radius - d2)
+	t0, t1 = math.Max(0, tca-thc), math.Min(maxDist, tca+thc)
+	return t0, t1, t0 < t1
+}
+
+// throughMedium возвращает цвет color, пришедший по лучу r с расстояния
+// dist, после прохождения среды.
+func (s *Scene) throughMedium(r Ray, dist float64, color Vec3f, rng *rand.Rand) Vec3f {
+	if !s.hasMedium() {
+		return color
+	}
+	t, in := s.medium(r, dist, rng)
+	return color.MulScalar(t).Add(in)
+}
+
+// hasMedium сообщает, есть ли в сцене туман или объемы.
+func (s *Scene) hasMedium() bool {
+	return (s.Fog != nil && s.Fog.Density > 0) || len(s.Volumes) > 0
+}
+
+// medium возвращает пропускание среды на отрезке луча r длиной dist
+// (+Inf - луч ушел в фон) и свет, который среда добавляет к лучу на этом
+// отрезке. Цвет, видимый по лучу, равен color*transmittance + inscatter.
+func (s *Scene) medium(r Ray, dist float64, rng *rand.Rand) (transmittance float64, inscatter Vec3f) {
+	transmittance = 1
+	if f := s.Fog; f != nil && f.Density > 0 && !math.IsInf(dist, 1) {
+		t := math.Exp(-f.Density * dist)
+		transmittance *= t
+		inscatter = inscatter.Add(f.Color.MulScalar(1 - t))
+	}
+	for i := range s.Volumes {
+		v := &s.Volumes[i]
+		t0, t1, ok := v.segment(r, dist)
+		if !ok {
+			continue
+		}
+		steps := v.Steps
+		if steps < 1 {
+			steps = volumeSteps
+		}
+		// Интегрирование с шагом dt; точки внутри шагов сдвинуты случайно,
+		// чтобы вместо полос получался шум
+		dt := (t1 - t0) / float64(steps)
+		q := &lightQuery{scale: s.lightNorm(), single: true, volume: true}
+		T := 1.0
+		var sum Vec3f
+		offset := rng.Float64()
+		for k := 0; k < steps; k++ {
+			q.point = r.At(t0 + (float64(k)+offset)*dt)
+			light, _ := s.directLight(q, rng)
+			sum = sum.Add(v.Color.Mul(light).MulScalar(T * v.Density * dt))
+			T *= math.Exp(-v.Density * dt)
+		}
+		inscatter = inscatter.Add(sum.MulScalar(transmittance))
+		transmittance *= T
+	}
+	return transmittance, inscatter
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Размер в памяти одного элемента буферов рендера, в байтах.
+const (
+	texelBytes   = 24 + 8           // Цвет и альфа текселя
+	envBytes     = 24               // Пиксель карты окружения
+	pixelBytes   = 24 + 4           // Пиксель кадра и его 8-битная копия при сохранении
+	aovBytes     = 1 + 3*24 + 8 + 4 // Попадание, точка, нормаль, альбедо, глубина и номер объекта
+	denoiseBytes = 3 * 24           // Промежуточные буферы шумоподавления
+	// Сэмплы пикселя, их погрешность и поток случайных чисел, см.
+	// adaptiveRect и progressive
+	sampleBytes = 24 + 3*8 + 8 + 8 + 16
+)
+
+// minTextureSide - текстуры не уменьшаются меньше этого размера.
+const minTextureSide = 16
+
+// parseByteSize разбирает размер вида 512M, 2G, 1.5GiB или 100000 (байты).
+func parseByteSize(s string) (int64, error) {
+	num := strings.TrimSpace(s)
+	num = strings.TrimSuffix(strings.TrimSuffix(num, "B"), "i")
+	mult := 1.0
+	if n := len(num); n > 0 {
+		switch strings.ToUpper(num[n-1:]) {
+		case "K":
+			mult = 1 << 10
+		case "M":
+			mult = 1 << 20
+		case "G":
+			mult = 1 << 30
+		}
+		if mult > 1 {
+			num = num[:n-1]
+		}
+	}
+	x, err := strconv.ParseFloat(num, 64)
+	if err != nil || x <= 0 {
+		return 0, fmt.Errorf("invalid memory size %q", s)
+	}
+	return int64(x * mult), nil
+}
+
+// frameMemory оценивает память буферов кадра с aovs вспомогательными
+// проходами и, если denoise, с шумоподавлением, а если samples - с
+// сэмплами каждого пикселя (адаптивное сэмплирование, контрольные снимки,
+// направленное сэмплирование).
+func frameMemory(aovs int, denoise, samples bool) int64 {
+	px := int64(frameWidth * frameHeight)
+	total := px * pixelBytes
+	if aovs > 0 || denoise {
+		total += px * (aovBytes + int64(aovs)*pixelBytes)
+	}
+	if denoise {
+		total += px * denoiseBytes
+	}
+	if samples {
+		total += px * sampleBytes
+	}
+	return total
+}
+
+// checkFrameMemory проверяет, что буферы кадра (см. frameMemory)
+// укладываются в бюджет budget байт. В отличие от текстур они не
+// уменьшаются, поэтому при нехватке рендер не начинается.
+func checkFrameMemory(budget int64, aovs int, denoise, samples bool) error {
+	if frame := frameMemory(aovs, denoise, samples); frame > budget*3/4 {
+		return fmt.Errorf("frame buffers need %s, more than 3/4 of the %s budget (the rest is left for the scene and runtime)", formatBytes(frame), formatBytes(budget))
+	}
+	return nil
+}
+
+// limitTextures ограничивает размер текстур, загружаемых после вызова,
+// так, чтобы любая из них вместе с буферами кадра (см. frameMemory)
+// укладывалась в бюджет budget байт: крупная текстура уменьшается еще при
+// загрузке и не занимает память в полном размере.
+func limitTextures(budget int64, aovs int, denoise, samples bool) {
+	textureLimit = max(1, (budget*3/4-frameMemory(aovs, denoise, samples))/texelBytes)
+}
+
+// fitMemory укладывает рендер сцены с aovs вспомогательными проходами,
+// шумоподавлением (denoise) и сэмплами каждого пикселя (samples) в
+// бюджет памяти budget байт: Go получает мягкий предел памяти, а если
+// текстуры и карта окружения вместе с буферами кадра в бюджет не
+// помещаются, самые большие из них уменьшаются вдвое, пока не поместятся.
+// Качество текстур при этом падает, но рендер не завершается нехваткой
+// памяти. Сами буферы кадра должны помещаться в бюджет заранее (см.
+// checkFrameMemory).
+// Возвращает описания сделанных уступок.
+func (s *Scene) fitMemory(budget int64, aovs int, denoise, samples bool) []string {
+	debug.SetMemoryLimit(budget)
+	var notes []string
+	// Запас на сцену, стеки горутин и сборщик мусора
+	avail := max(0, budget*3/4-frameMemory(aovs, denoise, samples))
+
+	var textures []*Texture
+	for _, obj := range s.objects {
+		m := obj.material()
+		for _, t := range []*Texture{m.texture, m.normalMap} {
+			if t != nil && !slices.Contains(textures, t) {
+				textures = append(textures, t)
+			}
+		}
+	}
+	used := func() int64 {
+		total := int64(0)
+		for _, t := range textures {
+			total += int64(t.Width*t.Height) * texelBytes
+		}
+		if s.envMap != nil {
+			total += int64(s.envMap.Width*s.envMap.Height) * envBytes
+		}
+		return total
+	}
+
+	replaced := map[*Texture]*Texture{}
+	for used() > avail {
+		// Уменьшается самый большой ресурс
+		big, bigBytes := -1, int64(0)
+		for i, t := range textures {
+			if b := int64(t.Width*t.Height) * texelBytes; b > bigBytes && min(t.Width, t.Height) >= 2*minTextureSide {
+				big, bigBytes = i, b
+			}
+		}
+		env := s.envMap
+		if env != nil && int64(env.Width*env.Height)*envBytes > bigBytes && min(env.Width, env.Height) >= 2*minTextureSide {
+			s.envMap = env.halve()
+			notes = append(notes, fmt.Sprintf("envmap reduced to %dx%d", s.envMap.Width, s.envMap.Height))
+			continue
+		}
+		if big < 0 {
+			notes = append(notes, fmt.Sprintf("textures need %s even at minimum size", formatBytes(used())))
+			break
+		}
+		old := textures[big]
+		textures[big] = old.halve()
+		replaced[old] = textures[big]
+		notes = append(notes, fmt.Sprintf("texture reduced to %dx%d", textures[big].Width, textures[big].Height))
+	}
+
+	// Материалы переключаются на уменьшенные текстуры (цепочки уменьшений
+	// одной текстуры проходятся до конца)
+	final := func(t *Texture) *Texture {
+		for replaced[t] != nil {
+			t = replaced[t]
+		}
+		return t
+	}
+	for _, obj := range s.objects {
+		m := obj.material()
+		m.texture, m.normalMap = final(m.texture), final(m.normalMap)
+	}
+	return notes
+}
+
+// halve возвращает текстуру вдвое меньшего размера: каждый тексель -
+// среднее квадрата 2x2 исходных.
+func (t *Texture) halve() *Texture {
+	w, h := t.Width/2, t.Height/2
+	out := &Texture{Width: w, Height: h, Pixels: make([]Vec3f, w*h), Alpha: make([]float64, w*h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var c Vec3f
+			a := 0.0
+			for _, k := range [4]int{2*y*t.Width + 2*x, 2*y*t.Width + 2*x + 1, (2*y+1)*t.Width + 2*x, (2*y+1)*t.Width + 2*x + 1} {
+				c = c.Add(t.Pixels[k])
+				a += t.Alpha[k]
+			}
+			out.Pixels[y*w+x] = c.MulScalar(0.25)
+			out.Alpha[y*w+x] = a / 4
+		}
+	}
+	return out
+}
+
+// halve возвращает карту окружения вдвое меньшего размера.
+func (e *EnvMap) halve() *EnvMap {
+	w, h := e.Width/2, e.Height/2
+	out := &EnvMap{Width: w, Height: h, Pixels: make([]Vec3f, w*h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := e.texel(2*x, 2*y).Add(e.texel(2*x+1, 2*y)).Add(e.texel(2*x, 2*y+1)).Add(e.texel(2*x+1, 2*y+1))
+			out.Pixels[y*w+x] = c.MulScalar(0.25)
+		}
+	}
+	return out
+}
+
+// formatBytes форматирует размер в байтах с двоичным суффиксом.
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
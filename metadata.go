@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// MetaEntry - запись метаданных изображения.
+type MetaEntry struct {
+	Key, Value string
+}
+
+// Metadata - упорядоченный список записей, который сохраняется в заголовок
+// файла результата: по нему видно, как было получено изображение.
+type Metadata []MetaEntry
+
+func (m *Metadata) add(key, format string, args ...any) {
+	*m = append(*m, MetaEntry{key, fmt.Sprintf(format, args...)})
+}
+
+// buildVersion возвращает версию программы из информации о сборке.
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			return s.Value
+		}
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	return "devel"
+}
+
+// metadata описывает проход pass с буфером fb: настройки рендера, зерно,
+// версию программы и статистику прохода.
+func (r *RenderResult) metadata(opts RenderOptions, pass string, fb *Framebuffer) Metadata {
+	var m Metadata
+	m.add("Software", "ITMO_GoRayTracing %s (%s)", buildVersion(), runtime.Version())
+	if opts.Scene != "" {
+		m.add("Scene", "%s", opts.Scene)
+	}
+	m.add("Pass", "%s", pass)
+	m.add("Integrator", "%s", opts.Integrator)
+	m.add("Samples", "%d", max(1, opts.Samples))
+	if opts.MaxSamples > opts.Samples {
+		m.add("MaxSamples", "%d", opts.MaxSamples)
+		m.add("Noise", "%g", opts.Noise)
+		if pixels := r.Stats.pixels(); pixels > 0 {
+			m.add("SamplesMean", "%.2f", float64(r.Stats.Samples)/float64(pixels))
+		}
+	}
+	m.add("Depth", "%d", opts.Depth)
+	m.add("Seed", "%d", opts.Seed)
+	m.add("Exposure", "%g", opts.Exposure)
+	m.add("ToneMap", "%s", opts.ToneMap)
+	m.add("SRGB", "%t", opts.SRGB)
+	if opts.Denoise {
+		m.add("Denoise", "%t", opts.Denoise)
+	}
+	m.add("Rays", "%d", r.Rays)
+	m.add("RenderTime", "%.3fs", r.Duration.Seconds())
+	if n := len(r.RowTimes); n > 0 {
+		var total, slowest time.Duration
+		for _, d := range r.RowTimes {
+			total += d
+			slowest = max(slowest, d)
+		}
+		m.add("RowTimeMean", "%.3fms", float64(total)/float64(n)/float64(time.Millisecond))
+		m.add("RowTimeMax", "%.3fms", float64(slowest)/float64(time.Millisecond))
+	}
+	lo, hi, mean := fb.stats()
+	m.add("PassMin", "%g", lo)
+	m.add("PassMax", "%g", hi)
+	m.add("PassMean", "%g", mean)
+	return m
+}
+
+// stats возвращает минимум, максимум и среднее значение каналов буфера.
+func (f *Framebuffer) stats() (lo, hi, mean float64) {
+	lo, hi = math.Inf(1), math.Inf(-1)
+	sum := 0.0
+	for _, c := range f.Pixels {
+		for _, x := range [3]float64{c.X, c.Y, c.Z} {
+			lo, hi = math.Min(lo, x), math.Max(hi, x)
+			sum += x
+		}
+	}
+	if len(f.Pixels) == 0 {
+		return 0, 0, 0
+	}
+	return lo, hi, sum / float64(3*len(f.Pixels))
+}
+
+// pngWithText вставляет метаданные в закодированный PNG текстовыми блоками
+// сразу после IHDR. Значения в ASCII пишутся в tEXt, остальные - в iTXt (UTF-8).
+func pngWithText(data []byte, meta Metadata) []byte {
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4 // Сигнатура и блок IHDR
+	var buf bytes.Buffer
+	buf.Write(data[:ihdrEnd])
+	for _, e := range meta {
+		if isASCII(e.Value) {
+			writePNGChunk(&buf, "tEXt", []byte(e.Key+"\x00"+e.Value))
+		} else {
+			// Без сжатия, без языка и перевода ключа
+			writePNGChunk(&buf, "iTXt", []byte(e.Key+"\x00\x00\x00\x00\x00"+e.Value))
+		}
+	}
+	buf.Write(data[ihdrEnd:])
+	return buf.Bytes()
+}
+
+// writePNGChunk записывает блок PNG: длину, тип, данные и CRC.
+func writePNGChunk(buf *bytes.Buffer, typ string, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	buf.WriteString(typ)
+	buf.Write(data)
+	binary.Write(buf, binary.BigEndian, crc.Sum32())
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// jpegWithComment вставляет метаданные в закодированный JPEG сегментом
+// комментария COM сразу после маркера SOI.
+func jpegWithComment(data []byte, meta Metadata) []byte {
+	text := meta.lines("", ": ")
+	if len(text) > 0xFFFF-2 {
+		text = text[:0xFFFF-2]
+	}
+	var buf bytes.Buffer
+	buf.Write(data[:2])
+	buf.Write([]byte{0xFF, 0xFE})
+	binary.Write(&buf, binary.BigEndian, uint16(len(text)+2))
+	buf.WriteString(text)
+	buf.Write(data[2:])
+	return buf.Bytes()
+}
+
+// lines форматирует записи построчно: prefix, ключ, sep, значение.
+func (m Metadata) lines(prefix, sep string) string {
+	var sb strings.Builder
+	for _, e := range m {
+		sb.WriteString(prefix + e.Key + sep + strings.ReplaceAll(e.Value, "\n", " ") + "\n")
+	}
+	return sb.String()
+}
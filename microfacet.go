@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+)
+
+// dielectricF0 - отражательная способность неметаллов при нормальном падении.
+const dielectricF0 = 0.04
+
+// minGGXAlpha - наименьшая ширина распределения микрограней: у идеально
+// гладкой поверхности блик точечного источника вырождается в точку.
+const minGGXAlpha = 1e-3
+
+// ggx сообщает, что материал отражает по модели микрограней Кука-Торранса
+// с распределением GGX.
+func (m *Material) ggx() bool {
+	return m.BRDF == "ggx"
+}
+
+// ggxAlpha возвращает ширину распределения микрограней материала GGX или 0
+// для остальных моделей.
+func (m *Material) ggxAlpha() float64 {
+	if !m.ggx() {
+		return 0
+	}
+	return math.Max(m.Roughness*m.Roughness, minGGXAlpha)
+}
+
+// checkBRDF проверяет модель отражения и ее параметры.
+func (m *Material) checkBRDF() error {
+	switch m.BRDF {
+	case "", "phong", "ggx":
+	default:
+		return fmt.Errorf("unknown brdf %q (want phong or ggx)", m.BRDF)
+	}
+	if m.Roughness < 0 || m.Roughness > 1 {
+		return fmt.Errorf("roughness must be between 0 and 1, got %g", m.Roughness)
+	}
+	if m.Metalness < 0 || m.Metalness > 1 {
+		return fmt.Errorf("metalness must be between 0 and 1, got %g", m.Metalness)
+	}
+	return nil
+}
+
+// ggxD - распределение нормалей микрограней GGX; nh - косинус угла между
+// нормалью и половинным вектором.
+func ggxD(nh, alpha float64) float64 {
+	a2 := alpha * alpha
+	d := nh*nh*(a2-1) + 1
+	return a2 / (math.Pi * d * d)
+}
+
+// smithG1 - доля микрограней, видимых под углом с косинусом c (Смит, GGX).
+func smithG1(c, alpha float64) float64 {
+	a2 := alpha * alpha
+	return 2 * c / (c + math.Sqrt(a2+(1-a2)*c*c))
+}
+
+// ggxSpecular возвращает бликовую составляющую света, приходящего по
+// направлению l, в направлении взгляда v без учета френелевского отражения.
+// Множитель Pi переводит BRDF в единицы освещения сцены, в которых
+// ламбертова поверхность цвета Color отражает Color * cos.
+func ggxSpecular(N, v, l Vec3f, alpha float64) float64 {
+	nl, nv := N.Dot(l), N.Dot(v)
+	if nl <= 0 || nv <= 0 {
+		return 0
+	}
+	h := v.Add(l).Normalize()
+	return math.Pi * ggxD(N.Dot(h), alpha) * smithG1(nl, alpha) * smithG1(nv, alpha) / (4 * nv)
+}
+
+// microfacet - материал GGX в точке поверхности: диффузный слой под
+// бликовым. Френелевское отражение берется по углу взгляда, а не по
+// половинному вектору, поэтому прямой свет сводится к интенсивностям
+// Scene.illuminate, а отраженный луч не меняет вес слоев.
+type microfacet struct {
+	N, V    Vec3f   // Нормаль и направление на наблюдателя
+	alpha   float64 // Ширина распределения микрограней
+	diffuse Vec3f   // Цвет диффузного слоя: металл его не имеет
+	fresnel Vec3f   // Доля света, отраженная бликовым слоем
+}
+
+// microfacet возвращает материал m в точке с цветом color и нормалью N,
+// видимой лучом с направлением dir.
+func (m *Material) microfacet(color, N, dir Vec3f) microfacet {
+	f0 := Vec3f{dielectricF0, dielectricF0, dielectricF0}
+	f0 = f0.MulScalar(1 - m.Metalness).Add(color.MulScalar(m.Metalness))
+	v := dir.Negate()
+	w := math.Pow(1-math.Max(0, math.Min(1, N.Dot(v))), 5) // Аппроксимация Шлика
+	fresnel := f0.MulScalar(1 - w).Add(Vec3f{w, w, w})
+	diffuse := color.MulScalar(1 - m.Metalness).Mul(Vec3f{1 - fresnel.X, 1 - fresnel.Y, 1 - fresnel.Z})
+	return microfacet{N: N, V: v, alpha: m.ggxAlpha(), diffuse: diffuse, fresnel: fresnel}
+}
+
+// direct возвращает цвет прямого освещения по составляющим Scene.illuminate.
+func (f *microfacet) direct(diffuse, specular Vec3f) Vec3f {
+	return f.diffuse.Mul(diffuse).Add(f.fresnel.Mul(specular))
+}
+
+// sample выбирает направление отраженного луча: по распределению GGX для
+// бликового слоя или косинусно-взвешенное для диффузного, с вероятностью,
+// пропорциональной их вкладу. Возвращает направление, вес луча
+// (BRDF * cos / плотность) и признак бликового отражения. Нулевой вес -
+// луч ушел под поверхность и путь обрывается.
+func (f *microfacet) sample(rng *rand.Rand) (dir, weight Vec3f, glossy bool) {
+	fs := (f.fresnel.X + f.fresnel.Y + f.fresnel.Z) / 3
+	fd := (f.diffuse.X + f.diffuse.Y + f.diffuse.Z) / 3
+	// Блик выбирается не реже чем в четверти случаев: иначе у неметаллов
+	// отражения окружения собирались бы слишком редкими лучами
+	pSpec := 1.0
+	if fd > 0 {
+		pSpec = math.Max(fs/(fs+fd), 0.25)
+	}
+	if rng.Float64() >= pSpec {
+		return cosineSampleHemisphere(f.N, rng), f.diffuse.MulScalar(1 / (1 - pSpec)), false
+	}
+
+	// Половинный вектор с плотностью D(h) * cos(h)
+	u := rng.Float64()
+	phi := 2 * math.Pi * rng.Float64()
+	cosTheta := math.Sqrt((1 - u) / (1 + (f.alpha*f.alpha-1)*u))
+	sinTheta := math.Sqrt(math.Max(0, 1-cosTheta*cosTheta))
+	t, b := orthonormalBasis(f.N)
+	h := t.MulScalar(sinTheta * math.Cos(phi)).Add(b.MulScalar(sinTheta * math.Sin(phi))).Add(f.N.MulScalar(cosTheta))
+
+	dir = reflect(f.V.Negate(), h).Normalize()
+	nl, nv, vh := f.N.Dot(dir), f.N.Dot(f.V), f.V.Dot(h)
+	if nl <= 0 || nv <= 0 || vh <= 0 {
+		return dir, Vec3f{}, true
+	}
+	// D и 4 * vh сокращаются с плотностью направления D * nh / (4 * vh)
+	g := smithG1(nl, f.alpha) * smithG1(nv, f.alpha)
+	return dir, f.fresnel.MulScalar(g * vh / (nv * cosTheta * pSpec)), true
+}
@@ -0,0 +1,84 @@
+package main
+
+// Moving - объект, равномерно смещающийся за время выдержки кадра на
+// Motion: при открытии затвора он на своем месте, при закрытии - сдвинут
+// на Motion. Момент выдержки берется из сэмплера, которому принадлежит
+// копия сцены (см. Scene.withShutter); без него объект стоит на месте.
+type Moving struct {
+	Object Hittable
+	Motion Vec3f
+	time   *float64 // Момент выдержки текущего сэмпла, от 0 до 1
+}
+
+// offset возвращает смещение объекта в текущий момент выдержки.
+func (m *Moving) offset() Vec3f {
+	if m.time == nil {
+		return Vec3f{}
+	}
+	return m.Motion.MulScalar(*m.time)
+}
+
+func (m *Moving) RayIntersect(orig, dir Vec3f) (bool, float64) {
+	return m.Object.RayIntersect(orig.Subtract(m.offset()), dir)
+}
+
+func (m *Moving) normalAt(point Vec3f) Vec3f {
+	return m.Object.normalAt(point.Subtract(m.offset()))
+}
+
+func (m *Moving) uv(point Vec3f) (float64, float64) {
+	return objectUV(m.Object, point.Subtract(m.offset()))
+}
+
+// bounds возвращает параллелепипед, содержащий объект во все моменты выдержки.
+func (m *Moving) bounds() AABB {
+	b := m.Object.bounds()
+	return b.union(AABB{Min: b.Min.Add(m.Motion), Max: b.Max.Add(m.Motion)})
+}
+
+func (m *Moving) material() *Material { return m.Object.material() }
+
+// hasMotion сообщает, движутся ли за время выдержки камера или объекты сцены.
+func (s *Scene) hasMotion() bool {
+	return s.moving || s.Camera.Motion != nil
+}
+
+// withShutter возвращает копию сцены, движущиеся объекты которой стоят
+// в момент выдержки *time. Копия принадлежит одной горутине рендера:
+// она меняет *time перед каждым сэмплом.
+func (s *Scene) withShutter(time *float64) *Scene {
+	out := *s
+	out.objects = make([]Hittable, len(s.objects))
+	for i, obj := range s.objects {
+		if m, ok := obj.(*Moving); ok {
+			obj = &Moving{Object: m.Object, Motion: m.Motion, time: time}
+		}
+		out.objects[i] = obj
+	}
+	return &out
+}
+
+// shutterMotion возвращает смещение объекта с анимацией a за выдержку
+// длиной shutter кадров, начинающуюся в кадре frame; center и transform -
+// центр и преобразование объекта без анимации. Движение считается
+// линейным: учитывается только перемещение центра, а не поворот и
+// масштаб. Без анимации или выдержки возвращается motion, заданное в сцене.
+func shutterMotion(motion *Vec3f, center Vec3f, transform *Transform, a *ObjectAnimation, frame, shutter float64) *Vec3f {
+	if a == nil || shutter <= 0 {
+		return motion
+	}
+	at := func(frame float64) Vec3f {
+		c, t := center, transform
+		animateObject(&c, &t, a, frame)
+		if t == nil {
+			return c
+		}
+		toWorld, _ := t.matrices()
+		return toWorld.Point(c)
+	}
+	m := at(frame + shutter).Subtract(at(frame))
+	if m.Length2() == 0 {
+		return nil
+	}
+	return &m
+}
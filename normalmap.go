@@ -0,0 +1,65 @@
+package main
+
+import "math"
+
+// step - шаг численного дифференцирования развертки в долях
+// отступа лучей в точке (см. EpsilonPolicy): так шаг растет вместе с
+// масштабом сцены и ошибкой округления координат.
+const tangentStep = 0.1
+
+// shadingNormal возвращает нормаль N в точке point, возмущенную картой
+// нормалей материала объекта. Без карты нормаль не меняется.
+func (s *Scene) shadingNormal(obj Hittable, point, N Vec3f) Vec3f {
+	m := obj.material()
+	if m.normalMap == nil {
+		return N
+	}
+	T, B, ok := tangentFrame(obj, point, N, tangentStep*s.epsilon.at(point))
+	if !ok {
+		return N
+	}
+	c := m.normalMap.At(objectUV(obj, point))
+	strength := m.NormalStrength
+	if strength == 0 {
+		strength = 1
+	}
+	x, y, z := (2*c.X-1)*strength, (2*c.Y-1)*strength, 2*c.Z-1
+	n := T.MulScalar(x).Add(B.MulScalar(y)).Add(N.MulScalar(math.Max(z, 1e-3)))
+	return n.Normalize()
+}
+
+// tangentFrame строит касательный базис в точке поверхности: T направлен
+// в сторону роста u, B - вверх по изображению (в сторону убывания v).
+// Производные развертки находятся численно, поэтому базис строится для
+// любого объекта, включая преобразованные и с автоматической разверткой,
+// с шагом step.
+func tangentFrame(obj Hittable, point, N Vec3f, step float64) (T, B Vec3f, ok bool) {
+	t1, t2 := orthonormalBasis(N)
+	u0, v0 := objectUV(obj, point)
+	// Якобиан развертки по касательным направлениям t1, t2
+	var du, dv [2]float64
+	for k, t := range [2]Vec3f{t1, t2} {
+		u, v := objectUV(obj, point.Add(t.MulScalar(step)))
+		du[k] = wrapDelta(u-u0) / step
+		dv[k] = wrapDelta(v-v0) / step
+	}
+	det := du[0]*dv[1] - du[1]*dv[0]
+	if math.Abs(det) < 1e-12 {
+		return Vec3f{}, Vec3f{}, false
+	}
+	// Обратный якобиан дает dP/du и dP/dv
+	dPdu := t1.MulScalar(dv[1] / det).Add(t2.MulScalar(-dv[0] / det))
+	dPdv := t1.MulScalar(-du[1] / det).Add(t2.MulScalar(du[0] / det))
+	T = dPdu.Subtract(N.MulScalar(dPdu.Dot(N))).Normalize()
+	B = N.Cross(T)
+	if B.Dot(dPdv) > 0 {
+		B = B.Negate()
+	}
+	return T, B, true
+}
+
+// wrapDelta приводит разность текстурных координат к [-0.5, 0.5]: шов
+// развертки не должен давать скачок производной.
+func wrapDelta(d float64) float64 {
+	return d - math.Round(d)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// encodeParams - параметры кодирования изображения.
+type encodeParams struct {
+	Quality int      // Качество JPEG (0 - по умолчанию)
+	Meta    Metadata // Метаданные для заголовка файла, если формат их допускает
+}
+
+// ldrEncoders - форматы с 8 битами на канал, выбираемые по расширению файла.
+var ldrEncoders = map[string]func(io.Writer, image.Image, encodeParams) error{
+	".png":  encodePNG,
+	".jpg":  encodeJPEG,
+	".jpeg": encodeJPEG,
+	".ppm":  encodePPM,
+	".bmp":  func(w io.Writer, img image.Image, _ encodeParams) error { return encodeBMP(w, img) },
+}
+
+// checkOutputPath проверяет, что формат файла результата поддерживается.
+func checkOutputPath(path string) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	if _, ok := ldrEncoders[ext]; !ok && !isHDRPath(path) {
+		return fmt.Errorf("unsupported output format %q", ext)
+	}
+	return nil
+}
+
+// writeFile создает файл в sink и записывает в него данные функцией write.
+// Если запись не удалась, файл отменяется (см. aborter), а не закрывается:
+// недописанные данные не сохраняются и не загружаются.
+func writeFile(sink Sink, path string, write func(io.Writer) error) error {
+	file, err := sink.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		if a, ok := file.(aborter); ok {
+			a.Abort()
+		} else {
+			file.Close()
+		}
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return file.Close()
+}
+
+// saveImage сохраняет изображение в формате, выбранном по расширению файла.
+func saveImage(sink Sink, img image.Image, path string, params encodeParams) error {
+	encode := ldrEncoders[strings.ToLower(filepath.Ext(path))]
+	return writeFile(sink, path, func(w io.Writer) error {
+		return encode(w, img, params)
+	})
+}
+
+// encodePNG записывает PNG с метаданными в текстовых блоках.
+func encodePNG(w io.Writer, img image.Image, params encodeParams) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	_, err := w.Write(pngWithText(buf.Bytes(), params.Meta))
+	return err
+}
+
+// encodeJPEG записывает JPEG с метаданными в сегменте комментария.
+func encodeJPEG(w io.Writer, img image.Image, params encodeParams) error {
+	quality := params.Quality
+	if quality <= 0 {
+		quality = jpeg.DefaultQuality
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return err
+	}
+	_, err := w.Write(jpegWithComment(buf.Bytes(), params.Meta))
+	return err
+}
+
+// encodePPM записывает изображение в формате binary PPM (P6), метаданные -
+// комментариями заголовка.
+func encodePPM(w io.Writer, img image.Image, params encodeParams) error {
+	b := img.Bounds()
+	if _, err := fmt.Fprintf(w, "P6\n%s%d %d\n255\n", params.Meta.lines("# ", ": "), b.Dx(), b.Dy()); err != nil {
+		return err
+	}
+	row := make([]byte, 3*b.Dx())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			k := 3 * (x - b.Min.X)
+			row[k], row[k+1], row[k+2] = byte(r>>8), byte(g>>8), byte(bl>>8)
+		}
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeBMP записывает изображение в формате BMP (24 бита, без сжатия).
+func encodeBMP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	stride := (3*width + 3) &^ 3 // Строки выравниваются на 4 байта
+	const headerSize = 14 + 40
+
+	header := []any{
+		// BITMAPFILEHEADER
+		[2]byte{'B', 'M'},
+		uint32(headerSize + stride*height),
+		uint32(0),
+		uint32(headerSize),
+		// BITMAPINFOHEADER
+		uint32(40),
+		int32(width),
+		int32(height), // Положительная высота - строки снизу вверх
+		uint16(1),
+		uint16(24),
+		uint32(0), // BI_RGB
+		uint32(stride * height),
+		int32(2835), // 72 DPI
+		int32(2835),
+		uint32(0),
+		uint32(0),
+	}
+	for _, field := range header {
+		if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+
+	row := make([]byte, stride)
+	for y := b.Max.Y - 1; y >= b.Min.Y; y-- {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			k := 3 * (x - b.Min.X)
+			row[k], row[k+1], row[k+2] = byte(bl>>8), byte(g>>8), byte(r>>8)
+		}
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
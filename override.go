@@ -0,0 +1,30 @@
+package main
+
+import "sort"
+
+// materialOverrides - материалы, которыми -override-material заменяет
+// материалы всех объектов сцены.
+var materialOverrides = map[string]Material{
+	// Матовая светло-серая глина: видны только форма и освещение
+	"clay": {Color: Vec3f{0.8, 0.8, 0.8}, Albedo: 1, SpecularExponent: 10},
+}
+
+// materialOverrideNames возвращает имена материалов для -override-material.
+func materialOverrideNames() []string {
+	names := make([]string, 0, len(materialOverrides))
+	for name := range materialOverrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// overrideMaterial заменяет материалы всех объектов сцены на m. Источники
+// света не меняются, ловцы теней остаются ловцами теней.
+func (s *Scene) overrideMaterial(m Material) {
+	for _, obj := range s.objects {
+		if mat := obj.material(); !mat.ShadowCatcher {
+			*mat = m
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// rouletteDepth - число отражений, после которого путь может быть оборван
+// русской рулеткой.
+const rouletteDepth = 3
+
+// tracePath оценивает цвет луча трассировкой путей (Монте-Карло).
+// В каждой точке с вероятностью Albedo выбирается диффузное отражение
+// в косинусно-взвешенном направлении, иначе - зеркальное, поэтому в среднем
+// результат совпадает со смешиванием компонент в castRay, но дополнительно
+// учитывает непрямое диффузное освещение.
+func tracePath(ray Ray, scene *Scene, depth int, rng *rand.Rand) Vec3f {
+	radiance := Vec3f{0, 0, 0}
+	throughput := Vec3f{1, 1, 1}
+	// Диффузные точки пути, яркость которых запишется в обучающийся кэш
+	var verts []guideVertex
+	if scene.guide.recording() {
+		verts = make([]guideVertex, 0, 8)
+	}
+	// Дальность луча (ray.TMax) ограничена только у зеркальных отражений
+	for bounce := 0; bounce < depth; bounce++ {
+		hit, ok := scene.hit(ray)
+		if scene.hasMedium() {
+			// Среда на пути луча добавляет рассеянный свет и ослабляет остальной
+			dist := math.Inf(1)
+			if ok {
+				dist = hit.T
+			}
+			t, in := scene.medium(ray, dist, rng)
+			radiance = radiance.Add(throughput.Mul(in))
+			throughput = throughput.MulScalar(t)
+		}
+		if !ok {
+			radiance = radiance.Add(throughput.Mul(scene.background(ray.Dir, bounce == 0)))
+			break
+		}
+		if fade := reflectionFade(hit.T, ray.TMax); fade > 0 {
+			radiance = radiance.Add(throughput.Mul(scene.background(ray.Dir, bounce == 0)).MulScalar(fade))
+			throughput = throughput.MulScalar(1 - fade)
+		}
+
+		m := hit.Material
+		if m.ShadowCatcher {
+			radiance = radiance.Add(throughput.Mul(scene.background(ray.Dir, bounce == 0)).MulScalar(scene.shadowRatio(hit.Point, hit.Normal, rng)))
+			break
+		}
+		N := scene.shadingNormal(hit.Object, hit.Point, hit.Normal)
+
+		// Прямое освещение от источников (оценка следующего события)
+		diffuse, specular := scene.illuminate(hit.Point, N, ray.Dir, m, true, rng)
+
+		if m.ggx() {
+			f := m.microfacet(surfaceColor(hit.Object, hit.Point), N, ray.Dir)
+			radiance = radiance.Add(throughput.Mul(f.direct(diffuse, specular)))
+			dir, weight, glossy := f.sample(rng)
+			if weight == (Vec3f{}) {
+				break
+			}
+			throughput = throughput.Mul(weight)
+			if !glossy {
+				ray = scene.spawnRay(hit.Point, N, dir, math.Inf(1))
+			} else if m.ReflectEnvOnly {
+				radiance = radiance.Add(throughput.Mul(scene.background(dir, false)))
+				break
+			} else {
+				ray = scene.spawnRay(hit.Point, N, dir, scene.reflectLimit(m))
+			}
+		} else if radiance = radiance.Add(throughput.Mul(specular)); rng.Float64() < m.Albedo {
+			color := surfaceColor(hit.Object, hit.Point)
+			radiance = radiance.Add(throughput.Mul(color).Mul(diffuse))
+			dir, weight := scene.guide.bounce(hit.Point, N, rng)
+			if weight == 0 {
+				break
+			}
+			ray = scene.spawnRay(hit.Point, N, dir, math.Inf(1))
+			throughput = throughput.Mul(color).MulScalar(weight)
+			if verts != nil {
+				verts = append(verts, guideVertex{cell: scene.guide.cell(hit.Point), bin: guideBin(dir), radiance: radiance, throughput: throughput})
+			}
+		} else if m.ReflectEnvOnly {
+			radiance = radiance.Add(throughput.Mul(scene.background(reflect(ray.Dir, N).Normalize(), false)))
+			break
+		} else {
+			ray = scene.spawnRay(hit.Point, N, reflect(ray.Dir, N).Normalize(), scene.reflectLimit(m))
+		}
+
+		// Русская рулетка: путь с малым вкладом обрывается, выжившие
+		// пути усиливаются, чтобы оценка оставалась несмещенной
+		if bounce >= rouletteDepth {
+			p := math.Min(0.95, math.Max(throughput.X, math.Max(throughput.Y, throughput.Z)))
+			if rng.Float64() >= p {
+				break
+			}
+			throughput = throughput.MulScalar(1 / p)
+		}
+		scene.reachDepth(depth - bounce - 1)
+	}
+	if verts != nil {
+		scene.guide.record(verts, radiance)
+	}
+	return radiance
+}
+
+// cosineSampleHemisphere возвращает случайное направление в полусфере вокруг N
+// с плотностью, пропорциональной косинусу угла с нормалью.
+func cosineSampleHemisphere(N Vec3f, rng *rand.Rand) Vec3f {
+	r := math.Sqrt(rng.Float64())
+	phi := 2 * math.Pi * rng.Float64()
+	x, y := r*math.Cos(phi), r*math.Sin(phi)
+	z := math.Sqrt(math.Max(0, 1-x*x-y*y))
+	t, b := orthonormalBasis(N)
+	return t.MulScalar(x).Add(b.MulScalar(y)).Add(N.MulScalar(z)).Normalize()
+}
+
+// orthonormalBasis строит два единичных вектора, ортогональных N и друг другу.
+func orthonormalBasis(N Vec3f) (Vec3f, Vec3f) {
+	a := Vec3f{1, 0, 0}
+	if math.Abs(N.X) > 0.9 {
+		a = Vec3f{0, 1, 0}
+	}
+	t := a.Subtract(N.MulScalar(a.Dot(N))).Normalize()
+	return t, N.Cross(t)
+}
@@ -0,0 +1,36 @@
+package main
+
+import "sync"
+
+// WorkerPool - набор горутин, выполняющих задания рендера. Один пул можно
+// использовать для нескольких рендеров подряд, не создавая потоки заново.
+type WorkerPool struct {
+	jobs chan func()
+	wg   sync.WaitGroup
+}
+
+// NewWorkerPool запускает пул из workers горутин.
+func NewWorkerPool(workers int) *WorkerPool {
+	p := &WorkerPool{jobs: make(chan func())}
+	for w := 0; w < max(1, workers); w++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+	return p
+}
+
+// Submit передает задание свободной горутине пула, ожидая ее освобождения.
+func (p *WorkerPool) Submit(job func()) {
+	p.jobs <- job
+}
+
+// Close дожидается выполнения переданных заданий и останавливает пул.
+func (p *WorkerPool) Close() {
+	close(p.jobs)
+	p.wg.Wait()
+}
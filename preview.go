@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// previewMaxPasses - после стольких проходов предпросмотр перестает
+// накапливать кадр и ждет изменения настроек.
+const previewMaxPasses = 256
+
+// previewStreamInterval - наименьший промежуток между кадрами /stream.
+const previewStreamInterval = 100 * time.Millisecond
+
+// previewSettings - настройки, которые меняются из браузера во время
+// предпросмотра.
+type previewSettings struct {
+	Exposure    float64   `json:"exposure"`    // Экспозиция в ступенях (EV)
+	FOV         float64   `json:"fov"`         // Вертикальный угол обзора в градусах
+	Intensities []float64 `json:"intensities"` // Интенсивности источников в порядке lightKnobs
+}
+
+// lightKnob - интенсивность источника света, которую можно менять.
+type lightKnob struct {
+	Name  string
+	Value *float64
+}
+
+// lightKnobs возвращает интенсивности всех источников сцены в порядке s.lights.
+func (s *Scene) lightKnobs() []lightKnob {
+	var knobs []lightKnob
+	for i := range s.Lights {
+		knobs = append(knobs, lightKnob{fmt.Sprintf("point %d", i+1), &s.Lights[i].Intensity})
+	}
+	for i := range s.DirectionalLights {
+		knobs = append(knobs, lightKnob{fmt.Sprintf("sun %d", i+1), &s.DirectionalLights[i].Intensity})
+	}
+	for i := range s.AmbientLights {
+		knobs = append(knobs, lightKnob{fmt.Sprintf("ambient %d", i+1), &s.AmbientLights[i].Intensity})
+	}
+	for i := range s.DomeLights {
+		knobs = append(knobs, lightKnob{fmt.Sprintf("dome %d", i+1), &s.DomeLights[i].Intensity})
+	}
+	return knobs
+}
+
+// withSettings возвращает копию сцены с углом обзора и интенсивностями
+// источников из p. Экспозиция применяется при показе кадра, а не к сцене.
+func (s *Scene) withSettings(p previewSettings) *Scene {
+	out := *s
+	out.Camera.FOV = p.FOV
+	out.Lights = slices.Clone(s.Lights)
+	out.DirectionalLights = slices.Clone(s.DirectionalLights)
+	out.AmbientLights = slices.Clone(s.AmbientLights)
+	out.DomeLights = slices.Clone(s.DomeLights)
+	for i, knob := range out.lightKnobs() {
+		*knob.Value = p.Intensities[i]
+	}
+	out.build()
+	return &out
+}
+
+// previewServer - HTTP-сервер предпросмотра: кадр рендерится проходами
+// с разными зернами, проходы усредняются, и изображение в браузере
+// постепенно очищается от шума. Угол обзора и интенсивности источников
+// меняются ползунками страницы и запускают накопление заново; экспозиция
+// применяется к уже накопленному кадру без перерендера. Пока первый проход
+// не готов, показываются его готовые тайлы; /stream передает кадр потоком
+// MJPEG по мере появления тайлов и проходов.
+type previewServer struct {
+	scene *Scene
+	opts  RenderOptions
+
+	mu       sync.Mutex
+	settings previewSettings
+	sum      *Framebuffer       // Сумма готовых проходов
+	partial  *Framebuffer       // Готовые тайлы первого прохода
+	passes   int                // Число проходов в sum
+	gen      int                // Номер набора настроек, растет при каждом перезапуске
+	cancel   context.CancelFunc // Прерывает текущий проход
+	changed  chan struct{}      // Сигнал циклу рендера о новых настройках
+	frame    chan struct{}      // Закрывается, когда показываемый кадр изменился
+}
+
+func newPreviewServer(scene *Scene, opts RenderOptions) *previewServer {
+	settings := previewSettings{Exposure: opts.Exposure, FOV: math.Round(scene.Camera.fovRadians()*180/math.Pi*1e6) / 1e6}
+	for _, knob := range scene.lightKnobs() {
+		settings.Intensities = append(settings.Intensities, *knob.Value)
+	}
+	return &previewServer{
+		scene:    scene,
+		opts:     opts,
+		settings: settings,
+		sum:      NewFramebuffer(frameWidth, frameHeight),
+		partial:  NewFramebuffer(frameWidth, frameHeight),
+		changed:  make(chan struct{}, 1),
+		frame:    make(chan struct{}),
+	}
+}
+
+// route возвращает обработчик, передающий h только запросы к самому пути
+// path с методами methods (HEAD - как GET): на другие пути (путь "/"
+// совпадает со всеми) он отвечает 404, на другие методы - 405. Шаблоны ServeMux с методом
+// ("GET /path") не используются: без go.mod сборка работает в режиме
+// совместимости с Go 1.21, в котором они считаются обычными путями.
+func route(path string, h http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		method := r.Method
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+		if !slices.Contains(methods, method) {
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// servePreview запускает сервер предпросмотра на адресе addr и работает
+// до отмены ctx.
+func servePreview(ctx context.Context, addr string, scene *Scene, opts RenderOptions) error {
+	pool := NewWorkerPool(runtime.NumCPU())
+	defer pool.Close()
+	opts.Pool = pool
+	p := newPreviewServer(scene, opts)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", route("/", p.handlePage, http.MethodGet))
+	mux.HandleFunc("/image.png", route("/image.png", p.handleImage, http.MethodGet))
+	mux.HandleFunc("/stream", route("/stream", p.handleStream, http.MethodGet))
+	mux.HandleFunc("/settings", route("/settings", p.handleSettings, http.MethodGet, http.MethodPost))
+	srv := &http.Server{Addr: addr, Handler: mux, BaseContext: func(net.Listener) context.Context { return ctx }}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.run(ctx)
+	}()
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	err := srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	wg.Wait()
+	return err
+}
+
+// run рендерит проходы, пока не отменен ctx. Новые настройки прерывают
+// текущий проход, и накопление начинается с нуля.
+func (p *previewServer) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		p.mu.Lock()
+		settings, gen, pass := p.settings, p.gen, p.passes
+		passCtx, cancel := context.WithCancel(ctx)
+		p.cancel = cancel
+		p.mu.Unlock()
+
+		if pass >= previewMaxPasses {
+			select {
+			case <-p.changed:
+			case <-ctx.Done():
+			}
+			cancel()
+			continue
+		}
+		fb := p.renderPass(passCtx, p.scene.withSettings(settings), gen, pass)
+		cancel()
+		if fb == nil {
+			continue
+		}
+		p.mu.Lock()
+		if gen == p.gen {
+			for k, c := range fb.Pixels {
+				p.sum.Pixels[k] = p.sum.Pixels[k].Add(c)
+			}
+			p.passes++
+			p.notify()
+		}
+		p.mu.Unlock()
+	}
+}
+
+// renderPass рендерит проход номер pass для настроек номер gen; nil -
+// проход прерван. Тайлы первого прохода сразу попадают в показываемый кадр.
+func (p *previewServer) renderPass(ctx context.Context, scene *Scene, gen, pass int) *Framebuffer {
+	opts := p.opts
+	opts.Seed += uint64(pass)
+	opts.Jitter = true
+	opts.Exposure = 0
+	fb := NewFramebuffer(frameWidth, frameHeight)
+	for t := range RenderTiles(ctx, scene, opts) {
+		for y := 0; y < t.Height; y++ {
+			copy(fb.Pixels[(t.Y+y)*fb.Width+t.X:], t.Pixels[y*t.Width:(y+1)*t.Width])
+		}
+		if pass > 0 {
+			continue
+		}
+		p.mu.Lock()
+		if gen == p.gen {
+			for y := 0; y < t.Height; y++ {
+				copy(p.partial.Pixels[(t.Y+y)*fb.Width+t.X:], t.Pixels[y*t.Width:(y+1)*t.Width])
+			}
+			p.notify()
+		}
+		p.mu.Unlock()
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return fb
+}
+
+// update применяет новые настройки. Если изменилась сцена, а не только
+// экспозиция, накопленный кадр сбрасывается и текущий проход прерывается.
+func (p *previewServer) update(settings previewSettings) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	restart := settings.FOV != p.settings.FOV || !slices.Equal(settings.Intensities, p.settings.Intensities)
+	p.settings = settings
+	p.notify()
+	if !restart {
+		return
+	}
+	p.sum = NewFramebuffer(frameWidth, frameHeight)
+	p.partial = NewFramebuffer(frameWidth, frameHeight)
+	p.passes = 0
+	p.gen++
+	if p.cancel != nil {
+		p.cancel()
+	}
+	select {
+	case p.changed <- struct{}{}:
+	default:
+	}
+}
+
+// notify сообщает потокам /stream об изменении кадра. Вызывается под p.mu.
+func (p *previewServer) notify() {
+	close(p.frame)
+	p.frame = make(chan struct{})
+}
+
+// snapshot возвращает среднее готовых проходов с примененной экспозицией,
+// а пока нет ни одного - готовые тайлы первого прохода. Второй результат
+// закрывается при следующем изменении кадра.
+func (p *previewServer) snapshot() (*Framebuffer, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fb := NewFramebuffer(frameWidth, frameHeight)
+	src, scale := p.partial, math.Exp2(p.settings.Exposure)
+	if p.passes > 0 {
+		src, scale = p.sum, scale/float64(p.passes)
+	}
+	for k, c := range src.Pixels {
+		fb.Pixels[k] = c.MulScalar(scale)
+	}
+	return fb, p.frame
+}
+
+func (p *previewServer) handleImage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	fb, _ := p.snapshot()
+	png.Encode(w, postProcess(fb, p.opts.ToneMap, p.opts.SRGB))
+}
+
+// handleStream передает кадр потоком MJPEG (multipart/x-mixed-replace):
+// новый JPEG отправляется при каждом изменении кадра, но не чаще чем раз
+// в previewStreamInterval.
+func (p *previewServer) handleStream(w http.ResponseWriter, r *http.Request) {
+	const boundary = "frame"
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+boundary)
+	w.Header().Set("Cache-Control", "no-store")
+	rc := http.NewResponseController(w)
+	var buf bytes.Buffer
+	for {
+		fb, changed := p.snapshot()
+		buf.Reset()
+		jpeg.Encode(&buf, postProcess(fb, p.opts.ToneMap, p.opts.SRGB), &jpeg.Options{Quality: 90})
+		fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", boundary, buf.Len())
+		w.Write(buf.Bytes())
+		if _, err := io.WriteString(w, "\r\n"); err != nil {
+			return
+		}
+		if rc.Flush() != nil {
+			return
+		}
+		select {
+		case <-time.After(previewStreamInterval):
+		case <-r.Context().Done():
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// previewStatus - ответ /settings.
+type previewStatus struct {
+	previewSettings
+	Passes int `json:"passes"`
+}
+
+// handleSettings возвращает текущие настройки и число проходов, а POST с
+// полями формы exposure, fov и light0, light1, ... сначала меняет их.
+func (p *previewServer) handleSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		p.mu.Lock()
+		settings := p.settings
+		p.mu.Unlock()
+		settings.Intensities = slices.Clone(settings.Intensities)
+		if err := parsePreviewForm(r, &settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p.update(settings)
+	}
+	p.mu.Lock()
+	status := previewStatus{p.settings, p.passes}
+	p.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// parsePreviewForm переносит в settings поля формы запроса r.
+func parsePreviewForm(r *http.Request, settings *previewSettings) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	field := func(name string, dst *float64, lo, hi float64) error {
+		v := r.PostForm.Get(name)
+		if v == "" {
+			return nil
+		}
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil || x < lo || x > hi {
+			return fmt.Errorf("invalid %s %q", name, v)
+		}
+		*dst = x
+		return nil
+	}
+	if err := field("exposure", &settings.Exposure, -20, 20); err != nil {
+		return err
+	}
+	if err := field("fov", &settings.FOV, 1, 179); err != nil {
+		return err
+	}
+	for i := range settings.Intensities {
+		if err := field(fmt.Sprintf("light%d", i), &settings.Intensities[i], 0, math.MaxFloat64); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *previewServer) handlePage(w http.ResponseWriter, r *http.Request) {
+	type light struct {
+		Name       string
+		Value, Max float64
+	}
+	p.mu.Lock()
+	settings := p.settings
+	p.mu.Unlock()
+	data := struct {
+		Width, Height int
+		Settings      previewSettings
+		Lights        []light
+	}{Width: frameWidth, Height: frameHeight, Settings: settings}
+	for i, knob := range p.scene.lightKnobs() {
+		v := settings.Intensities[i]
+		data.Lights = append(data.Lights, light{knob.Name, v, math.Max(2*math.Max(v, *knob.Value), 1)})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	previewPage.Execute(w, data)
+}
+
+// previewPage - страница предпросмотра: кадр потоком /stream и ползунки
+// настроек. Число готовых проходов опрашивается два раза в секунду.
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>preview</title>
+<style>body{font:14px sans-serif;display:flex;gap:16px}label{display:block;margin:8px 0}input{width:240px}</style>
+</head><body>
+<img src="stream" width="{{.Width}}" height="{{.Height}}">
+<form id="controls">
+<label>exposure <output>{{.Settings.Exposure}}</output><br>
+<input type="range" name="exposure" min="-6" max="6" step="0.1" value="{{.Settings.Exposure}}"></label>
+<label>fov <output>{{.Settings.FOV}}</output><br>
+<input type="range" name="fov" min="5" max="150" step="1" value="{{.Settings.FOV}}"></label>
+{{range $i, $l := .Lights}}<label>{{$l.Name}} <output>{{$l.Value}}</output><br>
+<input type="range" name="light{{$i}}" min="0" max="{{$l.Max}}" step="any" value="{{$l.Value}}"></label>
+{{end}}<p>passes: <span id="passes">0</span></p>
+</form>
+<script>
+const form = document.getElementById("controls");
+form.addEventListener("input", e => {
+	e.target.previousElementSibling.previousElementSibling.value = (+e.target.value).toFixed(2);
+	fetch("settings", {method: "POST", body: new URLSearchParams(new FormData(form))});
+});
+setInterval(() => fetch("settings").then(r => r.json()).then(s => document.getElementById("passes").textContent = s.passes), 500);
+</script>
+</body></html>
+`))
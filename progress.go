@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress получает уведомления о ходе рендера. Update может вызываться
+// из нескольких горутин одновременно.
+type Progress interface {
+	Start(total int)             // total - число единиц работы (строк)
+	Update(done int, rays int64) // done - сколько единиц готово, rays - сколько выпущено лучей
+	Finish()
+}
+
+// barProgress выводит строку прогресса с процентом готовности, скоростью
+// и оценкой оставшегося времени.
+type barProgress struct {
+	w        io.Writer
+	interval time.Duration // Минимальный интервал между обновлениями строки
+
+	mu    sync.Mutex
+	total int
+	start time.Time
+	last  time.Time
+}
+
+// NewBarProgress возвращает Progress, который рисует полосу прогресса в w.
+func NewBarProgress(w io.Writer) Progress {
+	return &barProgress{w: w, interval: 200 * time.Millisecond}
+}
+
+func (p *barProgress) Start(total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total = total
+	p.start = time.Now()
+	p.last = time.Time{}
+}
+
+func (p *barProgress) Update(done int, rays int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if done < p.total && now.Sub(p.last) < p.interval {
+		return
+	}
+	p.last = now
+	p.draw(done, rays, now.Sub(p.start))
+}
+
+func (p *barProgress) Finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintln(p.w)
+}
+
+func (p *barProgress) draw(done int, rays int64, elapsed time.Duration) {
+	const width = 30
+	frac := float64(done) / float64(max(1, p.total))
+	filled := int(frac * width)
+	eta := "--:--"
+	if done > 0 {
+		remaining := time.Duration(float64(elapsed) * (1 - frac) / frac)
+		eta = formatDuration(remaining)
+	}
+	raysPerSec := float64(rays) / max(elapsed.Seconds(), 1e-9)
+	fmt.Fprintf(p.w, "\r[%s%s] %5.1f%%  %s rays/s  ETA %s ",
+		strings.Repeat("#", filled), strings.Repeat(".", width-filled), 100*frac, formatCount(raysPerSec), eta)
+}
+
+// formatDuration форматирует длительность как m:ss.
+func formatDuration(d time.Duration) string {
+	s := int(d.Round(time.Second).Seconds())
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// formatCount форматирует число с суффиксом K/M/G.
+func formatCount(x float64) string {
+	switch {
+	case x >= 1e9:
+		return fmt.Sprintf("%.1fG", x/1e9)
+	case x >= 1e6:
+		return fmt.Sprintf("%.1fM", x/1e6)
+	case x >= 1e3:
+		return fmt.Sprintf("%.1fK", x/1e3)
+	}
+	return fmt.Sprintf("%.0f", x)
+}
@@ -0,0 +1,127 @@
+package main
+
+import "math"
+
+// Решение алгебраических уравнений до четвертой степени в радикалах
+// (по J. Schwarze, "Cubic and Quartic Roots", Graphics Gems I).
+// Коэффициенты передаются по возрастанию степени: c[0] + c[1]x + ...
+
+// polyEps - порог, ниже которого промежуточные величины считаются нулем.
+const polyEps = 1e-12
+
+func polyIsZero(x float64) bool {
+	return math.Abs(x) < polyEps
+}
+
+// solveQuadratic добавляет к roots действительные корни c0 + c1 x + c2 x^2 = 0.
+func solveQuadratic(c0, c1, c2 float64, roots []float64) []float64 {
+	p := c1 / (2 * c2)
+	q := c0 / c2
+	D := p*p - q
+	switch {
+	case polyIsZero(D):
+		return append(roots, -p)
+	case D < 0:
+		return roots
+	}
+	sqrtD := math.Sqrt(D)
+	return append(roots, sqrtD-p, -sqrtD-p)
+}
+
+// solveCubic добавляет к roots действительные корни
+// c0 + c1 x + c2 x^2 + c3 x^3 = 0.
+func solveCubic(c0, c1, c2, c3 float64, roots []float64) []float64 {
+	// Нормальная форма x^3 + A x^2 + B x + C = 0
+	A, B, C := c2/c3, c1/c3, c0/c3
+	// Замена x = y - A/3 убирает квадратичный член: y^3 + 3p y + 2q = 0
+	sqA := A * A
+	p := (-sqA/3 + B) / 3
+	q := (2.0/27*A*sqA - A*B/3 + C) / 2
+	cbP := p * p * p
+	D := q*q + cbP
+
+	n := len(roots)
+	switch {
+	case polyIsZero(D):
+		if polyIsZero(q) {
+			roots = append(roots, 0)
+		} else {
+			u := math.Cbrt(-q)
+			roots = append(roots, 2*u, -u)
+		}
+	case D < 0:
+		// Три действительных корня (тригонометрическая формула)
+		phi := math.Acos(-q/math.Sqrt(-cbP)) / 3
+		t := 2 * math.Sqrt(-p)
+		roots = append(roots, t*math.Cos(phi), -t*math.Cos(phi+math.Pi/3), -t*math.Cos(phi-math.Pi/3))
+	default:
+		sqrtD := math.Sqrt(D)
+		roots = append(roots, math.Cbrt(sqrtD-q)-math.Cbrt(sqrtD+q))
+	}
+	for i := n; i < len(roots); i++ {
+		roots[i] -= A / 3
+	}
+	return roots
+}
+
+// solveQuartic добавляет к roots действительные корни уравнения
+// c[0] + c[1] x + c[2] x^2 + c[3] x^3 + c[4] x^4 = 0 (метод Феррари).
+// Найденные корни уточняются несколькими шагами метода Ньютона.
+func solveQuartic(c [5]float64, roots []float64) []float64 {
+	// Нормальная форма x^4 + A x^3 + B x^2 + C x + D = 0
+	A, B, C, D := c[3]/c[4], c[2]/c[4], c[1]/c[4], c[0]/c[4]
+	// Замена x = y - A/4 убирает кубический член: y^4 + p y^2 + q y + r = 0
+	sqA := A * A
+	p := -3.0/8*sqA + B
+	q := sqA*A/8 - A*B/2 + C
+	r := -3.0/256*sqA*sqA + sqA*B/16 - A*C/4 + D
+
+	n := len(roots)
+	if polyIsZero(r) {
+		// y (y^3 + p y + q) = 0
+		roots = append(roots, 0)
+		roots = solveCubic(q, p, 0, 1, roots)
+	} else {
+		// Резольвента, достаточно одного действительного корня
+		var zbuf [3]float64
+		z := solveCubic(r*p/2-q*q/8, -r, -p/2, 1, zbuf[:0])[0]
+		u := z*z - r
+		v := 2*z - p
+		switch {
+		case polyIsZero(u):
+			u = 0
+		case u > 0:
+			u = math.Sqrt(u)
+		default:
+			return roots
+		}
+		switch {
+		case polyIsZero(v):
+			v = 0
+		case v > 0:
+			v = math.Sqrt(v)
+		default:
+			return roots
+		}
+		if q < 0 {
+			v = -v
+		}
+		roots = solveQuadratic(z-u, v, 1, roots)
+		roots = solveQuadratic(z+u, -v, 1, roots)
+	}
+
+	for i := n; i < len(roots); i++ {
+		x := roots[i] - A/4
+		// Уточнение корня методом Ньютона по исходному многочлену
+		for k := 0; k < 3; k++ {
+			f := (((c[4]*x+c[3])*x+c[2])*x+c[1])*x + c[0]
+			df := ((4*c[4]*x+3*c[3])*x+2*c[2])*x + c[1]
+			if df == 0 {
+				break
+			}
+			x -= f / df
+		}
+		roots[i] = x
+	}
+	return roots
+}
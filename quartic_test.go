@@ -0,0 +1,182 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// polyFromRoots возвращает коэффициенты многочлена scale * (x - r0)(x - r1)...
+// по возрастанию степени и квадратичные множители x^2 + b x + c без
+// действительных корней из pairs.
+func polyFromRoots(scale float64, roots []float64, pairs [][2]float64) []float64 {
+	c := []float64{scale}
+	mul := func(f []float64) {
+		out := make([]float64, len(c)+len(f)-1)
+		for i, a := range c {
+			for j, b := range f {
+				out[i+j] += a * b
+			}
+		}
+		c = out
+	}
+	for _, r := range roots {
+		mul([]float64{-r, 1})
+	}
+	for _, p := range pairs {
+		mul([]float64{p[1], p[0], 1})
+	}
+	return c
+}
+
+// checkRoots сравнивает найденные корни с ожидаемыми без учета порядка;
+// кратный корень может быть найден как один или как несколько близких.
+func checkRoots(t *testing.T, got, want []float64, tol float64) {
+	t.Helper()
+	got, want = slices.Clone(got), slices.Compact(slices.Sorted(slices.Values(want)))
+	slices.Sort(got)
+	near := func(a, b float64) bool { return math.Abs(a-b) <= tol*math.Max(1, math.Abs(b)) }
+	for _, g := range got {
+		if !slices.ContainsFunc(want, func(w float64) bool { return near(g, w) }) {
+			t.Errorf("roots %v, want %v: unexpected %g", got, want, g)
+			return
+		}
+	}
+	for _, w := range want {
+		if !slices.ContainsFunc(got, func(g float64) bool { return near(g, w) }) {
+			t.Errorf("roots %v, want %v: missing %g", got, want, w)
+			return
+		}
+	}
+}
+
+func TestSolveQuadratic(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		roots []float64
+		pairs [][2]float64
+	}{
+		{"two", []float64{-1, 3}, nil},
+		{"double", []float64{2, 2}, nil},
+		{"none", nil, [][2]float64{{0, 1}}},
+		{"zero", []float64{0, 5}, nil},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			p := polyFromRoots(1, c.roots, c.pairs)
+			checkRoots(t, solveQuadratic(p[0], p[1], p[2], nil), c.roots, 1e-9)
+		})
+	}
+}
+
+func TestSolveCubic(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		roots []float64
+		pairs [][2]float64
+	}{
+		{"three", []float64{-2, 0.5, 4}, nil},
+		{"one", []float64{1.5}, [][2]float64{{1, 1}}},
+		{"double", []float64{1, 1, -3}, nil},
+		{"triple", []float64{2, 2, 2}, nil},
+		{"zero", []float64{0, 1, 2}, nil},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			p := polyFromRoots(2, c.roots, c.pairs)
+			checkRoots(t, solveCubic(p[0], p[1], p[2], p[3], nil), c.roots, 1e-6)
+		})
+	}
+}
+
+func TestSolveQuartic(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		scale float64
+		roots []float64
+		pairs [][2]float64
+		tol   float64
+	}{
+		{"four", 1, []float64{-3, -1, 2, 5}, nil, 1e-9},
+		{"two", 1, []float64{-1, 4}, [][2]float64{{2, 5}}, 1e-9},
+		{"none", 1, nil, [][2]float64{{0, 1}, {-2, 3}}, 1e-9},
+		{"zero", 1, []float64{0, 1, 2, 3}, nil, 1e-9},
+		{"scaled", 1e-6, []float64{-0.5, 0.25, 1, 8}, nil, 1e-9},
+		{"spread", 1, []float64{1e-3, 1, 10, 100}, nil, 1e-9},
+		// Кратные корни соответствуют касанию луча с тором: точность
+		// квадратного корня из порога polyEps
+		{"double", 1, []float64{1, 1, 3, 4}, nil, 1e-5},
+		{"double-pair", 1, []float64{-2, -2, 2, 2}, nil, 1e-5},
+		{"quadruple", 1, []float64{1.5, 1.5, 1.5, 1.5}, nil, 1e-3},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			p := polyFromRoots(c.scale, c.roots, c.pairs)
+			got := solveQuartic([5]float64{p[0], p[1], p[2], p[3], p[4]}, nil)
+			checkRoots(t, got, c.roots, c.tol)
+		})
+	}
+}
+
+// TestTorusIntersect проверяет пересечения луча с тором радиусов 1 и 0.25
+// в начале координат (ось тора - Y).
+func TestTorusIntersect(t *testing.T) {
+	torus := &Torus{MajorRadius: 1, MinorRadius: 0.25}
+	for _, c := range []struct {
+		name      string
+		orig, dir Vec3f
+		hit       bool
+		dist      float64
+	}{
+		{"front", Vec3f{0, 0, 5}, Vec3f{0, 0, -1}, true, 3.75},
+		{"side", Vec3f{-5, 0, 0}, Vec3f{1, 0, 0}, true, 3.75},
+		{"far", Vec3f{0, 0, 1e4}, Vec3f{0, 0, -1}, true, 1e4 - 1.25},
+		{"oblique", Vec3f{1, 5, 0}, Vec3f{0, -1, 0}, true, 4.75},
+		{"through hole", Vec3f{0, 5, 0}, Vec3f{0, -1, 0}, false, 0},
+		{"hole edge", Vec3f{0.74, 5, 0}, Vec3f{0, -1, 0}, false, 0},
+		{"outside", Vec3f{1.26, 5, 0}, Vec3f{0, -1, 0}, false, 0},
+		{"above", Vec3f{-5, 0.26, 0}, Vec3f{1, 0, 0}, false, 0},
+		{"away", Vec3f{0, 0, 5}, Vec3f{0, 0, 1}, false, 0},
+		{"tangent", Vec3f{-5, 0.25, 0}, Vec3f{1, 0, 0}, true, 4},
+		{"inside tube", Vec3f{1, 0, 0}, Vec3f{1, 0, 0}, true, 0.25},
+		{"inside tube along ring", Vec3f{1, 0, 0}, Vec3f{0, 0, 1}, true, 0.75},
+		{"inside tube up", Vec3f{1, 0, 0}, Vec3f{0, 1, 0}, true, 0.25},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			hit, dist := torus.RayIntersect(c.orig, c.dir.Normalize())
+			if hit != c.hit || hit && math.Abs(dist-c.dist) > 1e-3*math.Max(1, c.dist) {
+				t.Errorf("hit %v at %g, want %v at %g", hit, dist, c.hit, c.dist)
+			}
+		})
+	}
+}
+
+// TestParseScenePrimitives проверяет, что файл сцены с вырожденными
+// примитивами отвергается так же, как SceneBuilder: на таких размерах
+// решатели пересечений делят на ноль или находят лишние корни.
+func TestParseScenePrimitives(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		scene string
+		ok    bool
+	}{
+		{"cylinder", `{"cylinders": [{"radius": 1, "height": 2}]}`, true},
+		{"cylinder zero radius", `{"cylinders": [{"radius": 0, "height": 2}]}`, false},
+		{"cylinder negative height", `{"cylinders": [{"radius": 1, "height": -2}]}`, false},
+		{"cone", `{"cones": [{"radius": 1, "height": 2}]}`, true},
+		{"cone zero height", `{"cones": [{"radius": 1, "height": 0}]}`, false},
+		{"cone negative radius", `{"cones": [{"radius": -1, "height": 2}]}`, false},
+		{"torus", `{"tori": [{"majorRadius": 1, "minorRadius": 0.25}]}`, true},
+		{"torus minor equals major", `{"tori": [{"majorRadius": 1, "minorRadius": 1}]}`, false},
+		{"torus minor above major", `{"tori": [{"majorRadius": 1, "minorRadius": 2}]}`, false},
+		{"torus zero minor", `{"tori": [{"majorRadius": 1, "minorRadius": 0}]}`, false},
+		{"torus negative radii", `{"tori": [{"majorRadius": -1, "minorRadius": -2}]}`, false},
+		{"plane", `{"planes": [{"normal": {"x": 0, "y": 1, "z": 0}}]}`, true},
+		{"plane zero normal", `{"planes": [{"normal": {"x": 0, "y": 0, "z": 0}}]}`, false},
+		{"sphere zero radius", `{"spheres": [{"radius": 0}]}`, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := parseScene([]byte(c.scene), "scene", "", "")
+			if (err == nil) != c.ok {
+				t.Errorf("error %v, want ok %v", err, c.ok)
+			}
+		})
+	}
+}
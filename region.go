@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Renderer хранит кадр между рендерами, чтобы перерисовывать его по
+// частям: RenderRegion заново рендерит прямоугольник кадра, а остальные
+// пиксели оставляет как есть. После правки материала достаточно
+// перерисовать участок, где виден объект, - так работают интерактивные
+// редакторы. Вспомогательные проходы, шумоподавление, контрольные снимки,
+// направленное сэмплирование, ферма и кэш тайлов не поддерживаются.
+//
+// Методы Renderer нельзя вызывать одновременно из нескольких горутин.
+type Renderer struct {
+	Image *Framebuffer // Кадр; еще не отрендеренные пиксели черные
+	opts  RenderOptions
+	stats *renderStats
+	pr    *pixelRenderer
+}
+
+// NewRenderer возвращает рендерер кадра сцены scene с черным изображением.
+func NewRenderer(scene *Scene, opts RenderOptions) *Renderer {
+	width, height := opts.size()
+	r := &Renderer{Image: NewFramebuffer(width, height), opts: opts, stats: newRenderStats(opts.Depth)}
+	r.SetScene(scene)
+	return r
+}
+
+// SetScene заменяет сцену, например после правки материала. Уже
+// отрендеренные пиксели не меняются до следующего RenderRegion.
+func (r *Renderer) SetScene(scene *Scene) {
+	r.pr = newPixelRenderer(scene.withStats(r.stats), r.opts)
+}
+
+// bounds возвращает прямоугольник всего кадра.
+func (r *Renderer) bounds() image.Rectangle {
+	return image.Rect(0, 0, r.Image.Width, r.Image.Height)
+}
+
+// RenderRegion заново рендерит пиксели кадра в прямоугольнике rect,
+// обрезанном по кадру. Пиксели совпадают с пикселями Render с теми же
+// параметрами. При отмене ctx недорисованные тайлы сохраняют прежние
+// цвета, и возвращается ctx.Err().
+func (r *Renderer) RenderRegion(ctx context.Context, rect image.Rectangle) error {
+	tiles := regionTiles(rect.Intersect(r.bounds()), tileSize)
+	if r.opts.Progress != nil {
+		r.opts.Progress.Start(len(tiles))
+		defer r.opts.Progress.Finish()
+	}
+	pool := r.opts.Pool
+	if pool == nil {
+		pool = NewWorkerPool(runtime.NumCPU())
+		defer pool.Close()
+	}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		done int
+	)
+	for _, t := range tiles {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		pool.Submit(func() {
+			defer wg.Done()
+			if ctx.Err() != nil {
+				return
+			}
+			t.Pixels = make([]Vec3f, t.Width*t.Height)
+			r.pr.isolate(t.area(), r.pr.newSampler(), func(s *pixelSampler) { r.pr.renderRows(&t, 0, t.Height, s) })
+			// Тайлы не пересекаются, поэтому пиксели копируются без блокировки
+			for y := 0; y < t.Height; y++ {
+				copy(r.Image.Pixels[(t.Y+y)*r.Image.Width+t.X:], t.Pixels[y*t.Width:(y+1)*t.Width])
+			}
+			if r.opts.Progress != nil {
+				mu.Lock()
+				done++
+				r.opts.Progress.Update(done, r.stats.rays.Load())
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// renderRegion рендерит только прямоугольник кадра opts.Region; его и
+// возвращает как изображение результата.
+func renderRegion(ctx context.Context, scene *Scene, opts RenderOptions) (*RenderResult, error) {
+	start := time.Now()
+	r := NewRenderer(scene, opts)
+	err := r.RenderRegion(ctx, opts.Region)
+	st := r.stats.snapshot()
+	img := r.Image.crop(opts.Region.Intersect(r.bounds()))
+	return &RenderResult{Image: img, Rays: st.Rays, Duration: time.Since(start), Stats: st}, err
+}
+
+// regionTiles делит прямоугольник rect на тайлы со стороной size (без
+// пикселей), выровненные по его левому верхнему углу.
+func regionTiles(rect image.Rectangle, size int) []Tile {
+	var rects []Tile
+	for y := rect.Min.Y; y < rect.Max.Y; y += size {
+		for x := rect.Min.X; x < rect.Max.X; x += size {
+			rects = append(rects, Tile{X: x, Y: y, Width: min(size, rect.Max.X-x), Height: min(size, rect.Max.Y-y)})
+		}
+	}
+	return rects
+}
+
+// parseRegion разбирает прямоугольник кадра в формате x,y,w,h (левый
+// верхний пиксель, ширина и высота) и проверяет, что он целиком лежит в
+// кадре width x height.
+func parseRegion(s string, width, height int) (image.Rectangle, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 4 {
+		return image.Rectangle{}, fmt.Errorf("region %q: want x,y,w,h", s)
+	}
+	var v [4]int
+	for i, f := range fields {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return image.Rectangle{}, fmt.Errorf("region %q: %w", s, err)
+		}
+		v[i] = n
+	}
+	rect := image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3])
+	if v[2] <= 0 || v[3] <= 0 || !rect.In(image.Rect(0, 0, width, height)) {
+		return image.Rectangle{}, fmt.Errorf("region %q: must be a non-empty rectangle inside the %dx%d frame", s, width, height)
+	}
+	return rect, nil
+}
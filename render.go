@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RenderOptions - параметры рендера.
+type RenderOptions struct {
+	Depth      int      // Глубина рекурсии (максимальное число отражений)
+	Samples    int      // Число сэмплов на пиксель (при адаптивном сэмплировании - наименьшее)
+	Integrator string   // Один из integratorNames
+	AOVs       []string // Вспомогательные проходы, сохраняемые рядом с основным изображением
+	ToneMap    string   // Оператор тональной компрессии (см. toneMappers)
+	SRGB       bool     // Гамма-коррекция sRGB при сохранении
+	Output     string   // Файл результата; формат определяется расширением
+	Sink       Sink     // Куда сохраняются файлы результата (nil - на диск)
+	Wireframe  bool     // Наложение каркаса примитивов для отладки
+	// Качество JPEG (1-100, 0 - по умолчанию)
+	JPEGQuality int
+	// Получатель уведомлений о ходе рендера (nil - без уведомлений)
+	Progress Progress
+	// Пул горутин для рендера (nil - временный пул на время рендера)
+	Pool *WorkerPool
+	// Зерно генератора случайных чисел: при одинаковых сцене, параметрах и
+	// зерне стохастические эффекты (сглаживание, мягкие тени, трассировка
+	// путей) дают побитово одинаковое изображение
+	Seed uint64
+	// Экспозиция в ступенях (EV): цвет каждого пикселя умножается на 2^Exposure
+	Exposure float64
+	// Размер кадра в пикселях (0 - frameWidth x frameHeight)
+	Width, Height int
+	// Сдвигать случайно и единственный сэмпл пикселя: так кадры с разными
+	// зернами можно усреднять, накапливая сглаживание (см. previewServer)
+	Jitter bool
+	// Адаптивное сэмплирование (см. adaptiveRect): пиксель получает от
+	// Samples до MaxSamples сэмплов, чтобы относительная погрешность его
+	// среднего не превышала Noise (MaxSamples <= Samples - у всех пикселей
+	// ровно Samples сэмплов)
+	MaxSamples int
+	Noise      float64
+	// Подавление шума после рендера (см. denoise)
+	Denoise bool
+	// Адреса воркеров фермы (host:port): кадр рендерится на них, см. renderFarm
+	Farm []string
+	// Тайлы предыдущего кадра анимации (nil - без кэша), см. FrameCache.
+	// Со вспомогательными проходами и шумоподавлением не используется
+	Cache *FrameCache
+	// Рендерить стадиями по числу сэмплов и после стадий, завершающих 1%,
+	// 2%, 4%... сэмплов, сохранять рядом с Output контрольные снимки (см.
+	// progressive). С адаптивным сэмплированием и фермой не используется
+	Checkpoints bool
+	// Направленное сэмплирование для интегратора path: первые сэмплы
+	// пикселей (у адаптивного рендера - основные) учат кэш яркости, а
+	// следующие выбирают по нему направления диффузных отскоков (см.
+	// radianceCache). С фермой и кэшем тайлов не используется
+	Guide bool
+	// Рендерить только этот прямоугольник кадра (пустой - весь кадр): он и
+	// становится изображением результата, см. Renderer. Поддерживается то
+	// же, что и в Renderer
+	Region image.Rectangle
+	// Отладочные проходы источников света: вместе с кадром рендерится по
+	// кадру на каждый источник, в котором горит только он, и они
+	// сохраняются рядом с Output (см. LightPass). С фермой, кэшем тайлов и
+	// Region не используются
+	LightPasses bool
+	// Путь к файлу сцены; только для метаданных результата
+	Scene string
+}
+
+// Integrator вычисляет цвет луча. depth - максимальная глубина рекурсии,
+// rng - генератор случайных чисел текущего потока рендера.
+type Integrator func(ray Ray, scene *Scene, depth int, rng *rand.Rand) Vec3f
+
+// newIntegrator возвращает интегратор по имени. pixelAngle - угловой
+// размер пикселя, нужный отладочному интегратору mip.
+func newIntegrator(name string, pixelAngle float64) Integrator {
+	switch name {
+	case "path":
+		return tracePath
+	case "uv":
+		return traceUV
+	case "checker":
+		return traceChecker
+	case "mip":
+		return mipLevelTracer(pixelAngle)
+	}
+	return castRay
+}
+
+// Размер кадра в пикселях по умолчанию.
+const frameWidth, frameHeight = 1024, 768
+
+// size возвращает размер кадра.
+func (o RenderOptions) size() (width, height int) {
+	if o.Width <= 0 || o.Height <= 0 {
+		return frameWidth, frameHeight
+	}
+	return o.Width, o.Height
+}
+
+// pixelRenderer вычисляет цвета пикселей кадра; общий для Render и RenderTiles.
+type pixelRenderer struct {
+	scene      *Scene
+	opts       RenderOptions
+	trace      Integrator
+	samples    int // Число основных сэмплов пикселя
+	maxSamples int // Наибольшее число сэмплов пикселя (см. adaptiveRect)
+	exposure   float64
+	tanHalf    float64 // Тангенс половины вертикального угла обзора
+	project    projection
+	width      int // Размер кадра
+	height     int
+}
+
+func newPixelRenderer(scene *Scene, opts RenderOptions) *pixelRenderer {
+	fov := scene.Camera.fovRadians() // Поле зрения
+	width, height := opts.size()
+	samples := max(1, opts.Samples)
+	maxSamples := max(samples, opts.MaxSamples)
+	if maxSamples > samples {
+		samples = min(max(samples, adaptiveMinSamples), maxSamples)
+	}
+	return &pixelRenderer{
+		scene:      scene,
+		opts:       opts,
+		trace:      newIntegrator(opts.Integrator, 2*math.Tan(fov/2)/float64(height)),
+		samples:    samples,
+		maxSamples: maxSamples,
+		exposure:   math.Exp2(opts.Exposure),
+		tanHalf:    math.Tan(fov / 2),
+		project:    scene.Camera.cameraProjection(),
+		width:      width,
+		height:     height,
+	}
+}
+
+// cameraRay возвращает первичный луч камеры-обскуры через точку (dx, dy)
+// пикселя (i, j) в проекции камеры и false, если точка лежит вне
+// изображения проекции: такие сэмплы черные.
+func (p *pixelRenderer) cameraRay(i, j int, dx, dy float64) (Ray, bool) {
+	w, h := float64(p.width), float64(p.height)
+	x := 2*(float64(i)+dx)/w - 1
+	y := -(2*(float64(j)+dy)/h - 1)
+	cam := &p.scene.Camera
+	orig, dir, ok := p.project(p, x, y)
+	return newRay(cam.Position.Add(cam.orient(orig)), cam.orient(dir)), ok
+}
+
+// pixelSampler - состояние одной горутины рендера: генератор случайных
+// чисел rng с источником pcg и копия сцены, отмечающая в probe, куда
+// дошли лучи.
+type pixelSampler struct {
+	pcg   *rand.PCG
+	rng   *rand.Rand
+	scene *Scene
+	probe rayProbe
+	time  float64 // Момент выдержки текущего сэмпла (см. Scene.withShutter)
+}
+
+// newSampler возвращает состояние для новой горутины рендера.
+func (p *pixelRenderer) newSampler() *pixelSampler {
+	s := &pixelSampler{pcg: rand.NewPCG(0, 0)}
+	s.rng = rand.New(s.pcg)
+	s.scene = p.scene.withProbe(&s.probe)
+	if s.scene.moving {
+		s.scene = s.scene.withShutter(&s.time)
+	}
+	return s
+}
+
+// pixelSamples - накопленные сэмплы пикселя.
+type pixelSamples struct {
+	sum   Vec3f       // Сумма цветов сэмплов
+	noise sampleNoise // Число сэмплов и разброс их яркости
+	level int         // Наибольшая глубина рекурсии, до которой дошли лучи
+}
+
+// pixel возвращает цвет пикселя (i, j). У каждого пикселя свой поток
+// случайных чисел, поэтому результат не зависит ни от порядка, в котором
+// горутины берут работу, ни от того, какая часть кадра рендерится.
+func (p *pixelRenderer) pixel(i, j int, ps *pixelSampler) Vec3f {
+	var s pixelSamples
+	p.sample(&s, i, j, p.samples, 0, ps)
+	return p.finish(&s, i, j, ps)
+}
+
+// sample добавляет к s count сэмплов пикселя (i, j) из потока случайных
+// чисел номер stream: 0 - основные сэмплы, 1 - добавочные сэмплы
+// адаптивного сэмплирования.
+func (p *pixelRenderer) sample(s *pixelSamples, i, j, count int, stream uint64, ps *pixelSampler) {
+	ps.pcg.Seed(p.opts.Seed, uint64(j*p.width+i)|stream<<63)
+	p.addSamples(s, i, j, count, ps)
+}
+
+// addSamples добавляет к s count сэмплов пикселя (i, j), продолжая текущий
+// поток случайных чисел ps.
+func (p *pixelRenderer) addSamples(s *pixelSamples, i, j, count int, ps *pixelSampler) {
+	ps.probe.min = p.opts.Depth
+	rng := ps.rng
+	for k := 0; k < count; k++ {
+		// Единственный сэмпл берется в центре пикселя, остальные - со случайным сдвигом
+		dx, dy := 0.5, 0.5
+		if p.maxSamples > 1 || p.opts.Jitter {
+			dx, dy = rng.Float64(), rng.Float64()
+		}
+		// Движение за выдержку: все лучи сэмпла видят сцену в один случайный момент
+		if p.scene.hasMotion() {
+			ps.time = rng.Float64()
+		}
+		ray, ok := p.cameraRay(i, j, dx, dy)
+		var c Vec3f
+		if ok {
+			ray = p.scene.Camera.lensRay(ray, rng)
+			if m := p.scene.Camera.Motion; m != nil {
+				ray.Origin = ray.Origin.Add(m.MulScalar(ps.time))
+			}
+			c = p.trace(ray, ps.scene, p.opts.Depth, rng)
+		}
+		s.sum = s.sum.Add(c)
+		s.noise.add(luminance(c))
+	}
+	s.level = max(s.level, p.opts.Depth-ps.probe.min)
+}
+
+// finish возвращает итоговый цвет пикселя (i, j) по его сэмплам s.
+func (p *pixelRenderer) finish(s *pixelSamples, i, j int, ps *pixelSampler) Vec3f {
+	if p.scene.stats != nil {
+		p.scene.stats.countDepth(s.level)
+		p.scene.stats.samples.Add(int64(s.noise.n))
+	}
+	col := s.sum.MulScalar(p.exposure / float64(s.noise.n))
+	if p.opts.Wireframe {
+		if ray, ok := p.cameraRay(i, j, 0.5, 0.5); ok && wireframeEdge(ps.scene, ray) {
+			col = wireColor
+		}
+	}
+	return col
+}
+
+// RenderResult - результат рендера: основное изображение и данные для
+// вспомогательных проходов (nil, если не запрошены ни проходы, ни
+// шумоподавление).
+type RenderResult struct {
+	Image    *Framebuffer
+	AOV      *AOVBuffers
+	Lights   []LightPass     // Проходы источников (RenderOptions.LightPasses)
+	Rays     int64           // Число выпущенных лучей
+	Duration time.Duration   // Время рендера
+	RowTimes []time.Duration // Время рендера каждой строки (nil - не измерялось)
+	Stats    RenderStats     // Подробные счетчики лучей и пересечений
+	Reused   int             // Тайлы, взятые из FrameCache без рендера
+}
+
+// Render генерирует изображение сцены. При отмене ctx рендер прекращается
+// после текущих строк, и возвращается частично готовое изображение вместе
+// с ctx.Err(): недорисованные строки остаются черными.
+func Render(ctx context.Context, scene *Scene, opts RenderOptions) (*RenderResult, error) {
+	if !opts.Region.Empty() {
+		return renderRegion(ctx, scene, opts)
+	}
+	if len(opts.Farm) > 0 {
+		return renderFarm(ctx, scene, opts)
+	}
+	if opts.Cache != nil && len(opts.AOVs) == 0 && !opts.Denoise && !opts.LightPasses {
+		return renderCached(ctx, scene, opts)
+	}
+	width, height := opts.size()
+	fb := NewFramebuffer(width, height)
+	var aov *AOVBuffers
+	if len(opts.AOVs) > 0 || opts.Denoise {
+		aov = newAOVBuffers(width, height, scene.objects)
+	}
+
+	// Счетчики подключаются к копии сцены, чтобы параллельные рендеры
+	// одной сцены не смешивали статистику
+	stats := newRenderStats(opts.Depth)
+	scene = scene.withStats(stats)
+	if opts.Guide && opts.Integrator == "path" {
+		scene = scene.withGuide(newRadianceCache(scene))
+	}
+	pr := newPixelRenderer(scene, opts)
+	var lights []LightPass
+	var lightRenderers []*pixelRenderer
+	if opts.LightPasses {
+		lights, lightRenderers = newLightPasses(scene, opts, width, height)
+	}
+	// Адаптивный рендер проходит по строкам дважды, см. adaptiveRect, а
+	// рендер с контрольными снимками или направленным сэмплированием - по
+	// разу на стадию, см. progressive. Кэш яркости учится до конца прохода
+	// learnPass: у адаптивного рендера - на основных сэмплах
+	var adapt *adaptiveRect
+	var prog *progressive
+	passes, learnPass := 1, -1
+	if pr.adaptive() {
+		adapt = pr.newAdaptiveRect(&Tile{Width: width, Height: height})
+		passes, learnPass = 2, 0
+	} else if opts.Checkpoints || scene.guide != nil {
+		if prog = pr.newProgressive(); prog != nil {
+			passes, learnPass = len(prog.counts), prog.learn
+		}
+	}
+	var rowsDone atomic.Int64
+	if opts.Progress != nil {
+		opts.Progress.Start(passes * height)
+		defer opts.Progress.Finish()
+	}
+
+	pool := opts.Pool
+	if pool == nil {
+		pool = NewWorkerPool(runtime.NumCPU())
+		defer pool.Close()
+	}
+
+	// Строки изображения распределяются между горутинами пула
+	start := time.Now()
+	rowTimes := make([]time.Duration, height)
+	var wg sync.WaitGroup
+	for pass := 0; pass < passes && ctx.Err() == nil; pass++ {
+		for j := 0; j < height; j++ {
+			wg.Add(1)
+			pool.Submit(func() {
+				defer wg.Done()
+				if ctx.Err() != nil {
+					return
+				}
+				rowStart := time.Now()
+				pr.isolate(fmt.Sprintf("row %d", j), pr.newSampler(), func(s *pixelSampler) {
+					switch {
+					case prog != nil:
+						prog.sampleRow(pr, j, pass, fb, s)
+					case adapt == nil:
+						for i := 0; i < width; i++ {
+							fb.Set(i, j, pr.pixel(i, j, s))
+						}
+					case pass == 0:
+						adapt.sampleRow(pr, j, s)
+					default:
+						for i := 0; i < width; i++ {
+							fb.Set(i, j, adapt.refinePixel(pr, i, j, s))
+						}
+					}
+				})
+				if aov != nil && pass == passes-1 {
+					// Первичные лучи строки пересекаются со сценой одной пачкой
+					rays := make([]Ray, width)
+					for i := range rays {
+						// Луч вне изображения проекции (TMax = 0) ни во что не попадает
+						if ray, ok := pr.cameraRay(i, j, 0.5, 0.5); ok {
+							rays[i] = ray
+						}
+					}
+					aov.recordRow(j, rays, scene.IntersectMany(rays))
+				}
+				if pass == passes-1 {
+					// Проходы источников считаются основными сэмплами пикселей
+					// вместе с последним проходом кадра
+					for k, lp := range lightRenderers {
+						lp.isolate(fmt.Sprintf("row %d, %s", j, lights[k].Name), lp.newSampler(), func(s *pixelSampler) {
+							for i := 0; i < width; i++ {
+								lights[k].Image.Set(i, j, lp.pixel(i, j, s))
+							}
+						})
+					}
+				}
+				rowTimes[j] += time.Since(rowStart)
+				done := rowsDone.Add(1)
+				if opts.Progress != nil {
+					opts.Progress.Update(int(done), stats.rays.Load())
+				}
+			})
+		}
+		wg.Wait()
+		if ctx.Err() != nil {
+			break
+		}
+		if prog != nil {
+			prog.saveCheckpoint(pr, pass)
+		}
+		if pass == learnPass && scene.guide != nil {
+			scene.guide.freeze()
+		}
+	}
+	if prog != nil && ctx.Err() != nil {
+		// Прерванный рендер сохраняет все сэмплы, накопленные пикселями
+		fb = prog.snapshot(pr)
+	}
+	if opts.Denoise && ctx.Err() == nil {
+		fb = denoise(fb, aov)
+	}
+
+	st := stats.snapshot()
+	res := &RenderResult{Image: fb, AOV: aov, Lights: lights, Rays: st.Rays, Duration: time.Since(start), RowTimes: rowTimes, Stats: st}
+	return res, ctx.Err()
+}
+
+// save сохраняет результат в файл opts.Output, а вспомогательные проходы и
+// проходы источников - в файлы с суффиксами имен проходов. В заголовок каждого файла, если формат
+// это допускает, записываются метаданные рендера.
+func (r *RenderResult) save(opts RenderOptions) error {
+	// HDR-форматы получают буфер без постобработки
+	hdr := isHDRPath(opts.Output)
+	sink := opts.Sink
+	if sink == nil {
+		sink = FileSink{}
+	}
+	meta := r.metadata(opts, "beauty", r.Image)
+	var err error
+	if hdr {
+		err = saveHDR(sink, r.Image, opts.Output, meta)
+	} else {
+		err = saveImage(sink, postProcess(r.Image, opts.ToneMap, opts.SRGB), opts.Output, encodeParams{opts.JPEGQuality, meta})
+	}
+	if err != nil {
+		return err
+	}
+	for _, name := range opts.AOVs {
+		fb := r.AOV.framebuffer(name)
+		meta := r.metadata(opts, name, fb)
+		if hdr {
+			err = saveHDR(sink, fb, aovPath(opts.Output, name), meta)
+		} else {
+			err = saveImage(sink, r.AOV.image(name), aovPath(opts.Output, name), encodeParams{opts.JPEGQuality, meta})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, lp := range r.Lights {
+		meta := r.metadata(opts, "light "+lp.Name, lp.Image)
+		path := lightPassPath(opts.Output, lp.Name)
+		if hdr {
+			err = saveHDR(sink, lp.Image, path, meta)
+		} else {
+			err = saveImage(sink, postProcess(lp.Image, opts.ToneMap, opts.SRGB), path, encodeParams{opts.JPEGQuality, meta})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// render рендерит сцену и сохраняет результат, предупреждая о сорвавшихся
+// участках кадра. Прерванный рендер тоже сохраняется, а ошибка отмены
+// возвращается вызывающему.
+func render(ctx context.Context, scene *Scene, opts RenderOptions) (*RenderResult, error) {
+	res, err := Render(ctx, scene, opts)
+	if res != nil {
+		printFailures(os.Stderr, res)
+		if saveErr := res.save(opts); saveErr != nil {
+			return res, saveErr
+		}
+	}
+	return res, err
+}
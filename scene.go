@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Scene описывает сцену: объекты, источники света и фон.
+type Scene struct {
+	Spheres   []Sphere     `json:"spheres"`
+	Cylinders []Cylinder   `json:"cylinders,omitempty"`
+	Cones     []Cone       `json:"cones,omitempty"`
+	Tori      []Torus      `json:"tori,omitempty"`
+	Planes    []Plane      `json:"planes,omitempty"`
+	Instances []Instance   `json:"instances,omitempty"`
+	Lights    []PointLight `json:"lights"`
+	// Россыпь сфер, добавляемых при загрузке в конец Spheres (номера
+	// объектов в Instances считаются уже с ними)
+	Drop *SphereDrop `json:"drop,omitempty"`
+	// Солнечные, рассеянные источники и купол неба
+	DirectionalLights []DirectionalLight `json:"directionalLights,omitempty"`
+	AmbientLights     []AmbientLight     `json:"ambientLights,omitempty"`
+	DomeLights        []DomeLight        `json:"domeLights,omitempty"`
+	// Нормировать интенсивности источников на их сумму (см. illuminate)
+	NormalizeLights bool `json:"normalizeLights,omitempty"`
+
+	Camera     Camera `json:"camera"`
+	Background Vec3f  `json:"background"`
+	EnvMap     string `json:"envmap,omitempty"` // Путь к equirectangular-карте окружения
+	// Фон, который видят только лучи камеры или только вторичные лучи
+	// (отражения, отскоки трассировки путей), вместо Background и EnvMap.
+	// nil - без замены
+	CameraBackground    *Vec3f `json:"cameraBackground,omitempty"`
+	SecondaryBackground *Vec3f `json:"secondaryBackground,omitempty"`
+	// Дальность отражений для материалов, у которых она не задана (0 - без ограничения)
+	MaxReflectDistance float64 `json:"maxReflectDistance,omitempty"`
+	// Туман и объемы рассеивающей среды
+	Fog     *Fog     `json:"fog,omitempty"`
+	Volumes []Volume `json:"volumes,omitempty"`
+
+	// Отступ лучей от поверхности; nil - постоянный 1e-3 (см. EpsilonPolicy)
+	Epsilon *EpsilonPolicy `json:"epsilon,omitempty"`
+
+	objects []Hittable     // Все примитивы сцены, см. build
+	boxes   []AABB         // Ограничивающие параллелепипеды objects
+	packed  *packedSpheres // Сферы для ядра "packed" (nil - ядро "portable")
+	rest    []int          // Номера объектов, не вошедших в packed
+	lights  []Light        // Все источники света, см. build
+	envMap  *EnvMap
+	stats   *renderStats
+	probe   *rayProbe
+	guide   *radianceCache // Кэш яркости направленного сэмплирования, см. withGuide
+	brute   bool           // Перебирать все объекты без ускорений, см. withBruteForce
+	moving  bool           // Среди objects есть движущиеся (Moving)
+	epsilon rayEpsilon     // Отступ лучей от поверхности, см. build
+	solo    Light          // Единственный горящий источник (nil - все), см. withSoloLight
+}
+
+// defaultScene возвращает сцену, которая рендерится без файла сцены.
+func defaultScene() *Scene {
+	scene := &Scene{
+		// Источники света
+		Lights: []PointLight{
+			*NewPointLight(Vec3f{X: 1.0, Y: 2.0, Z: 3.0}, 1.4),
+			*NewPointLight(Vec3f{X: 3.0, Y: -2.0, Z: -3.0}, 1.0),
+		},
+		// Несколько сфер
+		Spheres: []Sphere{
+			{Center: Vec3f{X: 2.1, Y: 0, Z: -3}, Radius: 0.8, Material: Material{Color: Vec3f{X: 0.4, Y: 0.4, Z: 0.3}, Albedo: 0.25, SpecularExponent: 50}},
+			{Center: Vec3f{X: 4, Y: 4, Z: -10}, Radius: 1.5, Material: Material{Color: Vec3f{X: 0.7, Y: 0.3, Z: 0.5}, Albedo: 0.5, SpecularExponent: 50}},
+			{Center: Vec3f{X: 2, Y: -2.5, Z: -5}, Radius: 1.2, Material: Material{Color: Vec3f{X: 0.3, Y: 0.6, Z: 0.7}, Albedo: 0.5, SpecularExponent: 50}},
+			{Center: Vec3f{X: -2, Y: 0, Z: -10}, Radius: 4.2, Material: Material{Color: Vec3f{X: 0.3, Y: 0.1, Z: 0.9}, Albedo: 0.5, SpecularExponent: 50}},
+		},
+		Background: Vec3f{0.2, 0.7, 0.8},
+	}
+	scene.build()
+	return scene
+}
+
+// LoadScene читает сцену из JSON-файла или снимка сцены (см.
+// WriteSnapshot). Относительные пути внутри файла считаются от каталога,
+// в котором лежит сцена.
+func LoadScene(path string) (*Scene, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if isSnapshot(data) {
+		scene, err := ReadSnapshot(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return scene, nil
+	}
+	return parseScene(data, path, filepath.Dir(path), "")
+}
+
+// parseScene разбирает сцену из JSON data; path - имя сцены в сообщениях
+// об ошибках, dir - каталог, от которого считаются пути к ресурсам. При
+// пустом dir сцена не может ссылаться на файлы, при непустом root - на
+// файлы вне каталога root.
+func parseScene(data []byte, path, dir, root string) (*Scene, error) {
+	var err error
+	scene := &Scene{Background: Vec3f{0.2, 0.7, 0.8}}
+	if err := json.Unmarshal(data, scene); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if d := scene.Drop; d != nil {
+		if d.Count < 0 || d.MinRadius <= 0 || d.MaxRadius < d.MinRadius {
+			return nil, fmt.Errorf("%s: drop: need count >= 0 and 0 < minRadius <= maxRadius", path)
+		}
+		scene.Spheres = append(scene.Spheres, dropSpheres(d, scene.Spheres)...)
+	}
+	scene.sortKeys()
+	if err := scene.check(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	scene.build()
+	if dir == "" {
+		if err := scene.checkNoFiles(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if root != "" {
+		if err := scene.checkFilesUnder(dir, root); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if scene.EnvMap != "" {
+		scene.envMap, err = LoadEnvMap(resolvePath(dir, scene.EnvMap))
+		if err != nil {
+			return nil, fmt.Errorf("%s: envmap: %w", path, err)
+		}
+	}
+	primitives := scene.primitiveCount()
+	// Повторы разделяют материал с исходным объектом, а объекты с одной
+	// текстурой - ее копию в памяти
+	textures := map[string]*Texture{}
+	for i, obj := range scene.objects[:primitives] {
+		if err := obj.material().load(dir, textures); err != nil {
+			return nil, fmt.Errorf("%s: object %d: %w", path, i, err)
+		}
+	}
+	return scene, nil
+}
+
+// check проверяет параметры сцены, которые не зависят от файлов ресурсов:
+// камеру, отступ лучей, ключевые кадры, размеры примитивов (те же
+// проверки, что в SceneBuilder), ссылки повторов и источники.
+// Сцена из файла и из снимка проверяется до сборки (см. build).
+func (s *Scene) check() error {
+	if s.Camera.Shutter < 0 {
+		return errors.New("camera: negative shutter")
+	}
+	if err := s.Camera.checkProjection(); err != nil {
+		return fmt.Errorf("camera: %w", err)
+	}
+	if s.Epsilon != nil {
+		if err := s.Epsilon.check(); err != nil {
+			return fmt.Errorf("epsilon: %w", err)
+		}
+	}
+	if err := s.checkKeys(); err != nil {
+		return fmt.Errorf("animation: %w", err)
+	}
+	for i := range s.Spheres {
+		if err := s.Spheres[i].checkShape(); err != nil {
+			return fmt.Errorf("sphere %d: %w", i, err)
+		}
+	}
+	for i := range s.Cylinders {
+		if err := s.Cylinders[i].checkShape(); err != nil {
+			return fmt.Errorf("cylinder %d: %w", i, err)
+		}
+	}
+	for i := range s.Cones {
+		if err := s.Cones[i].checkShape(); err != nil {
+			return fmt.Errorf("cone %d: %w", i, err)
+		}
+	}
+	for i := range s.Tori {
+		if err := s.Tori[i].checkShape(); err != nil {
+			return fmt.Errorf("torus %d: %w", i, err)
+		}
+	}
+	for i := range s.Planes {
+		if err := s.Planes[i].checkShape(); err != nil {
+			return fmt.Errorf("plane %d: %w", i, err)
+		}
+	}
+	primitives := s.primitiveCount()
+	for i, inst := range s.Instances {
+		if inst.Object < 0 || inst.Object >= primitives {
+			return fmt.Errorf("instance %d: no object %d", i, inst.Object)
+		}
+	}
+	for i, light := range s.Lights {
+		switch light.Shape {
+		case "", "point", "sphere", "rect":
+		default:
+			return fmt.Errorf("light %d: unknown shape %q", i, light.Shape)
+		}
+		if err := light.checkFalloff(); err != nil {
+			return fmt.Errorf("light %d: %w", i, err)
+		}
+	}
+	for i, light := range s.DirectionalLights {
+		if light.Direction.Length2() == 0 {
+			return fmt.Errorf("directional light %d: zero direction", i)
+		}
+	}
+	return nil
+}
+
+// check проверяет параметры материала, не связанные с файлами текстур.
+func (m *Material) check() error {
+	if _, ok := uvProjections[m.UVMapping]; m.UVMapping != "" && !ok {
+		return fmt.Errorf("unknown uvMapping %q (want one of %s)", m.UVMapping, strings.Join(uvProjectionNames(), ", "))
+	}
+	return m.checkBRDF()
+}
+
+// load загружает ресурсы материала (текстуры и карты нормалей). Уже
+// загруженные текстуры берутся из cache по пути к файлу.
+func (m *Material) load(dir string, cache map[string]*Texture) error {
+	if err := m.check(); err != nil {
+		return err
+	}
+	load := func(name string) (*Texture, error) {
+		path := resolvePath(dir, name)
+		if tex := cache[path]; tex != nil {
+			return tex, nil
+		}
+		tex, err := LoadTexture(path)
+		if err == nil {
+			cache[path] = tex
+		}
+		return tex, err
+	}
+	var err error
+	if m.Texture != "" {
+		if m.texture, err = load(m.Texture); err != nil {
+			return err
+		}
+	}
+	if m.NormalMap != "" {
+		if m.normalMap, err = load(m.NormalMap); err != nil {
+			return fmt.Errorf("normal map: %w", err)
+		}
+	}
+	return nil
+}
+
+// build собирает указатели на примитивы всех типов в общий список objects,
+// оборачивая преобразованием те, у которых оно задано, и движением за
+// выдержку (повторы из Instances добавляются в конец), запоминает их параллелепипеды в boxes, упаковывает
+// сферы для выбранного ядра пересечений, а источники света всех типов
+// собирает в список lights.
+// Вызывается после любого изменения списков примитивов или источников.
+func (s *Scene) build() {
+	s.objects = s.objects[:0:0]
+	s.moving = false
+	// Примитивы без движения: повторы двигаются вместе с исходным объектом,
+	// но со своим преобразованием
+	var still []Hittable
+	var motions []*Vec3f
+	add := func(obj Hittable, t *Transform, motion *Vec3f) {
+		if t != nil {
+			obj = NewTransformed(obj, t)
+		}
+		still = append(still, obj)
+		motions = append(motions, motion)
+		if motion != nil {
+			obj = &Moving{Object: obj, Motion: *motion}
+			s.moving = true
+		}
+		s.objects = append(s.objects, obj)
+	}
+	for i := range s.Spheres {
+		add(&s.Spheres[i], s.Spheres[i].Transform, s.Spheres[i].Motion)
+	}
+	for i := range s.Cylinders {
+		add(&s.Cylinders[i], s.Cylinders[i].Transform, s.Cylinders[i].Motion)
+	}
+	for i := range s.Cones {
+		add(&s.Cones[i], s.Cones[i].Transform, s.Cones[i].Motion)
+	}
+	for i := range s.Tori {
+		add(&s.Tori[i], s.Tori[i].Transform, s.Tori[i].Motion)
+	}
+	for i := range s.Planes {
+		add(&s.Planes[i], nil, nil)
+	}
+	primitives := len(s.objects)
+	for i := range s.Instances {
+		if k := s.Instances[i].Object; k >= 0 && k < primitives {
+			t := &s.Instances[i].Transform
+			var motion *Vec3f
+			if motions[k] != nil {
+				toWorld, _ := t.matrices()
+				m := toWorld.Dir(*motions[k])
+				motion = &m
+			}
+			add(still[k], t, motion)
+		}
+	}
+
+	s.boxes = make([]AABB, len(s.objects))
+	for i, obj := range s.objects {
+		s.boxes[i] = obj.bounds()
+	}
+	s.epsilon = s.Epsilon.resolve(s.boxes)
+	s.packed, s.rest = nil, nil
+	if intersectKernel == "packed" {
+		s.packed = &packedSpheres{}
+		s.rest = s.packed.pack(s.objects)
+	}
+
+	s.lights = s.lights[:0:0]
+	for i := range s.Lights {
+		s.lights = append(s.lights, &s.Lights[i])
+	}
+	for i := range s.DirectionalLights {
+		s.lights = append(s.lights, &s.DirectionalLights[i])
+	}
+	for i := range s.AmbientLights {
+		s.lights = append(s.lights, &s.AmbientLights[i])
+	}
+	for i := range s.DomeLights {
+		s.lights = append(s.lights, &s.DomeLights[i])
+	}
+}
+
+// checkNoFiles проверяет, что сцена не ссылается на файлы.
+func (s *Scene) checkNoFiles() error {
+	if s.EnvMap != "" {
+		return fmt.Errorf("envmap: scene may not reference files")
+	}
+	for i, obj := range s.objects[:s.primitiveCount()] {
+		if m := obj.material(); m.Texture != "" || m.NormalMap != "" {
+			return fmt.Errorf("object %d: scene may not reference files", i)
+		}
+	}
+	return nil
+}
+
+// checkFilesUnder проверяет, что все файлы, на которые ссылается сцена с
+// каталогом ресурсов dir, лежат внутри каталога root.
+func (s *Scene) checkFilesUnder(dir, root string) error {
+	check := func(name string) error {
+		if name == "" {
+			return nil
+		}
+		path := resolvePath(dir, name)
+		// Ссылка внутри root может вести наружу
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			path = real
+		}
+		if !pathUnder(path, root) {
+			return fmt.Errorf("%s: file outside %s", name, root)
+		}
+		return nil
+	}
+	if err := check(s.EnvMap); err != nil {
+		return fmt.Errorf("envmap: %w", err)
+	}
+	for i, obj := range s.objects[:s.primitiveCount()] {
+		m := obj.material()
+		for _, name := range []string{m.Texture, m.NormalMap} {
+			if err := check(name); err != nil {
+				return fmt.Errorf("object %d: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// pathUnder сообщает, что путь path лежит внутри каталога root. Оба пути
+// должны быть абсолютными.
+func pathUnder(path, root string) bool {
+	if !filepath.IsAbs(path) || !filepath.IsAbs(root) {
+		return false
+	}
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// primitiveCount возвращает число примитивов сцены без учета повторов.
+func (s *Scene) primitiveCount() int {
+	return len(s.Spheres) + len(s.Cylinders) + len(s.Cones) + len(s.Tori) + len(s.Planes)
+}
+
+// resolvePath возвращает путь к ресурсу относительно каталога сцены.
+func resolvePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// withStats возвращает копию сцены, считающую лучи и проверки пересечений в stats.
+func (s *Scene) withStats(stats *renderStats) *Scene {
+	out := *s
+	out.stats = stats
+	return &out
+}
+
+// withBruteForce возвращает копию сцены, в которой лучи проверяются со
+// всеми объектами подряд: без ядра "packed" и отсечения по параллелепипедам.
+// Медленно, но не зависит от ускоряющих структур (см. isolate).
+func (s *Scene) withBruteForce() *Scene {
+	out := *s
+	out.packed, out.rest = nil, nil
+	out.brute = true
+	return &out
+}
+
+// withProbe возвращает копию сцены, отмечающую в probe, куда дошли лучи.
+// probe не защищен от гонок: копия принадлежит одной горутине рендера.
+func (s *Scene) withProbe(probe *rayProbe) *Scene {
+	out := *s
+	out.probe = probe
+	return &out
+}
+
+// background возвращает цвет фона для луча, не попавшего ни в один объект.
+// primary - луч выпущен камерой; вторичные лучи (отражения, отскоки) могут
+// видеть другой фон, см. CameraBackground и SecondaryBackground.
+func (s *Scene) background(dir Vec3f, primary bool) Vec3f {
+	switch {
+	case primary && s.CameraBackground != nil:
+		return *s.CameraBackground
+	case !primary && s.SecondaryBackground != nil:
+		return *s.SecondaryBackground
+	}
+	if s.envMap != nil {
+		return s.envMap.Sample(dir)
+	}
+	return s.Background
+}
+
+// reflectLimit возвращает дальность отражений для материала.
+func (s *Scene) reflectLimit(m *Material) float64 {
+	if m.MaxReflectDistance > 0 {
+		return m.MaxReflectDistance
+	}
+	if s.MaxReflectDistance > 0 {
+		return s.MaxReflectDistance
+	}
+	return math.Inf(1)
+}
+
+// intersect находит ближайший объект, который пересекает луч r, и
+// расстояние до него. Объекты, в параллелепипед которых луч не попадает
+// ближе уже найденного пересечения, не проверяются.
+func (s *Scene) intersect(r Ray) (Hittable, float64) {
+	orig, offset := r.start()
+	closestDist := r.TMax - offset
+	var hitObj Hittable
+	invDir := Vec3f{1 / r.Dir.X, 1 / r.Dir.Y, 1 / r.Dir.Z}
+	tests := 0
+	test := func(i int) {
+		if !s.brute && !s.boxes[i].hitRay(orig, invDir, closestDist) {
+			return
+		}
+		tests++
+		hit, dist := s.objects[i].RayIntersect(orig, r.Dir)
+		if hit && dist < closestDist {
+			closestDist = dist
+			hitObj = s.objects[i]
+		}
+	}
+	if s.packed != nil {
+		var k int
+		if k, closestDist = s.packed.nearest(orig, r.Dir, closestDist); k >= 0 {
+			hitObj = s.objects[s.packed.objects[k]]
+		}
+		tests += len(s.packed.objects)
+		for _, i := range s.rest {
+			test(i)
+		}
+	} else {
+		for i := range s.objects {
+			test(i)
+		}
+	}
+	s.countRay(false, tests)
+	s.probeSegment(orig, r.Dir, closestDist)
+	return hitObj, closestDist + offset
+}
+
+// lightNorm возвращает множитель интенсивностей источников: с
+// NormalizeLights он приводит их суммарную интенсивность к единице, иначе
+// равен единице.
+func (s *Scene) lightNorm() float64 {
+	if !s.NormalizeLights {
+		return 1
+	}
+	total := 0.0
+	for _, light := range s.lights {
+		total += light.power()
+	}
+	if total <= 0 {
+		return 1
+	}
+	return 1 / total
+}
+
+// illuminate вычисляет диффузный и бликовый цвет прямого освещения
+// в точке point с нормалью N для луча, пришедшего по направлению dir.
+// При single протяженные источники сэмплируются одним теневым лучом -
+// так делает трассировка путей, усредняющая результат по сэмплам пикселя.
+//
+// Интенсивности источников абсолютные: вклад каждого не зависит от
+// остальных. С NormalizeLights они нормируются на свою сумму, и у белых
+// источников без ослабления каждая из составляющих лежит в [0, 1] при
+// любом числе и яркости источников. Яркость кадра поверх этого задается
+// экспозицией (RenderOptions.Exposure).
+func (s *Scene) illuminate(point, N, dir Vec3f, m *Material, single bool, rng *rand.Rand) (diffuse, specular Vec3f) {
+	q := &lightQuery{point: point, N: N, dir: dir, specularExponent: m.SpecularExponent, alpha: m.ggxAlpha(), single: single, scale: s.lightNorm()}
+	return s.directLight(q, rng)
+}
+
+// directLight суммирует вклад всех источников света в точку q.
+func (s *Scene) directLight(q *lightQuery, rng *rand.Rand) (diffuse, specular Vec3f) {
+	for _, light := range s.lights {
+		if s.solo != nil && light != s.solo {
+			continue
+		}
+		d, sp := light.illuminate(s, q, rng)
+		diffuse = diffuse.Add(d)
+		specular = specular.Add(sp)
+	}
+	return diffuse, specular
+}
+
+// shadowRatio возвращает долю прямого диффузного освещения, доходящую до
+// точки point с нормалью N с учетом теней: 1 - тени нет, 0 - полная тень.
+func (s *Scene) shadowRatio(point, N Vec3f, rng *rand.Rand) float64 {
+	q := &lightQuery{point: point, N: N, scale: 1}
+	lit, _ := s.directLight(q, rng)
+	q.unshadowed = true
+	full, _ := s.directLight(q, rng)
+	if luminance(full) <= 0 {
+		return 1
+	}
+	return math.Min(1, luminance(lit)/luminance(full))
+}
+
+// Occluded сообщает, закрыт ли источник света в точке light от точки
+// поверхности point каким-либо объектом сцены. Объекты за источником
+// тень не отбрасывают.
+func (s *Scene) Occluded(point, light Vec3f) bool {
+	toLight := light.Subtract(point)
+	dist := toLight.Length()
+	eps := s.epsilon.at(point)
+	if dist <= eps {
+		return false
+	}
+	return s.occluded(Ray{Origin: point, Dir: toLight.MulScalar(1 / dist), TMin: eps, TMax: dist})
+}
+
+// occluded сообщает, пересекает ли теневой луч r какой-либо объект сцены
+// на отрезке [TMin, TMax].
+func (s *Scene) occluded(r Ray) bool {
+	orig, offset := r.start()
+	tMax := r.TMax - offset
+	invDir := Vec3f{1 / r.Dir.X, 1 / r.Dir.Y, 1 / r.Dir.Z}
+	tests := 0
+	test := func(i int) bool {
+		if !s.brute && !s.boxes[i].hitRay(orig, invDir, tMax) {
+			return false
+		}
+		tests++
+		hit, dist := s.objects[i].RayIntersect(orig, r.Dir)
+		return hit && dist <= tMax
+	}
+	blocked := false
+	if s.packed != nil {
+		tests += len(s.packed.objects)
+		blocked = s.packed.any(orig, r.Dir, tMax)
+		for _, i := range s.rest {
+			if blocked {
+				break
+			}
+			blocked = test(i)
+		}
+	} else {
+		for i := range s.objects {
+			if blocked = test(i); blocked {
+				break
+			}
+		}
+	}
+	s.countRay(true, tests)
+	s.probeSegment(orig, r.Dir, tMax)
+	return blocked
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	refl "reflect"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// SceneChange - различие двух версий сцены.
+type SceneChange struct {
+	Kind string // "moved", "material", "added", "removed" или "changed"
+	Path string // Путь к полю в терминах файла сцены, например spheres[1].center
+	Old  string // Значения до и после; у добавленного значения нет Old,
+	New  string // у удаленного - New
+}
+
+// DiffScenes сравнивает сцены по полям файла сцены: объекты и источники
+// сопоставляются по номерам в своих списках. Смещения объектов, источников
+// и камеры помечаются как "moved", изменения материалов - как "material".
+func DiffScenes(a, b *Scene) []SceneChange {
+	var changes []SceneChange
+	diffValues(&changes, "", false, refl.ValueOf(a).Elem(), refl.ValueOf(b).Elem())
+	return changes
+}
+
+// movedFields - поля, изменение которых означает перемещение.
+var movedFields = []string{"center", "position", "translate"}
+
+func diffValues(changes *[]SceneChange, path string, material bool, a, b refl.Value) {
+	add := func(kind, old, new string) {
+		*changes = append(*changes, SceneChange{kind, path, old, new})
+	}
+	switch a.Kind() {
+	case refl.Pointer:
+		switch {
+		case a.IsNil() && b.IsNil():
+		case a.IsNil():
+			add("added", "", describeValue(b.Elem()))
+		case b.IsNil():
+			add("removed", describeValue(a.Elem()), "")
+		default:
+			diffValues(changes, path, material, a.Elem(), b.Elem())
+		}
+	case refl.Slice:
+		n := min(a.Len(), b.Len())
+		for i := 0; i < n; i++ {
+			diffValues(changes, fmt.Sprintf("%s[%d]", path, i), material, a.Index(i), b.Index(i))
+		}
+		for i := n; i < b.Len(); i++ {
+			*changes = append(*changes, SceneChange{"added", fmt.Sprintf("%s[%d]", path, i), "", describeValue(b.Index(i))})
+		}
+		for i := n; i < a.Len(); i++ {
+			*changes = append(*changes, SceneChange{"removed", fmt.Sprintf("%s[%d]", path, i), describeValue(a.Index(i)), ""})
+		}
+	case refl.Struct:
+		if a.Type() == refl.TypeFor[Vec3f]() {
+			if !a.Equal(b) {
+				kind := "changed"
+				if field := path[strings.LastIndex(path, ".")+1:]; slices.Contains(movedFields, field) {
+					kind = "moved"
+				}
+				if material {
+					kind = "material"
+				}
+				add(kind, describeValue(a), describeValue(b))
+			}
+			return
+		}
+		t := a.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			// Встроенная структура (Material) лежит в файле на том же уровне
+			if f.Anonymous {
+				diffValues(changes, path, material || f.Type == refl.TypeFor[Material](), a.Field(i), b.Field(i))
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			diffValues(changes, name, material, a.Field(i), b.Field(i))
+		}
+	default:
+		if !a.Equal(b) {
+			kind := "changed"
+			if material {
+				kind = "material"
+			}
+			add(kind, describeValue(a), describeValue(b))
+		}
+	}
+}
+
+// describeValue кратко описывает значение поля: у объектов и источников -
+// положение, у остальных структур - имя типа.
+func describeValue(v refl.Value) string {
+	switch v.Kind() {
+	case refl.Pointer:
+		if v.IsNil() {
+			return "none"
+		}
+		return describeValue(v.Elem())
+	case refl.String:
+		return fmt.Sprintf("%q", v.String())
+	case refl.Slice:
+		return fmt.Sprintf("%d items", v.Len())
+	case refl.Struct:
+		if c, ok := v.Interface().(Vec3f); ok {
+			return fmt.Sprintf("(%g, %g, %g)", c.X, c.Y, c.Z)
+		}
+		for _, name := range []string{"Center", "Position"} {
+			if f := v.FieldByName(name); f.IsValid() {
+				return "at " + describeValue(f)
+			}
+		}
+		return strings.ToLower(v.Type().Name())
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// printSceneDiff выводит различия таблицей.
+func printSceneDiff(w io.Writer, changes []SceneChange) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range changes {
+		switch c.Kind {
+		case "added":
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Kind, c.Path, c.New)
+		case "removed":
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Kind, c.Path, c.Old)
+		default:
+			fmt.Fprintf(tw, "%s\t%s\t%s -> %s\n", c.Kind, c.Path, c.Old, c.New)
+		}
+	}
+	tw.Flush()
+}
+
+// runSceneDiff выполняет подкоманду diff: сравнивает файлы сцен из args
+// и возвращает код завершения, как diff: 0 - сцены совпадают, 1 - есть
+// различия, 2 - ошибка.
+func runSceneDiff(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: diff sceneA.json sceneB.json")
+		return 2
+	}
+	var scenes [2]*Scene
+	for i, path := range args {
+		s, err := LoadScene(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		scenes[i] = s
+	}
+	changes := DiffScenes(scenes[0], scenes[1])
+	printSceneDiff(os.Stdout, changes)
+	if len(changes) > 0 {
+		return 1
+	}
+	return 0
+}
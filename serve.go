@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"image"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+)
+
+// Ограничения запросов сервиса рендера: один запрос не должен занимать
+// сервис надолго.
+const (
+	maxServeSide       = 4096        // Наибольшая сторона кадра
+	maxServePixels     = 2048 * 2048 // Наибольшее число пикселей кадра
+	maxServeSamples    = 4096        // Наибольшее число сэмплов на пиксель
+	maxServeWork       = 1 << 28     // Наибольшее число сэмплов на кадр
+	maxServeDepth      = 1000        // Наибольшая глубина рекурсии
+	maxServeDrop       = 1000        // Наибольшее число сфер россыпи в присланной сцене
+	maxServeLights     = 64          // Наибольшее число источников в присланной сцене
+	maxServeShadowRays = 256         // Наибольшее число теневых лучей источника
+	maxServeVolumes    = 16          // Наибольшее число объемов в присланной сцене
+	maxServeSteps      = 256         // Наибольшее число шагов интегрирования объема
+	maxServeSceneBytes = 10 << 20    // Наибольший размер присланной сцены
+)
+
+// renderService - HTTP-сервис рендера. GET /render рендерит сцену сервера,
+// POST /render - сцену в формате файла сцены из тела запроса; ответ -
+// PNG. Параметры запроса width, height, spp, integrator и seed заменяют
+// параметры командной строки. Рендеры выполняются по одному на общем пуле
+// горутин, остальные запросы ждут своей очереди.
+type renderService struct {
+	scene   *Scene        // Сцена для GET-запросов
+	opts    RenderOptions // Параметры по умолчанию
+	prepare func(*Scene)  // Переопределения командной строки для присланных сцен
+	busy    chan struct{} // Занят, пока идет рендер
+}
+
+// serveRender запускает сервис рендера на адресе addr и работает до отмены ctx.
+func serveRender(ctx context.Context, addr string, scene *Scene, opts RenderOptions, prepare func(*Scene)) error {
+	pool := NewWorkerPool(runtime.NumCPU())
+	defer pool.Close()
+	opts.Pool = pool
+	// Ответ - один PNG: файлы рядом с результатом и обработка кадра
+	// целиком из командной строки к сервису не относятся
+	opts.Progress = nil
+	opts.AOVs = nil
+	opts.Checkpoints, opts.LightPasses, opts.Denoise = false, false, false
+	opts.Region = image.Rectangle{}
+	s := &renderService{scene: scene, opts: opts, prepare: prepare, busy: make(chan struct{}, 1)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", route("/", s.handlePage, http.MethodGet))
+	mux.HandleFunc("/render", route("/render", s.handleRender, http.MethodGet, http.MethodPost))
+	srv := &http.Server{Addr: addr, Handler: mux, BaseContext: func(net.Listener) context.Context { return ctx }}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	err := srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
+
+// requestOptions возвращает параметры рендера с учетом параметров запроса q.
+func (s *renderService) requestOptions(q url.Values) (RenderOptions, error) {
+	opts := s.opts
+	opts.Width, opts.Height = opts.size()
+	ints := []struct {
+		name string
+		dst  *int
+		max  int
+	}{
+		{"width", &opts.Width, maxServeSide},
+		{"height", &opts.Height, maxServeSide},
+		{"spp", &opts.Samples, maxServeSamples},
+	}
+	for _, p := range ints {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > p.max {
+			return opts, fmt.Errorf("%s must be between 1 and %d, got %q", p.name, p.max, v)
+		}
+		*p.dst = n
+	}
+	if pixels := opts.Width * opts.Height; pixels > maxServePixels {
+		return opts, fmt.Errorf("frame %dx%d exceeds %d pixels", opts.Width, opts.Height, maxServePixels)
+	} else if work := pixels * max(1, opts.Samples, opts.MaxSamples); work > maxServeWork {
+		return opts, fmt.Errorf("frame %dx%d at %d spp exceeds %d samples", opts.Width, opts.Height, max(1, opts.Samples, opts.MaxSamples), maxServeWork)
+	}
+	if v := q.Get("integrator"); v != "" {
+		if !slices.Contains(integratorNames, v) {
+			return opts, fmt.Errorf("unknown integrator %q", v)
+		}
+		opts.Integrator = v
+	}
+	if v := q.Get("seed"); v != "" {
+		seed, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return opts, fmt.Errorf("invalid seed %q", v)
+		}
+		opts.Seed = seed
+	}
+	return opts, nil
+}
+
+// checkServeScene проверяет присланную сцену до ее разбора: число сфер
+// россыпи (она строится при разборе), число источников и их теневых лучей
+// и число объемов и их шагов - от них время рендера растет без предела.
+func checkServeScene(data []byte) error {
+	type samples struct {
+		Samples int `json:"samples"`
+	}
+	var scene struct {
+		Drop              *SphereDrop       `json:"drop"`
+		Lights            []samples         `json:"lights"`
+		DirectionalLights []json.RawMessage `json:"directionalLights"`
+		AmbientLights     []json.RawMessage `json:"ambientLights"`
+		DomeLights        []samples         `json:"domeLights"`
+		Volumes           []Volume          `json:"volumes"`
+	}
+	if err := json.Unmarshal(data, &scene); err != nil {
+		return fmt.Errorf("scene: %w", err)
+	}
+	if d := scene.Drop; d != nil && d.Count > maxServeDrop {
+		return fmt.Errorf("scene: drop count %d exceeds %d", d.Count, maxServeDrop)
+	}
+	if n := len(scene.Lights) + len(scene.DirectionalLights) + len(scene.AmbientLights) + len(scene.DomeLights); n > maxServeLights {
+		return fmt.Errorf("scene: %d lights exceed %d", n, maxServeLights)
+	}
+	for _, l := range append(scene.Lights, scene.DomeLights...) {
+		if l.Samples > maxServeShadowRays {
+			return fmt.Errorf("scene: light samples %d exceed %d", l.Samples, maxServeShadowRays)
+		}
+	}
+	if len(scene.Volumes) > maxServeVolumes {
+		return fmt.Errorf("scene: %d volumes exceed %d", len(scene.Volumes), maxServeVolumes)
+	}
+	for _, v := range scene.Volumes {
+		if v.Steps > maxServeSteps {
+			return fmt.Errorf("scene: volume steps %d exceed %d", v.Steps, maxServeSteps)
+		}
+	}
+	return nil
+}
+
+func (s *renderService) handleRender(w http.ResponseWriter, r *http.Request) {
+	opts, err := s.requestOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scene := s.scene
+	if r.Method == http.MethodPost {
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxServeSceneBytes))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := checkServeScene(data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Присланная сцена не может читать файлы сервера
+		if scene, err = parseScene(data, "scene", "", ""); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.prepare(scene)
+		opts.Scene = "POST /render"
+	}
+
+	select {
+	case s.busy <- struct{}{}:
+		defer func() { <-s.busy }()
+	case <-r.Context().Done():
+		return
+	}
+	res, err := Render(r.Context(), scene, opts)
+	if err != nil {
+		return // Клиент отключился
+	}
+	opts.Output, opts.Sink = "render.png", ResponseSink{w}
+	if err := res.save(opts); err != nil {
+		// Заголовки уже могли уйти клиенту, поэтому ошибка только выводится
+		fmt.Fprintln(os.Stderr, "serve:", err)
+	}
+}
+
+func (s *renderService) handlePage(w http.ResponseWriter, r *http.Request) {
+	opts, err := s.requestOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scene, err := json.MarshalIndent(s.scene, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	servePage.Execute(w, struct {
+		Opts        RenderOptions
+		Integrators []string
+		Scene       string
+	}{opts, integratorNames, string(scene)})
+}
+
+// servePage - страница сервиса: параметры рендера и редактор сцены. Кнопка
+// отправляет сцену из редактора POST-запросом и показывает результат.
+var servePage = template.Must(template.New("serve").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>render</title>
+<style>body{font:14px sans-serif}textarea{width:100%;height:320px;font:12px monospace}input{width:80px}</style>
+</head><body>
+<form id="params">
+width <input name="width" type="number" min="1" max="4096" value="{{.Opts.Width}}">
+height <input name="height" type="number" min="1" max="4096" value="{{.Opts.Height}}">
+spp <input name="spp" type="number" min="1" max="4096" value="{{.Opts.Samples}}">
+integrator <select name="integrator">{{range .Integrators}}<option{{if eq . $.Opts.Integrator}} selected{{end}}>{{.}}</option>{{end}}</select>
+<button type="submit">render</button> <span id="status"></span>
+<p><textarea id="scene">{{.Scene}}</textarea></p>
+</form>
+<img id="result">
+<script>
+const form = document.getElementById("params");
+const status = document.getElementById("status");
+form.addEventListener("submit", async e => {
+	e.preventDefault();
+	status.textContent = "rendering...";
+	const query = new URLSearchParams(new FormData(form));
+	const resp = await fetch("render?" + query, {method: "POST", body: document.getElementById("scene").value});
+	if (!resp.ok) {
+		status.textContent = await resp.text();
+		return;
+	}
+	document.getElementById("result").src = URL.createObjectURL(await resp.blob());
+	status.textContent = "";
+});
+</script>
+</body></html>
+`))
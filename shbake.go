@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+)
+
+// shCoeffs - число коэффициентов сферических гармоник до второго порядка.
+const shCoeffs = 9
+
+// shChunk - число лучей пробы, которые трассирует одно задание пула.
+const shChunk = 256
+
+// shBasis возвращает вещественные сферические гармоники до второго порядка
+// в направлении d (единичный вектор) в порядке (l, m): (0,0), (1,-1),
+// (1,0), (1,1), (2,-2), (2,-1), (2,0), (2,1), (2,2).
+func shBasis(d Vec3f) [shCoeffs]float64 {
+	return [shCoeffs]float64{
+		0.282095,
+		0.488603 * d.Y,
+		0.488603 * d.Z,
+		0.488603 * d.X,
+		1.092548 * d.X * d.Y,
+		1.092548 * d.Y * d.Z,
+		0.315392 * (3*d.Z*d.Z - 1),
+		1.092548 * d.X * d.Z,
+		0.546274 * (d.X*d.X - d.Y*d.Y),
+	}
+}
+
+// shCosine - свертка с косинусом для каждого порядка: переводит
+// коэффициенты яркости окружения в коэффициенты освещенности (Рамамурти,
+// Ханрахан).
+var shCosine = [3]float64{math.Pi, 2 * math.Pi / 3, math.Pi / 4}
+
+// SHBakeOptions - параметры запекания освещенности.
+type SHBakeOptions struct {
+	Samples    int    // Число лучей на пробу
+	Depth      int    // Глубина рекурсии лучей
+	Integrator string // Интегратор, которым оценивается яркость лучей
+	Seed       uint64 // Зерно генератора случайных чисел
+}
+
+// SHProbe - освещенность объекта в виде коэффициентов сферических гармоник.
+// Освещенность поверхности с нормалью n - сумма Irradiance[k] * Y_k(n) (см.
+// shBasis); ламбертова поверхность цвета c отражает c * E(n) / Pi.
+type SHProbe struct {
+	Object     int             `json:"object"` // Номер объекта: примитивы, затем повторы, как в Scene.objects
+	Kind       string          `json:"kind"`   // Тип объекта: sphere, cylinder, cone, torus, plane или instance
+	Position   Vec3f           `json:"position"`
+	Irradiance [shCoeffs]Vec3f `json:"irradiance"`
+}
+
+// SHBake - результат запекания: по пробе на каждый объект сцены.
+type SHBake struct {
+	Order   int       `json:"order"` // Наибольший порядок гармоник
+	Samples int       `json:"samples"`
+	Probes  []SHProbe `json:"probes"`
+}
+
+// BakeSH запекает освещенность объектов сцены для простых просмотрщиков
+// реального времени. Проба ставится в центр объекта (у плоскости - в ее
+// точку Center) и собирает яркость по равномерно распределенным
+// направлениям; сам объект при этом скрыт, чтобы проба видела окружение, а
+// не его изнанку. Лучи проб видят фон так же, как вторичные лучи рендера.
+// Результат не зависит от числа горутин.
+func BakeSH(ctx context.Context, scene *Scene, opts SHBakeOptions) (*SHBake, error) {
+	samples := max(1, opts.Samples)
+	trace := newIntegrator(opts.Integrator, 0)
+	chunks := (samples + shChunk - 1) / shChunk
+	bake := &SHBake{Order: 2, Samples: samples, Probes: make([]SHProbe, len(scene.objects))}
+	sums := make([][shCoeffs]Vec3f, len(scene.objects)*chunks)
+
+	pool := NewWorkerPool(runtime.NumCPU())
+	var wg sync.WaitGroup
+	for k := range scene.objects {
+		bake.Probes[k] = scene.shProbe(k)
+		probeScene := scene.without(k)
+		for c := 0; c < chunks && ctx.Err() == nil; c++ {
+			wg.Add(1)
+			pool.Submit(func() {
+				defer wg.Done()
+				rng := rand.New(rand.NewPCG(opts.Seed, uint64(k)<<32|uint64(c)))
+				sum := &sums[k*chunks+c]
+				for range min(shChunk, samples-c*shChunk) {
+					z := 1 - 2*rng.Float64()
+					r := math.Sqrt(math.Max(0, 1-z*z))
+					phi := 2 * math.Pi * rng.Float64()
+					dir := Vec3f{r * math.Cos(phi), r * math.Sin(phi), z}
+					ray := Ray{Origin: bake.Probes[k].Position, Dir: dir, TMax: math.Inf(1)}
+					radiance := trace(ray, probeScene, opts.Depth, rng)
+					for i, y := range shBasis(dir) {
+						sum[i] = sum[i].Add(radiance.MulScalar(y))
+					}
+				}
+			})
+		}
+	}
+	wg.Wait()
+	pool.Close()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Сумма по направлениям с плотностью 1 / 4Pi, свернутая с косинусом
+	for k := range bake.Probes {
+		for c := 0; c < chunks; c++ {
+			for i, s := range sums[k*chunks+c] {
+				bake.Probes[k].Irradiance[i] = bake.Probes[k].Irradiance[i].Add(s)
+			}
+		}
+		for i := range bake.Probes[k].Irradiance {
+			l := int(math.Sqrt(float64(i)))
+			scale := 4 * math.Pi / float64(samples) * shCosine[l]
+			bake.Probes[k].Irradiance[i] = bake.Probes[k].Irradiance[i].MulScalar(scale)
+		}
+	}
+	return bake, nil
+}
+
+// shProbe возвращает пробу объекта k без коэффициентов. Повтор ставит
+// пробу туда же, куда его преобразование переносит пробу исходного объекта.
+func (s *Scene) shProbe(k int) SHProbe {
+	if primitives := s.primitiveCount(); k >= primitives {
+		inst := &s.Instances[k-primitives]
+		toWorld, _ := inst.Transform.matrices()
+		return SHProbe{Object: k, Kind: "instance", Position: toWorld.Point(s.shProbe(inst.Object).Position)}
+	}
+	kinds := []struct {
+		name  string
+		count int
+	}{{"sphere", len(s.Spheres)}, {"cylinder", len(s.Cylinders)}, {"cone", len(s.Cones)}, {"torus", len(s.Tori)}}
+	i := k
+	for _, kind := range kinds {
+		if i < kind.count {
+			box := s.boxes[k]
+			return SHProbe{Object: k, Kind: kind.name, Position: box.Min.Add(box.Max).MulScalar(0.5)}
+		}
+		i -= kind.count
+	}
+	return SHProbe{Object: k, Kind: "plane", Position: s.Planes[i].Center}
+}
+
+// without возвращает копию сцены без объекта k, в которой первичные лучи
+// видят тот же фон, что и вторичные.
+func (s *Scene) without(k int) *Scene {
+	out := *s
+	out.objects = append(s.objects[:k:k], s.objects[k+1:]...)
+	out.boxes = append(s.boxes[:k:k], s.boxes[k+1:]...)
+	out.packed, out.rest = nil, nil
+	out.CameraBackground = s.SecondaryBackground
+	return &out
+}
+
+// Encode записывает результат запекания в JSON.
+func (b *SHBake) Encode(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(b)
+}
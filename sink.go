@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Sink - место, куда сохраняются файлы результата: имя файла (opts.Output и
+// производные от него имена проходов и кадров) выбирает формат, а Sink -
+// куда попадут байты. Рендер сам файлов не создает.
+type Sink interface {
+	// Create открывает файл name на запись. Файл считается сохраненным
+	// после успешного Close. Если файл реализует aborter, при ошибке записи
+	// вместо Close вызывается Abort.
+	Create(name string) (io.WriteCloser, error)
+}
+
+// aborter - файл Sink, запись которого можно отменить: недописанный файл
+// не должен сохраниться, загрузиться или подменить прежний.
+type aborter interface {
+	Abort()
+}
+
+// FileSink сохраняет файлы на диск.
+type FileSink struct{}
+
+func (FileSink) Create(name string) (io.WriteCloser, error) {
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return diskFile{file}, nil
+}
+
+// diskFile - файл FileSink; при отмене недописанный файл удаляется.
+type diskFile struct {
+	*os.File
+}
+
+func (f diskFile) Abort() {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// StdoutSink пишет все файлы подряд в стандартный вывод.
+type StdoutSink struct{}
+
+func (StdoutSink) Create(string) (io.WriteCloser, error) {
+	return nopWriteCloser{os.Stdout}, nil
+}
+
+// ResponseSink пишет файл в HTTP-ответ с типом, выбранным по расширению имени.
+type ResponseSink struct {
+	W http.ResponseWriter
+}
+
+func (s ResponseSink) Create(name string) (io.WriteCloser, error) {
+	if typ := mime.TypeByExtension(filepath.Ext(name)); typ != "" {
+		s.W.Header().Set("Content-Type", typ)
+	}
+	return nopWriteCloser{s.W}, nil
+}
+
+// MemorySink хранит файлы в памяти по именам.
+type MemorySink struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (s *MemorySink) Create(name string) (io.WriteCloser, error) {
+	return &memoryFile{sink: s, name: name}, nil
+}
+
+// File возвращает содержимое сохраненного файла name.
+func (s *MemorySink) File(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[name]
+	return data, ok
+}
+
+// memoryFile - файл MemorySink, попадающий в него при Close.
+type memoryFile struct {
+	bytes.Buffer
+	sink *MemorySink
+	name string
+}
+
+func (f *memoryFile) Close() error {
+	f.sink.mu.Lock()
+	defer f.sink.mu.Unlock()
+	if f.sink.files == nil {
+		f.sink.files = make(map[string][]byte)
+	}
+	f.sink.files[f.name] = f.Bytes()
+	return nil
+}
+
+func (f *memoryFile) Abort() {}
+
+// UploadSink загружает файлы запросом PUT на адрес Base с путем, замененным
+// на имя файла. Адрес с параметрами запроса считается подписанной ссылкой
+// облачного хранилища (S3, GCS): подпись действительна только для пути
+// самой ссылки, поэтому файлы с другими именами (проходы, кадры) не
+// загружаются.
+type UploadSink struct {
+	Base   *url.URL
+	Client *http.Client // nil - http.DefaultClient
+}
+
+// presigned сообщает, что адрес Base - подписанная ссылка на один файл.
+func (s UploadSink) presigned() bool {
+	return s.Base.RawQuery != ""
+}
+
+func (s UploadSink) Create(name string) (io.WriteCloser, error) {
+	u := *s.Base
+	if s.presigned() && name != u.Path {
+		return nil, fmt.Errorf("upload %s: presigned URL is valid only for %s", name, u.Path)
+	}
+	u.Path = name
+	return &uploadFile{sink: s, url: u.String()}, nil
+}
+
+// uploadFile - файл UploadSink, загружаемый при Close; при отмене ничего
+// не загружается.
+type uploadFile struct {
+	bytes.Buffer
+	sink UploadSink
+	url  string
+}
+
+func (f *uploadFile) Close() error {
+	req, err := http.NewRequest(http.MethodPut, f.url, &f.Buffer)
+	if err != nil {
+		return err
+	}
+	if typ := mime.TypeByExtension(path.Ext(req.URL.Path)); typ != "" {
+		req.Header.Set("Content-Type", typ)
+	}
+	client := f.sink.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("upload %s: %s", req.URL.Redacted(), resp.Status)
+	}
+	return nil
+}
+
+func (f *uploadFile) Abort() {}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// parseDestination разбирает место назначения -out и возвращает Sink и имя
+// файла результата:
+//
+//	result.png, file:///tmp/result.png - файл на диске
+//	-, stdout:result.jpg - стандартный вывод (для - в формате PNG)
+//	https://bucket.example.com/result.png?... - загрузка запросом PUT
+func parseDestination(dest string) (Sink, string, error) {
+	switch {
+	case dest == "-":
+		return StdoutSink{}, "stdout.png", nil
+	case strings.HasPrefix(dest, "stdout:"):
+		return StdoutSink{}, strings.TrimPrefix(dest, "stdout:"), nil
+	case strings.HasPrefix(dest, "file://"):
+		u, err := url.Parse(dest)
+		if err != nil {
+			return nil, "", err
+		}
+		return FileSink{}, u.Path, nil
+	case strings.HasPrefix(dest, "http://"), strings.HasPrefix(dest, "https://"):
+		u, err := url.Parse(dest)
+		if err != nil {
+			return nil, "", err
+		}
+		return UploadSink{Base: u}, u.Path, nil
+	}
+	return FileSink{}, dest, nil
+}
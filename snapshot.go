@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// snapshotMagic - начало файла снимка сцены, по которому LoadScene
+// отличает снимок от JSON-файла сцены.
+const snapshotMagic = "GORTSNAP"
+
+// snapshotVersion - версия формата снимка; снимки других версий не читаются.
+const snapshotVersion = 1
+
+// sceneSnapshot - содержимое снимка сцены (см. WriteSnapshot).
+type sceneSnapshot struct {
+	Version  int
+	Scene    *Scene
+	Textures []snapshotImage // Раскодированные текстуры, каждая по одному разу
+	// Номера текстуры и карты нормалей в Textures для каждого примитива
+	// (-1 - нет) в порядке Scene.objects
+	Maps   [][2]int
+	EnvMap *snapshotImage
+}
+
+// snapshotImage - раскодированное изображение (текстура или карта
+// окружения) в снимке.
+type snapshotImage struct {
+	Width, Height int
+	Color         snapshotChannels  // Каналы R, G, B подряд для каждого пикселя
+	Alpha         *snapshotChannels // nil - изображение непрозрачно
+}
+
+// snapshotChannels - значения каналов в самом узком виде, из которого они
+// восстанавливаются без потерь: Bytes байт на значение. 1 и 2 - целые
+// уровни 8- и 16-битного изображения (v = n * 257 / 0xffff и n / 0xffff,
+// как при декодировании), 4 и 8 - float32 и float64.
+type snapshotChannels struct {
+	Bytes int
+	Data  []byte
+}
+
+// packChannels упаковывает значения каналов.
+func packChannels(values []float64) snapshotChannels {
+	size := 1
+	for _, v := range values {
+		q := math.Round(v * 0xffff)
+		switch {
+		case q >= 0 && q <= 0xffff && q/0xffff == v && int(q)%257 == 0:
+		case q >= 0 && q <= 0xffff && q/0xffff == v:
+			size = max(size, 2)
+		case float64(float32(v)) == v:
+			size = max(size, 4)
+		default:
+			size = 8
+		}
+	}
+	data := make([]byte, 0, len(values)*size)
+	for _, v := range values {
+		switch size {
+		case 1:
+			data = append(data, byte(math.Round(v*0xffff)/257))
+		case 2:
+			data = binary.LittleEndian.AppendUint16(data, uint16(math.Round(v*0xffff)))
+		case 4:
+			data = binary.LittleEndian.AppendUint32(data, math.Float32bits(float32(v)))
+		default:
+			data = binary.LittleEndian.AppendUint64(data, math.Float64bits(v))
+		}
+	}
+	return snapshotChannels{Bytes: size, Data: data}
+}
+
+// check проверяет, что в каналах ровно n значений.
+func (c *snapshotChannels) check(n int) error {
+	if c.Bytes != 1 && c.Bytes != 2 && c.Bytes != 4 && c.Bytes != 8 || len(c.Data) != n*c.Bytes {
+		return errors.New("snapshot: corrupt image data")
+	}
+	return nil
+}
+
+// value возвращает значение номер i.
+func (c *snapshotChannels) value(i int) float64 {
+	d := c.Data[i*c.Bytes:]
+	switch c.Bytes {
+	case 1:
+		return float64(uint16(d[0])*257) / 0xffff
+	case 2:
+		return float64(binary.LittleEndian.Uint16(d)) / 0xffff
+	case 4:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(d)))
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(d))
+}
+
+// packImage упаковывает изображение width x height с цветами pixels и
+// непрозрачностью alpha (nil - непрозрачно).
+func packImage(width, height int, pixels []Vec3f, alpha []float64) snapshotImage {
+	color := make([]float64, 0, 3*len(pixels))
+	for _, p := range pixels {
+		color = append(color, p.X, p.Y, p.Z)
+	}
+	img := snapshotImage{Width: width, Height: height, Color: packChannels(color)}
+	for _, a := range alpha {
+		if a != 1 {
+			packed := packChannels(alpha)
+			img.Alpha = &packed
+			break
+		}
+	}
+	return img
+}
+
+// unpack восстанавливает цвета и непрозрачность пикселей изображения.
+func (img *snapshotImage) unpack() ([]Vec3f, []float64, error) {
+	if img.Width < 0 || img.Height < 0 {
+		return nil, nil, errors.New("snapshot: corrupt image size")
+	}
+	n := img.Width * img.Height
+	if err := img.Color.check(3 * n); err != nil {
+		return nil, nil, err
+	}
+	if img.Alpha != nil {
+		if err := img.Alpha.check(n); err != nil {
+			return nil, nil, err
+		}
+	}
+	pixels, alpha := make([]Vec3f, n), make([]float64, n)
+	for i := range pixels {
+		pixels[i] = Vec3f{img.Color.value(3 * i), img.Color.value(3*i + 1), img.Color.value(3*i + 2)}
+		alpha[i] = 1
+		if img.Alpha != nil {
+			alpha[i] = img.Alpha.value(i)
+		}
+	}
+	return pixels, alpha, nil
+}
+
+// WriteSnapshot записывает подготовленную сцену в компактном двоичном
+// виде (gob): вместе с описанием сцены сохраняются уже раскодированные
+// текстуры и карта окружения. Снимок загружается без разбора JSON,
+// проверок и декодирования изображений - сцены без больших текстур за
+// миллисекунды, - поэтому годится для
+// повторных рендеров одной сцены и для раздачи воркерам. Параллелепипеды
+// объектов и упаковка сфер строятся за линейное время и собираются заново
+// при загрузке под текущее ядро пересечений. Изображения хранятся без
+// потерь в самом узком формате каналов (см. snapshotChannels). Пути к
+// файлам ресурсов остаются такими, как в файле сцены.
+func WriteSnapshot(w io.Writer, s *Scene) error {
+	out := *s
+	out.Drop = nil // Сферы россыпи уже входят в Spheres
+	snap := sceneSnapshot{Version: snapshotVersion, Scene: &out}
+	if e := s.envMap; e != nil {
+		env := packImage(e.Width, e.Height, e.Pixels, nil)
+		snap.EnvMap = &env
+	}
+	index := map[*Texture]int{}
+	add := func(t *Texture) int {
+		if t == nil {
+			return -1
+		}
+		i, ok := index[t]
+		if !ok {
+			i = len(snap.Textures)
+			index[t] = i
+			snap.Textures = append(snap.Textures, packImage(t.Width, t.Height, t.Pixels, t.Alpha))
+		}
+		return i
+	}
+	for _, obj := range s.objects[:s.primitiveCount()] {
+		m := obj.material()
+		snap.Maps = append(snap.Maps, [2]int{add(m.texture), add(m.normalMap)})
+	}
+	if _, err := io.WriteString(w, snapshotMagic); err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(&snap)
+}
+
+// ReadSnapshot читает сцену, записанную WriteSnapshot.
+func ReadSnapshot(r io.Reader) (*Scene, error) {
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != snapshotMagic {
+		return nil, errors.New("not a scene snapshot")
+	}
+	var snap sceneSnapshot
+	if err := gob.NewDecoder(r).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+	if snap.Version != snapshotVersion {
+		return nil, fmt.Errorf("snapshot version %d, want %d", snap.Version, snapshotVersion)
+	}
+	s := snap.Scene
+	if s == nil {
+		return nil, errors.New("snapshot: no scene")
+	}
+	s.sortKeys()
+	if err := s.check(); err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+	s.build()
+	if len(snap.Maps) != s.primitiveCount() {
+		return nil, fmt.Errorf("snapshot: %d texture entries for %d objects", len(snap.Maps), s.primitiveCount())
+	}
+	textures := make([]*Texture, len(snap.Textures))
+	for i := range snap.Textures {
+		img := &snap.Textures[i]
+		pixels, alpha, err := img.unpack()
+		if err != nil {
+			return nil, err
+		}
+		textures[i] = &Texture{Width: img.Width, Height: img.Height, Pixels: pixels, Alpha: alpha}
+	}
+	texture := func(i int) (*Texture, error) {
+		if i < 0 {
+			return nil, nil
+		}
+		if i >= len(textures) {
+			return nil, fmt.Errorf("snapshot: no texture %d", i)
+		}
+		return textures[i], nil
+	}
+	var err error
+	for k, obj := range s.objects[:s.primitiveCount()] {
+		m := obj.material()
+		if err := m.check(); err != nil {
+			return nil, fmt.Errorf("snapshot: object %d: %w", k, err)
+		}
+		if m.texture, err = texture(snap.Maps[k][0]); err != nil {
+			return nil, err
+		}
+		if m.normalMap, err = texture(snap.Maps[k][1]); err != nil {
+			return nil, err
+		}
+	}
+	if img := snap.EnvMap; img != nil {
+		pixels, _, err := img.unpack()
+		if err != nil {
+			return nil, err
+		}
+		s.envMap = &EnvMap{Width: img.Width, Height: img.Height, Pixels: pixels}
+	}
+	return s, nil
+}
+
+// isSnapshot сообщает, что данные файла - снимок сцены.
+func isSnapshot(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(snapshotMagic))
+}
+
+// SaveSnapshot сохраняет снимок сцены в файл path.
+func (s *Scene) SaveSnapshot(path string) error {
+	return writeFile(FileSink{}, path, func(w io.Writer) error { return WriteSnapshot(w, s) })
+}
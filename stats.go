@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// renderStats - счетчики, которые интеграторы пополняют во время рендера.
+type renderStats struct {
+	rays       atomic.Int64 // Все лучи: первичные, вторичные и теневые
+	shadowRays atomic.Int64 // Теневые лучи
+	tests      atomic.Int64 // Проверки пересечения луча с объектом
+	samples    atomic.Int64 // Сэмплы всех пикселей
+	// Число пикселей по глубине рекурсии, до которой дошли их лучи
+	depths []atomic.Int64
+
+	mu       sync.Mutex
+	failures []TileFailure // Участки кадра, рендер которых сорвался (см. isolate)
+}
+
+// newRenderStats возвращает счетчики рендера с глубиной рекурсии depth.
+func newRenderStats(depth int) *renderStats {
+	return &renderStats{depths: make([]atomic.Int64, max(depth, 0)+1)}
+}
+
+// countDepth учитывает пиксель, лучи которого дошли до глубины рекурсии level.
+func (s *renderStats) countDepth(level int) {
+	if level >= 0 && level < len(s.depths) {
+		s.depths[level].Add(1)
+	}
+}
+
+// rayProbe отмечает, куда дошли лучи горутины рендера (см. Scene.withProbe).
+type rayProbe struct {
+	min    int   // Наименьшая оставшаяся глубина рекурсии лучей текущего пикселя
+	volume *AABB // Если не nil - объем, который занимают отрезки лучей (см. FrameCache)
+}
+
+// reachDepth отмечает луч, выпущенный с оставшейся глубиной рекурсии depth.
+// depth <= 0 - луч оборван ограничением глубины.
+func (s *Scene) reachDepth(depth int) {
+	if s.probe != nil && depth < s.probe.min {
+		s.probe.min = max(depth, 0)
+	}
+}
+
+// countRay учитывает выпущенный луч и tests проверок пересечения, если
+// сцена рендерится со статистикой. shadow - теневой луч.
+func (s *Scene) countRay(shadow bool, tests int) {
+	if s.stats == nil {
+		return
+	}
+	s.stats.rays.Add(1)
+	if shadow {
+		s.stats.shadowRays.Add(1)
+	}
+	s.stats.tests.Add(int64(tests))
+}
+
+// RenderStats - итоговая статистика рендера.
+type RenderStats struct {
+	Rays       int64 // Все лучи: первичные, вторичные и теневые
+	ShadowRays int64 // Теневые лучи
+	Tests      int64 // Проверки пересечения луча с объектом
+	Samples    int64 // Сэмплы всех пикселей
+	// Число пикселей по глубине рекурсии, до которой дошли их лучи: 0 -
+	// только лучи камеры, последний элемент - пути, оборванные ограничением
+	// глубины (-depth)
+	Depths   []int64
+	Failures []TileFailure `json:",omitempty"`
+}
+
+// snapshot возвращает текущие значения счетчиков.
+func (s *renderStats) snapshot() RenderStats {
+	st := RenderStats{Rays: s.rays.Load(), ShadowRays: s.shadowRays.Load(), Tests: s.tests.Load(), Samples: s.samples.Load()}
+	for i := range s.depths {
+		st.Depths = append(st.Depths, s.depths[i].Load())
+	}
+	s.mu.Lock()
+	st.Failures = slices.Clone(s.failures)
+	s.mu.Unlock()
+	return st
+}
+
+// add возвращает сумму статистик двух рендеров.
+func (st RenderStats) add(o RenderStats) RenderStats {
+	depths := make([]int64, max(len(st.Depths), len(o.Depths)))
+	for i := range depths {
+		if i < len(st.Depths) {
+			depths[i] += st.Depths[i]
+		}
+		if i < len(o.Depths) {
+			depths[i] += o.Depths[i]
+		}
+	}
+	return RenderStats{Rays: st.Rays + o.Rays, ShadowRays: st.ShadowRays + o.ShadowRays, Tests: st.Tests + o.Tests,
+		Samples: st.Samples + o.Samples, Depths: depths, Failures: slices.Concat(st.Failures, o.Failures)}
+}
+
+// pixels возвращает число отрендеренных пикселей.
+func (st RenderStats) pixels() int64 {
+	var n int64
+	for _, d := range st.Depths {
+		n += d
+	}
+	return n
+}
+
+// printStats выводит статистику рендера (-stats).
+func printStats(w io.Writer, res *RenderResult) {
+	st := res.Stats
+	perSec := func(n int64) string {
+		return formatCount(float64(n)/max(res.Duration.Seconds(), 1e-9)) + "/s"
+	}
+	perRay := 0.0
+	if st.Rays > 0 {
+		perRay = float64(st.Tests) / float64(st.Rays)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "time\t%s\t\n", res.Duration.Round(time.Millisecond))
+	fmt.Fprintf(tw, "rays\t%d\t%s\n", st.Rays, perSec(st.Rays))
+	fmt.Fprintf(tw, "  camera and secondary\t%d\t\n", st.Rays-st.ShadowRays)
+	fmt.Fprintf(tw, "  shadow\t%d\t\n", st.ShadowRays)
+	fmt.Fprintf(tw, "intersection tests\t%d\t%s\n", st.Tests, perSec(st.Tests))
+	fmt.Fprintf(tw, "  per ray\t%.2f\t\n", perRay)
+	if pixels := st.pixels(); pixels > 0 {
+		fmt.Fprintf(tw, "samples\t%d\t%.2f per pixel\n", st.Samples, float64(st.Samples)/float64(pixels))
+	}
+	printDepths(tw, st.Depths)
+	tw.Flush()
+}
+
+// printDepths выводит распределение пикселей по достигнутой глубине
+// рекурсии и глубину, которой хватило бы для того же изображения.
+func printDepths(w io.Writer, depths []int64) {
+	var pixels int64
+	deepest := 0
+	for level, n := range depths {
+		pixels += n
+		if n > 0 {
+			deepest = level
+		}
+	}
+	if pixels == 0 {
+		return
+	}
+	limit := len(depths) - 1
+	fmt.Fprintf(w, "recursion depth\tpixels\tcumulative\n")
+	var sum int64
+	for level, n := range depths[:deepest+1] {
+		sum += n
+		name := strconv.Itoa(level)
+		if level == limit {
+			name += " (cut by -depth)"
+		}
+		fmt.Fprintf(w, "  %s\t%d\t%.2f%%\n", name, n, 100*float64(sum)/float64(pixels))
+	}
+	if deepest < limit {
+		fmt.Fprintf(w, "  -depth %d is enough for this frame\t\t\n", deepest+1)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import "math"
+
+// Параметры студийной постановки (-studio).
+const (
+	studioMargin      = 1.15 // Запас кадра вокруг объектов
+	studioDome        = 1.0  // Интенсивность купола
+	studioDomeSamples = 32   // Теневых лучей купола на точку
+)
+
+// studioBackground - цвет студийного фона, если у сцены нет карты окружения.
+var studioBackground = Vec3f{0.92, 0.92, 0.92}
+
+// studio превращает сцену в студийную: под объектами появляется пол - ловец
+// теней, сцену освещает купол неба, а камера отодвигается так, чтобы все
+// объекты поместились в кадр. Источники света сцены сохраняются.
+func (s *Scene) studio() {
+	bounds := AABB{Min: Vec3f{math.Inf(1), math.Inf(1), math.Inf(1)}, Max: Vec3f{math.Inf(-1), math.Inf(-1), math.Inf(-1)}}
+	for _, obj := range s.objects {
+		if b := obj.bounds(); b.isFinite() {
+			bounds = bounds.union(b)
+		}
+	}
+	if !bounds.isFinite() {
+		return // Нечего снимать
+	}
+	center := bounds.center()
+	radius := bounds.Max.Subtract(bounds.Min).Length() / 2
+
+	s.Planes = append(s.Planes, Plane{
+		Center:   Vec3f{center.X, bounds.Min.Y, center.Z},
+		Normal:   Vec3f{0, 1, 0},
+		Material: Material{Color: Vec3f{1, 1, 1}, Albedo: 1, ShadowCatcher: true},
+	})
+	s.DomeLights = append(s.DomeLights, DomeLight{Intensity: studioDome, Samples: studioDomeSamples})
+	if s.EnvMap == "" {
+		s.Background = studioBackground
+	}
+
+	// Камера смотрит вдоль -Z; описанная сфера объектов целиком помещается
+	// в вертикальный угол обзора (горизонтальный шире). Анимация камеры
+	// отключается, иначе она сдвинула бы камеру обратно.
+	dist := radius / math.Sin(s.Camera.fovRadians()/2) * studioMargin
+	s.Camera.Position = Vec3f{center.X, center.Y, center.Z + dist}
+	s.Camera.LookAt = nil
+	s.Camera.Animation = nil
+	if s.Camera.Aperture > 0 {
+		s.Camera.FocalDistance = dist
+	}
+	s.build()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"os"
+)
+
+// Texture - изображение с альфа-каналом, натягиваемое на поверхность по UV.
+type Texture struct {
+	Width, Height int
+	Pixels        []Vec3f   // Цвет без предумножения на альфу
+	Alpha         []float64 // Непрозрачность в диапазоне [0, 1]
+}
+
+// textureLimit - наибольшее число текселей загружаемой текстуры (0 - без
+// ограничения, см. -max-memory). Текстура крупнее уменьшается при загрузке
+// в целое число раз, по степеням двойки.
+var textureLimit int64
+
+// LoadTexture загружает текстуру из файла (PNG или JPEG).
+func LoadTexture(path string) (*Texture, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	// Каждый тексель - среднее квадрата f x f пикселей изображения
+	f := 1
+	for textureLimit > 0 && int64(bounds.Dx()/f)*int64(bounds.Dy()/f) > textureLimit && min(bounds.Dx(), bounds.Dy())/f >= 2*minTextureSide {
+		f *= 2
+	}
+	w, h := bounds.Dx()/f, bounds.Dy()/f
+	tex := &Texture{Width: w, Height: h, Pixels: make([]Vec3f, w*h), Alpha: make([]float64, w*h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum Vec3f
+			alpha := 0.0
+			for dy := 0; dy < f; dy++ {
+				for dx := 0; dx < f; dx++ {
+					c := color.NRGBA64Model.Convert(img.At(bounds.Min.X+x*f+dx, bounds.Min.Y+y*f+dy)).(color.NRGBA64)
+					sum = sum.Add(Vec3f{float64(c.R) / 0xffff, float64(c.G) / 0xffff, float64(c.B) / 0xffff})
+					alpha += float64(c.A) / 0xffff
+				}
+			}
+			tex.Pixels[y*w+x] = sum.MulScalar(1 / float64(f*f))
+			tex.Alpha[y*w+x] = alpha / float64(f*f)
+		}
+	}
+	return tex, nil
+}
+
+// index возвращает индекс ближайшего к (u, v) текселя. Координаты
+// заворачиваются, v = 0 соответствует верхней строке изображения.
+func (t *Texture) index(u, v float64) int {
+	u -= math.Floor(u)
+	v -= math.Floor(v)
+	x := min(int(u*float64(t.Width)), t.Width-1)
+	y := min(int(v*float64(t.Height)), t.Height-1)
+	return y*t.Width + x
+}
+
+// At возвращает цвет текселя в точке (u, v).
+func (t *Texture) At(u, v float64) Vec3f {
+	return t.Pixels[t.index(u, v)]
+}
+
+// AlphaAt возвращает непрозрачность текселя в точке (u, v).
+func (t *Texture) AlphaAt(u, v float64) float64 {
+	return t.Alpha[t.index(u, v)]
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"runtime"
+	"sync"
+)
+
+// tileSize - сторона тайла RenderTiles в пикселях.
+const tileSize = 32
+
+// Tile - готовый прямоугольный фрагмент кадра.
+type Tile struct {
+	X, Y          int     // Левый верхний пиксель тайла в кадре
+	Width, Height int     // У тайлов на правом и нижнем краях кадра меньше tileSize
+	Pixels        []Vec3f // Цвета по строкам, Width*Height значений
+}
+
+// RenderTiles рендерит кадр в фоне и возвращает канал, в который тайлы
+// отправляются по мере готовности, в произвольном порядке. Пиксели тайлов
+// совпадают с пикселями Render с теми же параметрами; вспомогательные
+// проходы (opts.AOVs) и уведомления о ходе рендера не поддерживаются.
+//
+// Канал закрывается, когда отправлены все тайлы или отменен ctx.
+// Получатель должен читать канал до закрытия либо отменить ctx: иначе
+// горутины рендера останутся ждать отправки.
+func RenderTiles(ctx context.Context, scene *Scene, opts RenderOptions) <-chan Tile {
+	pr := newPixelRenderer(scene.withStats(nil), opts)
+	width, height := opts.size()
+	rects := frameTiles(width, height, tileSize)
+
+	out := make(chan Tile)
+	go func() {
+		defer close(out)
+		pool := opts.Pool
+		if pool == nil {
+			pool = NewWorkerPool(runtime.NumCPU())
+			defer pool.Close()
+		}
+		var wg sync.WaitGroup
+		for _, t := range rects {
+			if ctx.Err() != nil {
+				break
+			}
+			wg.Add(1)
+			pool.Submit(func() {
+				defer wg.Done()
+				if ctx.Err() != nil {
+					return
+				}
+				t.Pixels = make([]Vec3f, t.Width*t.Height)
+				pr.isolate(t.area(), pr.newSampler(), func(s *pixelSampler) { pr.renderRows(&t, 0, t.Height, s) })
+				select {
+				case out <- t:
+				case <-ctx.Done():
+				}
+			})
+		}
+		wg.Wait()
+	}()
+	return out
+}
+
+// area возвращает название тайла для сообщений о сбоях.
+func (t *Tile) area() string {
+	return fmt.Sprintf("tile %d,%d", t.X, t.Y)
+}
+
+// frameTiles делит кадр width x height на тайлы со стороной size (без пикселей).
+func frameTiles(width, height, size int) []Tile {
+	return regionTiles(image.Rect(0, 0, width, height), size)
+}
+
+// renderRows заполняет строки тайла t с y0 до y1 (не включая).
+func (p *pixelRenderer) renderRows(t *Tile, y0, y1 int, s *pixelSampler) {
+	if p.adaptive() {
+		a := p.newAdaptiveRect(&Tile{X: t.X, Y: t.Y + y0, Width: t.Width, Height: y1 - y0})
+		for j := a.y0; j < a.y1; j++ {
+			a.sampleRow(p, j, s)
+		}
+		for y := y0; y < y1; y++ {
+			for x := 0; x < t.Width; x++ {
+				t.Pixels[y*t.Width+x] = a.refinePixel(p, t.X+x, t.Y+y, s)
+			}
+		}
+		return
+	}
+	for y := y0; y < y1; y++ {
+		for x := 0; x < t.Width; x++ {
+			t.Pixels[y*t.Width+x] = p.pixel(t.X+x, t.Y+y, s)
+		}
+	}
+}
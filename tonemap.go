@@ -0,0 +1,70 @@
+package main
+
+import (
+	"image"
+	"math"
+	"slices"
+)
+
+// toneMappers - операторы, сжимающие диапазон цвета к [0, 1].
+var toneMappers = map[string]func(Vec3f) Vec3f{
+	// clamp оставляет цвет как есть, значения выше 1 обрезаются при квантовании
+	"clamp": func(c Vec3f) Vec3f { return c },
+	// normalize делит цвет на максимальную компоненту, если она больше 1 -
+	// как в оригинальном tinyraytracer; оттенок при этом сохраняется
+	"normalize": func(c Vec3f) Vec3f {
+		if m := math.Max(c.X, math.Max(c.Y, c.Z)); m > 1 {
+			return c.MulScalar(1 / m)
+		}
+		return c
+	},
+	"reinhard": func(c Vec3f) Vec3f {
+		return Vec3f{c.X / (1 + c.X), c.Y / (1 + c.Y), c.Z / (1 + c.Z)}
+	},
+	"aces": func(c Vec3f) Vec3f {
+		return Vec3f{acesFilm(c.X), acesFilm(c.Y), acesFilm(c.Z)}
+	},
+}
+
+// toneMapperNames возвращает имена операторов в алфавитном порядке.
+func toneMapperNames() []string {
+	names := make([]string, 0, len(toneMappers))
+	for name := range toneMappers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// acesFilm - аппроксимация кривой ACES (K. Narkowicz).
+func acesFilm(x float64) float64 {
+	return math.Max(0, math.Min(1, x*(2.51*x+0.03)/(x*(2.43*x+0.59)+0.14)))
+}
+
+// linearToSRGB переводит линейное значение канала в гамму sRGB.
+func linearToSRGB(x float64) float64 {
+	if x <= 0.0031308 {
+		return 12.92 * x
+	}
+	return 1.055*math.Pow(x, 1/2.4) - 0.055
+}
+
+// postProcess применяет к буферу оператор тональной компрессии и, если
+// задано, гамма-коррекцию sRGB, и квантует результат в 8 бит.
+func postProcess(fb *Framebuffer, toneMap string, srgb bool) *image.RGBA {
+	mapper, ok := toneMappers[toneMap]
+	if !ok {
+		mapper = toneMappers["clamp"]
+	}
+	img := image.NewRGBA(image.Rect(0, 0, fb.Width, fb.Height))
+	for j := 0; j < fb.Height; j++ {
+		for i := 0; i < fb.Width; i++ {
+			c := mapper(fb.At(i, j))
+			if srgb {
+				c = Vec3f{linearToSRGB(math.Max(0, c.X)), linearToSRGB(math.Max(0, c.Y)), linearToSRGB(math.Max(0, c.Z))}
+			}
+			img.Set(i, j, colorToRGBA(c))
+		}
+	}
+	return img
+}
@@ -0,0 +1,160 @@
+package main
+
+import "math"
+
+// Mat4 - аффинное преобразование в однородных координатах (по строкам).
+type Mat4 [4][4]float64
+
+func identity() Mat4 {
+	return Mat4{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}, {0, 0, 0, 1}}
+}
+
+func translation(v Vec3f) Mat4 {
+	m := identity()
+	m[0][3], m[1][3], m[2][3] = v.X, v.Y, v.Z
+	return m
+}
+
+func scaling(v Vec3f) Mat4 {
+	m := identity()
+	m[0][0], m[1][1], m[2][2] = v.X, v.Y, v.Z
+	return m
+}
+
+// rotation возвращает поворот на angle радиан вокруг оси axis (0 - X, 1 - Y, 2 - Z).
+func rotation(axis int, angle float64) Mat4 {
+	m := identity()
+	s, c := math.Sincos(angle)
+	i, j := (axis+1)%3, (axis+2)%3
+	m[i][i], m[i][j] = c, -s
+	m[j][i], m[j][j] = s, c
+	return m
+}
+
+// Mul возвращает произведение m·o: сначала применяется o, затем m.
+func (m Mat4) Mul(o Mat4) Mat4 {
+	var out Mat4
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			for k := 0; k < 4; k++ {
+				out[i][j] += m[i][k] * o[k][j]
+			}
+		}
+	}
+	return out
+}
+
+// Point преобразует точку.
+func (m Mat4) Point(p Vec3f) Vec3f {
+	return Vec3f{
+		m[0][0]*p.X + m[0][1]*p.Y + m[0][2]*p.Z + m[0][3],
+		m[1][0]*p.X + m[1][1]*p.Y + m[1][2]*p.Z + m[1][3],
+		m[2][0]*p.X + m[2][1]*p.Y + m[2][2]*p.Z + m[2][3],
+	}
+}
+
+// Dir преобразует направление (без переноса).
+func (m Mat4) Dir(d Vec3f) Vec3f {
+	return Vec3f{
+		m[0][0]*d.X + m[0][1]*d.Y + m[0][2]*d.Z,
+		m[1][0]*d.X + m[1][1]*d.Y + m[1][2]*d.Z,
+		m[2][0]*d.X + m[2][1]*d.Y + m[2][2]*d.Z,
+	}
+}
+
+// transposedDir преобразует направление транспонированной матрицей -
+// так нормали переводятся обратной матрицей преобразования точек.
+func (m Mat4) transposedDir(d Vec3f) Vec3f {
+	return Vec3f{
+		m[0][0]*d.X + m[1][0]*d.Y + m[2][0]*d.Z,
+		m[0][1]*d.X + m[1][1]*d.Y + m[2][1]*d.Z,
+		m[0][2]*d.X + m[1][2]*d.Y + m[2][2]*d.Z,
+	}
+}
+
+// Transform задает положение объекта: масштаб, затем поворот вокруг осей
+// X, Y, Z (в градусах) и перенос.
+type Transform struct {
+	Translate Vec3f  `json:"translate"`
+	Rotate    Vec3f  `json:"rotate"`
+	Scale     *Vec3f `json:"scale,omitempty"` // nil - без масштабирования
+}
+
+// matrices возвращает матрицы перехода из системы объекта в мировую и обратно.
+func (t *Transform) matrices() (toWorld, toObject Mat4) {
+	scale := Vec3f{1, 1, 1}
+	if t.Scale != nil {
+		scale = *t.Scale
+	}
+	rad := t.Rotate.MulScalar(math.Pi / 180)
+	toWorld = translation(t.Translate).
+		Mul(rotation(2, rad.Z)).Mul(rotation(1, rad.Y)).Mul(rotation(0, rad.X)).
+		Mul(scaling(scale))
+	toObject = scaling(Vec3f{1 / scale.X, 1 / scale.Y, 1 / scale.Z}).
+		Mul(rotation(0, -rad.X)).Mul(rotation(1, -rad.Y)).Mul(rotation(2, -rad.Z)).
+		Mul(translation(t.Translate.Negate()))
+	return toWorld, toObject
+}
+
+// Transformed - объект, перенесенный в мир преобразованием. Лучи переводятся
+// в систему объекта, нормали - обратно в мировую. Несколько Transformed
+// могут ссылаться на один объект: так он повторяется в сцене без копирования.
+type Transformed struct {
+	Object            Hittable
+	toWorld, toObject Mat4
+}
+
+// NewTransformed оборачивает объект преобразованием t.
+func NewTransformed(obj Hittable, t *Transform) *Transformed {
+	toWorld, toObject := t.matrices()
+	return &Transformed{Object: obj, toWorld: toWorld, toObject: toObject}
+}
+
+func (t *Transformed) RayIntersect(orig, dir Vec3f) (bool, float64) {
+	d := t.toObject.Dir(dir)
+	scale := d.Length()
+	hit, dist := t.Object.RayIntersect(t.toObject.Point(orig), d.MulScalar(1/scale))
+	// Расстояние в системе объекта пересчитывается в мировое
+	return hit, dist / scale
+}
+
+func (t *Transformed) normalAt(point Vec3f) Vec3f {
+	n := t.Object.normalAt(t.toObject.Point(point))
+	return t.toObject.transposedDir(n).Normalize()
+}
+
+func (t *Transformed) uv(point Vec3f) (float64, float64) {
+	return objectUV(t.Object, t.toObject.Point(point))
+}
+
+// bounds возвращает параллелепипед, описанный вокруг преобразованных
+// вершин параллелепипеда объекта.
+func (t *Transformed) bounds() AABB {
+	b := t.Object.bounds()
+	inf := math.Inf(1)
+	out := AABB{Min: Vec3f{inf, inf, inf}, Max: Vec3f{-inf, -inf, -inf}}
+	for k := 0; k < 8; k++ {
+		corner := b.Min
+		if k&1 != 0 {
+			corner.X = b.Max.X
+		}
+		if k&2 != 0 {
+			corner.Y = b.Max.Y
+		}
+		if k&4 != 0 {
+			corner.Z = b.Max.Z
+		}
+		p := t.toWorld.Point(corner)
+		out.Min = Vec3f{math.Min(out.Min.X, p.X), math.Min(out.Min.Y, p.Y), math.Min(out.Min.Z, p.Z)}
+		out.Max = Vec3f{math.Max(out.Max.X, p.X), math.Max(out.Max.Y, p.Y), math.Max(out.Max.Z, p.Z)}
+	}
+	return out
+}
+
+func (t *Transformed) material() *Material { return t.Object.material() }
+
+// Instance - повтор объекта сцены с другим преобразованием.
+type Instance struct {
+	Object    int       `json:"object"` // Номер объекта: сферы, цилиндры, конусы, торы, плоскости подряд
+	Transform Transform `json:"transform"`
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// uvProjections - автоматические развертки, которые материал может задать
+// вместо собственной развертки примитива (Material.UVMapping). Проекция
+// строится по ограничивающему параллелепипеду объекта в его системе координат.
+var uvProjections = map[string]func(obj Hittable, point Vec3f) (float64, float64){
+	"spherical": sphericalUV,
+	"cubic":     cubicUV,
+	"planar":    planarUV,
+}
+
+// uvProjectionNames возвращает имена проекций в алфавитном порядке.
+func uvProjectionNames() []string {
+	names := make([]string, 0, len(uvProjections))
+	for name := range uvProjections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// objectUV возвращает текстурные координаты точки поверхности с учетом
+// развертки, заданной материалом. У преобразованного объекта проекция
+// строится в системе координат исходного объекта.
+func objectUV(obj Hittable, point Vec3f) (float64, float64) {
+	if t, ok := obj.(*Transformed); ok {
+		return t.uv(point)
+	}
+	if m, ok := obj.(*Moving); ok {
+		return m.uv(point)
+	}
+	if project := uvProjections[obj.material().UVMapping]; project != nil {
+		return project(obj, point)
+	}
+	return obj.uv(point)
+}
+
+// sphericalUV проецирует точку на сферу вокруг центра объекта
+// (долгота и широта, v = 0 сверху).
+func sphericalUV(obj Hittable, point Vec3f) (float64, float64) {
+	d := point.Subtract(obj.bounds().center())
+	if d.Length2() == 0 {
+		return 0.5, 0.5
+	}
+	d = d.Normalize()
+	return 0.5 + math.Atan2(d.Z, d.X)/(2*math.Pi), math.Acos(math.Max(-1, math.Min(1, d.Y))) / math.Pi
+}
+
+// cubicUV проецирует точку на грань параллелепипеда, к которой ближе всего
+// нормаль поверхности. Каждая грань получает всю текстуру.
+func cubicUV(obj Hittable, point Vec3f) (float64, float64) {
+	n := obj.normalAt(point)
+	axis := 0
+	if math.Abs(n.Y) > math.Abs(n.X) {
+		axis = 1
+	}
+	if math.Abs(n.Z) > math.Abs(component(n, axis)) {
+		axis = 2
+	}
+	return boxFaceUV(obj.bounds(), point, axis)
+}
+
+// planarUV проецирует точку вдоль самой короткой оси параллелепипеда объекта.
+func planarUV(obj Hittable, point Vec3f) (float64, float64) {
+	b := obj.bounds()
+	size := b.Max.Subtract(b.Min)
+	axis := 0
+	if size.Y < size.X {
+		axis = 1
+	}
+	if size.Z < component(size, axis) {
+		axis = 2
+	}
+	return boxFaceUV(b, point, axis)
+}
+
+// boxFaceUV возвращает координаты точки на грани параллелепипеда b,
+// перпендикулярной оси axis, нормированные к [0, 1] (v = 0 сверху; на
+// горизонтальной грани u идет вдоль X, v - вдоль Z).
+func boxFaceUV(b AABB, point Vec3f, axis int) (float64, float64) {
+	rel := func(k int) float64 {
+		lo, hi := component(b.Min, k), component(b.Max, k)
+		if hi <= lo {
+			return 0.5
+		}
+		return (component(point, k) - lo) / (hi - lo)
+	}
+	switch axis {
+	case 0:
+		return rel(2), 1 - rel(1)
+	case 1:
+		return rel(0), rel(2)
+	}
+	return rel(0), 1 - rel(1)
+}
+
+// component возвращает координату вектора по номеру оси.
+func component(v Vec3f, axis int) float64 {
+	switch axis {
+	case 0:
+		return v.X
+	case 1:
+		return v.Y
+	}
+	return v.Z
+}
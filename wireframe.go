@@ -0,0 +1,36 @@
+package main
+
+import "math"
+
+// Параметры наложения каркаса: объект изображается сеткой из wireSegments
+// линий по u и wireRings линий по v развертки - так, как выглядела бы его
+// триангуляция.
+const (
+	wireSegments   = 24
+	wireRings      = 12
+	wireWidth      = 0.06 // Толщина линии в долях ячейки сетки
+	wireSilhouette = 0.15 // Порог |N·dir|, ниже которого точка считается контуром
+)
+
+// wireColor - цвет линий каркаса.
+var wireColor = Vec3f{0.05, 0.05, 0.05}
+
+// wireframeEdge сообщает, попадает ли первичный луч на ребро каркаса:
+// линию параметрической сетки объекта или его контур.
+func wireframeEdge(scene *Scene, ray Ray) bool {
+	hit, ok := scene.hit(ray)
+	if !ok {
+		return false
+	}
+	if math.Abs(hit.Normal.Dot(ray.Dir)) < wireSilhouette {
+		return true
+	}
+	u, v := objectUV(hit.Object, hit.Point)
+	return nearGridLine(u*wireSegments) || nearGridLine(v*wireRings)
+}
+
+// nearGridLine сообщает, близка ли координата к целому значению.
+func nearGridLine(x float64) bool {
+	f := x - math.Floor(x)
+	return f < wireWidth/2 || f > 1-wireWidth/2
+}